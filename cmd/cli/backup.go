@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syncra/internal/client/backup"
+	"syncra/internal/config"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+)
+
+const backupUsage = `syncra backup - save the workspace to one encrypted file
+
+Usage:
+  syncra backup [-o FILE]
+
+The backup holds your identity key, chats, contacts and settings,
+encrypted with a passphrase you choose. Keep both safe: the passphrase
+cannot be recovered.
+`
+
+const restoreUsage = `syncra restore - set up this machine from a backup
+
+Usage:
+  syncra restore [-workspace DIR] [-force] FILE
+
+The workspace must not have a syncra folder yet. -force replaces this
+machine's current settings if it is already set up.
+`
+
+// minPassphrase is the shortest passphrase accepted for a new backup.
+const minPassphrase = 10
+
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, backupUsage) }
+	out := fs.String("o", "", "backup file")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil || cfg == nil {
+		return fmt.Errorf("no workspace: run syncra to set one up first")
+	}
+	path := *out
+	if path == "" {
+		path = fmt.Sprintf("syncra-%s-%s.backup", cfg.Username, time.Now().Format("20060102"))
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	passphrase, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	if len([]rune(string(passphrase))) < minPassphrase {
+		return fmt.Errorf("passphrase must be at least %d characters", minPassphrase)
+	}
+	again, err := readPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(passphrase, again) {
+		return fmt.Errorf("passphrases do not match")
+	}
+
+	var buf bytes.Buffer
+	sum, err := backup.Create(&buf, cfg, passphrase)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write backup: %v", err)
+	}
+	fmt.Printf("%s: backed up @%s, %d files\n", path, sum.Username, sum.Files)
+	return nil
+}
+
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, restoreUsage) }
+	home, _ := os.UserHomeDir()
+	workspace := fs.String("workspace", home, "workspace folder")
+	force := fs.Bool("force", false, "replace the current settings")
+	fs.Parse(args)
+	if fs.NArg() != 1 || *workspace == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if cur, err := config.LoadConfig(); err == nil && cur != nil && !*force {
+		return fmt.Errorf("this machine is set up as @%s; pass -force to replace it", cur.Username)
+	}
+	ws, err := filepath.Abs(*workspace)
+	if err != nil {
+		return err
+	}
+	if entries, err := os.ReadDir(filepath.Join(ws, "syncra")); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s: %v", ws, backup.ErrWorkspaceInUse)
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %v", err)
+	}
+	defer f.Close()
+
+	passphrase, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	cfg, sum, err := backup.Restore(f, passphrase, ws)
+	if err != nil {
+		return err
+	}
+	if err := config.SaveConfig(cfg); err != nil {
+		return fmt.Errorf("failed to save config: %v", err)
+	}
+	fmt.Printf("restored @%s into %s, %d files\n", sum.Username, filepath.Join(ws, "syncra"), sum.Files)
+	return nil
+}
+
+// readPassphrase prompts on stderr and reads without echo from a
+// terminal, or a line from piped input.
+func readPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if term.IsTerminal(os.Stdin.Fd()) {
+		p, err := term.ReadPassword(os.Stdin.Fd())
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase: %v", err)
+		}
+		return p, nil
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return nil, fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// stdin is shared so piped passphrases are read a line at a time.
+var stdin = bufio.NewReader(os.Stdin)
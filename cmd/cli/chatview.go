@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syncra/internal/models"
+	"syncra/internal/ui"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// The chat screen scrolls its messages in a viewport sized to the
+// terminal. It follows new messages while scrolled to the bottom; when
+// scrolled up it stays put and counts what arrived below instead.
+
+// chatChrome is how many lines of the chat screen are not messages:
+// header, sub header, the new-messages line, input, error and footer,
+// with their margins.
+const chatChrome = 16
+
+// Used until the first tea.WindowSizeMsg arrives.
+const (
+	defaultChatWidth  = 76
+	defaultChatHeight = 12
+)
+
+// chatWidth is the width messages wrap at: the terminal less the main
+// container's padding.
+func (m model) chatWidth() int {
+	if m.width == 0 {
+		return defaultChatWidth
+	}
+	return max(m.width-4, 20)
+}
+
+// chatHeight is the viewport height left over by the rest of the screen.
+func (m model) chatHeight() int {
+	if m.height == 0 {
+		return defaultChatHeight
+	}
+	h := m.height - chatChrome
+	if m.chatGroup != nil {
+		h-- // Member list
+	}
+	if m.thread != "" {
+		h--
+	}
+	if m.reacting || m.replyTo != "" || m.editing != "" {
+		h--
+	}
+	return max(h, 3)
+}
+
+// scrollToBottom makes the next sync jump to the newest message, as after
+// opening a chat or sending.
+func (m *model) scrollToBottom() {
+	m.viewSeen = -1
+}
+
+// syncViewport re-renders the messages into the viewport and settles the
+// scroll position: the selection is kept in view, and new messages are
+// followed only when we were at the bottom already.
+func (m *model) syncViewport() {
+	m.resolveQuotes()
+	atBottom := m.viewport.AtBottom()
+	lines := m.viewport.TotalLineCount()
+	m.viewport.Width = m.chatWidth()
+	m.viewport.Height = m.chatHeight()
+	content, spans := m.renderMessages(m.viewport.Width)
+	m.viewport.SetContent(content)
+
+	n := len(spans)
+	if m.keepScroll {
+		m.keepScroll = false
+		m.viewport.SetYOffset(m.viewport.YOffset + m.viewport.TotalLineCount() - lines)
+	}
+	switch {
+	case m.viewSeen < 0:
+		m.viewport.GotoBottom()
+	case m.msgCursor >= 0 && m.msgCursor < n && m.msgCursor != m.viewCursor:
+		top, bottom := spans[m.msgCursor][0], spans[m.msgCursor][1]
+		if top < m.viewport.YOffset {
+			m.viewport.SetYOffset(top)
+		} else if bottom >= m.viewport.YOffset+m.viewport.Height {
+			m.viewport.SetYOffset(bottom - m.viewport.Height + 1)
+		}
+	case atBottom && m.msgCursor < 0:
+		m.viewport.GotoBottom()
+	case n > m.viewSeen:
+		m.newBelow += n - m.viewSeen
+	}
+	if m.viewport.AtBottom() {
+		m.newBelow = 0
+	}
+	m.viewSeen, m.viewCursor = n, m.msgCursor
+}
+
+// renderMessages lays out the shown messages at width, with a separator
+// before each new day. spans holds the first and last line of each
+// message, quote and reactions included.
+func (m model) renderMessages(width int) (string, [][2]int) {
+	shown := m.shownMessages()
+	if len(shown) == 0 {
+		return ui.MutedStyle.Render("No messages yet. Say hello!"), nil
+	}
+
+	wrap := lipgloss.NewStyle().Width(width)
+	var lines []string
+	if m.chatOlder > 0 {
+		lines = append(lines, ui.MutedStyle.Render(lipgloss.PlaceHorizontal(width, lipgloss.Center, "↑ pgup for older messages")))
+	}
+	spans := make([][2]int, len(shown))
+	var day time.Time
+	for i, msg := range shown {
+		local := msg.Timestamp.Local()
+		if d := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.Local); !d.Equal(day) {
+			day = d
+			if len(lines) > 0 {
+				lines = append(lines, "")
+			}
+			lines = append(lines, daySeparator(d, width))
+		}
+
+		spans[i][0] = len(lines)
+		if msg.ReplyTo != "" {
+			lines = append(lines, "      "+m.quote(msg.ReplyTo))
+		}
+		line := wrap.Render(m.messageLine(msg, i == m.msgCursor))
+		lines = append(lines, strings.Split(line, "\n")...)
+		if r := m.reactionsLine(msg); r != "" {
+			lines = append(lines, "      "+r)
+		}
+		spans[i][1] = len(lines) - 1
+	}
+	return strings.Join(lines, "\n"), spans
+}
+
+// messageLine renders one message: time, sender, text and its markers.
+func (m model) messageLine(msg models.LocalChatMessage, selected bool) string {
+	prefix := lipgloss.NewStyle().Foreground(ui.Secondary).Render("@" + msg.From + ":")
+	if msg.IsMe {
+		prefix = ui.SelectedStyle.Render("You:")
+	}
+	text := msg.Content
+	if msg.Deleted {
+		text = ui.MutedStyle.Italic(true).Render("message deleted")
+	} else if msg.EditedAt != nil {
+		text += " " + ui.MutedStyle.Render("(edited)")
+	}
+	line := fmt.Sprintf("%s %s %s", ui.MutedStyle.Render(msg.Timestamp.Local().Format("15:04")), prefix, text)
+	if selected {
+		line = ui.SelectedStyle.Render("> ") + line
+	}
+	if e, queued := m.outbox[msg.ID]; queued && msg.IsMe {
+		if e.Failed {
+			line += " " + ui.ErrorTextStyle.Render("✗ failed")
+		} else {
+			line += " " + ui.MutedStyle.Render("… pending")
+		}
+	}
+	if msg.ExpiresAt != nil {
+		line += " " + ui.MutedStyle.Render("⏱")
+	}
+	return line
+}
+
+// daySeparator is a centered rule naming the day, relative when recent.
+func daySeparator(day time.Time, width int) string {
+	label := day.Format("Monday, Jan 2")
+	now := time.Now()
+	switch today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local); {
+	case day.Equal(today):
+		label = "Today"
+	case day.Equal(today.AddDate(0, 0, -1)):
+		label = "Yesterday"
+	case day.Year() != now.Year():
+		label = day.Format("Monday, Jan 2 2006")
+	}
+	return ui.MutedStyle.Render(lipgloss.PlaceHorizontal(width, lipgloss.Center, "── "+label+" ──"))
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	clientWS "syncra/internal/client/websocket"
 	"syncra/internal/config"
 	"syncra/internal/crypto"
 	"syncra/internal/models"
@@ -14,6 +17,7 @@ import (
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jackc/pgx/v5"
 )
 
 func (m model) listenWS() tea.Cmd {
@@ -22,14 +26,15 @@ func (m model) listenWS() tea.Cmd {
 			return wsErrorMsg{err: fmt.Errorf("no connection")}
 		}
 		for {
-			_, data, err := m.conn.Conn.ReadMessage()
+			p, err := m.conn.ReadPacket()
+			var decodeErr *clientWS.DecodeError
+			if errors.As(err, &decodeErr) {
+				slog.Warn("dropping malformed frame", "err", decodeErr.Err, "bytes", decodeErr.Size)
+				continue
+			}
 			if err != nil {
 				return wsErrorMsg{err: err}
 			}
-			var p models.Packet
-			if err := json.Unmarshal(data, &p); err != nil {
-				continue
-			}
 			return wsMessage{packet: p}
 		}
 	}
@@ -39,8 +44,47 @@ func (m model) pollLocalChats() tea.Cmd {
 		return wsMessage{packet: models.Packet{Type: models.TypeSystem}} // A generic ping to trigger UI update
 	})
 }
+
+// retryTick wakes the delivery tracker once a second.
+func (m model) retryTick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
+		return retryTickMsg{}
+	})
+}
+
+// purgeTick wakes the disappearing message purge.
+func (m model) purgeTick() tea.Cmd {
+	return tea.Tick(purgeInterval, func(t time.Time) tea.Msg {
+		return purgeTickMsg{}
+	})
+}
+
+// sendToRelay queues p on the live connection without blocking. Packets
+// that can't be queued now are picked up by the tracker's next retry.
+func (m model) sendToRelay(p models.Packet) bool {
+	if m.conn == nil || !m.authenticated {
+		return false
+	}
+	select {
+	case m.conn.Send <- p:
+		return true
+	default:
+		return false
+	}
+}
+
+// receipts reports whether the relay acks chat packets.
+func (m model) receipts() bool {
+	return m.conn != nil && m.conn.Protocol.Has(models.FeatureReceipts)
+}
+
+// performSetup creates a new identity, or with restoreKey set brings back
+// an existing one: the key must match the one the server holds for the
+// username, and nothing is registered.
 func (m model) performSetup() tea.Cmd {
 	return func() tea.Msg {
+		restoring := m.restoreKey != nil
+		fullName := m.tempFullName
 		var db *database.DB
 		var err error
 		if !m.isLocal {
@@ -53,19 +97,39 @@ func (m model) performSetup() tea.Cmd {
 
 			ctx := context.Background()
 
-			// 2. Validate Username uniqueness (again, just to be sure)
-			taken, err := db.IsUsernameTaken(ctx, m.tempUsername)
-			if err != nil {
-				return setupResult{err: fmt.Errorf("failed to validate username: %v", err)}
-			}
-			if taken {
-				return setupResult{err: fmt.Errorf("username already taken")}
+			if restoring {
+				// 2. The account must exist and hold this key
+				user, err := db.GetUserByUsername(ctx, m.tempUsername)
+				if errors.Is(err, pgx.ErrNoRows) {
+					return setupResult{err: fmt.Errorf("no account named '%s' on the server", m.tempUsername)}
+				}
+				if err != nil {
+					return setupResult{err: fmt.Errorf("failed to look up account: %v", err)}
+				}
+				pubHex := hex.EncodeToString(m.restoreKey.Public().(ed25519.PublicKey))
+				if user.PublicKey != pubHex {
+					return setupResult{err: fmt.Errorf("this recovery phrase is not for '%s'", m.tempUsername)}
+				}
+				fullName = user.FullName
+			} else {
+				// 2. Validate Username uniqueness (again, just to be sure)
+				taken, err := db.IsUsernameTaken(ctx, m.tempUsername)
+				if err != nil {
+					return setupResult{err: fmt.Errorf("failed to validate username: %v", err)}
+				}
+				if taken {
+					return setupResult{err: fmt.Errorf("username already taken")}
+				}
 			}
 		}
 
-		// 3. Generate Key Pair
-		pub, priv, err := crypto.GenerateKeyPair()
-		if err != nil {
+		// 3. Generate Key Pair, or take the restored one
+		var pub ed25519.PublicKey
+		var priv ed25519.PrivateKey
+		if restoring {
+			priv = m.restoreKey
+			pub = priv.Public().(ed25519.PublicKey)
+		} else if pub, priv, err = crypto.GenerateKeyPair(); err != nil {
 			return setupResult{err: fmt.Errorf("failed to generate keys: %v", err)}
 		}
 
@@ -74,8 +138,12 @@ func (m model) performSetup() tea.Cmd {
 			return setupResult{err: fmt.Errorf("failed to create folders: %v", err)}
 		}
 
-		// 5. Save Private Key locally
+		// 5. Save Private Key locally. A restore may find the workspace
+		// still there; it must not replace someone else's key.
 		keyPath := filepath.Join(m.tempWorkspace, "syncra", "identities", "id_ed25519")
+		if existing, err := crypto.LoadPrivateKey(keyPath); restoring && err == nil && !existing.Equal(priv) {
+			return setupResult{err: fmt.Errorf("%s already holds a different identity key", m.tempWorkspace)}
+		}
 		if err := crypto.SavePrivateKey(keyPath, priv); err != nil {
 			return setupResult{err: fmt.Errorf("failed to save private key: %v", err)}
 		}
@@ -83,10 +151,10 @@ func (m model) performSetup() tea.Cmd {
 		// 6. Register on Server (If not local)
 		pubHash := crypto.HashPublicKey(pub)
 		pubHex := hex.EncodeToString(pub)
-		if !m.isLocal {
+		if !m.isLocal && !restoring {
 			user := &models.User{
 				Username:      m.tempUsername,
-				FullName:      m.tempFullName,
+				FullName:      fullName,
 				PublicKey:     pubHex,
 				PublicKeyHash: pubHash,
 			}
@@ -99,13 +167,13 @@ func (m model) performSetup() tea.Cmd {
 		cfg := &config.Config{
 			WorkspacePath: m.tempWorkspace,
 			Username:      m.tempUsername,
-			FullName:      m.tempFullName,
+			FullName:      fullName,
 		}
 		if err := config.SaveConfig(cfg); err != nil {
 			return setupResult{err: fmt.Errorf("failed to save config: %v", err)}
 		}
 
-		return setupResult{cfg: cfg}
+		return setupResult{cfg: cfg, restored: restoring}
 	}
 }
 func (m model) performSearch(query string) tea.Cmd {
@@ -160,11 +228,16 @@ func (m model) performSelfDestruct() tea.Cmd {
 		// 1. Delete from Server
 		db, err := database.Connect()
 		if err == nil {
-			db.DeleteUser(context.Background(), m.cfg.Username)
+			if err := db.DeleteUser(context.Background(), m.cfg.Username); err != nil {
+				slog.Error("self-destruct: server delete failed", "err", err)
+			}
 			db.Close()
+		} else {
+			slog.Error("self-destruct: server unreachable", "err", err)
 		}
 
-		// 2. Delete local folder
+		// 2. Delete local folder (including the log file)
+		initLogging("")
 		syncraPath := filepath.Join(m.cfg.WorkspacePath, "syncra")
 		os.RemoveAll(syncraPath)
 
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"syncra/internal/client/storage"
+)
+
+// reloadChats refreshes the 1:1 chat list, sorted and filtered by the
+// contacts store.
+func (m *model) reloadChats() {
+	chats, err := storage.ListChatSummaries(m.showArchived)
+	if err != nil {
+		slog.Warn("failed to list chats", "err", err)
+		return
+	}
+	m.chats = chats
+	if contacts, err := storage.LoadContacts(); err == nil {
+		m.contacts = contacts
+	}
+	if n := len(m.chats) + len(m.groups); m.chatSelectionIndex >= n {
+		m.chatSelectionIndex = max(n-1, 0)
+	}
+}
+
+// selectedContact returns the 1:1 chat under the cursor, if any.
+func (m *model) selectedContact() (storage.ChatSummary, bool) {
+	if i := m.chatSelectionIndex; i < len(m.chats) {
+		return m.chats[i], true
+	}
+	return storage.ChatSummary{}, false
+}
+
+// updateContact changes the selected contact's settings and keeps the
+// cursor on it, wherever the new sort order puts it.
+func (m *model) updateContact(fn func(*storage.Contact)) {
+	s, ok := m.selectedContact()
+	if !ok {
+		return
+	}
+	if err := storage.UpdateContact(s.Username, fn); err != nil {
+		m.err = err
+		return
+	}
+	m.reloadChats()
+	for i, c := range m.chats {
+		if c.Username == s.Username {
+			m.chatSelectionIndex = i
+		}
+	}
+}
+
+// setNickname saves the nickname typed in stateNickname; blank clears it.
+func (m *model) setNickname(username, nickname string) {
+	nickname = strings.TrimSpace(nickname)
+	if err := storage.UpdateContact(username, func(c *storage.Contact) { c.Nickname = nickname }); err != nil {
+		m.err = err
+		return
+	}
+	m.reloadChats()
+}
+
+// displayName is the nickname we gave username, or username itself.
+func (m model) displayName(username string) string {
+	if c, ok := m.contacts[username]; ok {
+		return c.DisplayName()
+	}
+	return username
+}
+
+// markRead clears the unread count of the open 1:1 chat.
+func (m *model) markRead() {
+	if m.chatGroup != nil || m.chatTarget == "" {
+		return
+	}
+	if err := storage.MarkRead(m.chatTarget); err != nil {
+		slog.Warn("failed to mark chat read", "user", m.chatTarget, "err", err)
+	}
+}
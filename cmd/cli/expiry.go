@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"syncra/internal/client/storage"
+	"syncra/internal/models"
+	"syncra/internal/protocol"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Disappearing messages: a conversation's timer is set by either side
+// with an expiry packet and stamped on every message sent under it. Both
+// sides purge what has expired on a ticker; messages still queued for
+// delivery expire with them.
+
+// How often expired messages are purged.
+const purgeInterval = 10 * time.Second
+
+type purgeTickMsg struct{}
+type purgedMsg struct{ n int }
+
+// reloadExpiry refreshes the timers behind the chat header icon.
+func (m *model) reloadExpiry() {
+	timers, err := storage.LoadExpiry()
+	if err != nil {
+		slog.Warn("failed to load message timers", "err", err)
+		return
+	}
+	m.expiry = timers
+}
+
+// chatKey is the open chat's key in the timer store.
+func (m model) chatKey() string {
+	if m.chatGroup != nil {
+		return storage.GroupKey(m.chatTarget)
+	}
+	return m.chatTarget
+}
+
+// timer is the open chat's message lifetime, zero when off.
+func (m model) timer() time.Duration {
+	return m.expiry[m.chatKey()].TTL
+}
+
+// timerCommand handles /timer in any chat. It reports whether content was
+// one.
+func (m *model) timerCommand(content string) (bool, tea.Cmd) {
+	fields := strings.Fields(content)
+	if len(fields) == 0 || fields[0] != "/timer" {
+		return false, nil
+	}
+	if len(fields) != 2 {
+		m.err = fmt.Errorf("usage: /timer <duration, e.g. 30s, 5m, 1h, 1d, 1w> or /timer off")
+		return true, nil
+	}
+	ttl, err := parseTimer(fields[1])
+	if err != nil {
+		m.err = err
+		return true, nil
+	}
+	return true, m.setTimer(ttl)
+}
+
+// setTimer changes the open chat's timer here and for the other side.
+func (m *model) setTimer(ttl time.Duration) tea.Cmd {
+	group := m.chatGroup != nil
+	pkg, err := protocol.Expiry(m.cfg.Username, m.chatTarget, group, ttl)
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	sendCmd := m.queueOutgoing(pkg)
+	t := storage.ExpiryTimer{TTL: ttl, SetBy: m.cfg.Username, SetAt: pkg.Timestamp}
+	if _, err := storage.SetExpiry(m.chatKey(), t); err != nil {
+		slog.Error("failed to store message timer", "chat", m.chatTarget, "err", err)
+	}
+	slog.Info("message timer changed", "chat", m.chatTarget, "ttl", ttl)
+	m.reloadExpiry()
+	m.err = nil
+	return sendCmd
+}
+
+// applyExpiry stores a timer change received from the relay.
+func (m *model) applyExpiry(p models.Packet) {
+	x, err := protocol.DecodeExpiry(p)
+	if err != nil {
+		slog.Warn("invalid expiry payload", "from", p.From, "err", err)
+		return
+	}
+	storeExpiry(p, x)
+	m.reloadExpiry()
+}
+
+// storeExpiry applies a received timer change; shared with the LAN
+// receiver.
+func storeExpiry(p models.Packet, x models.ExpiryPayload) {
+	key := p.From
+	if x.Group {
+		key = storage.GroupKey(p.To)
+	}
+	t := storage.ExpiryTimer{TTL: time.Duration(x.Seconds) * time.Second, SetBy: p.From, SetAt: p.Timestamp}
+	if applied, err := storage.SetExpiry(key, t); err != nil {
+		slog.Error("failed to store message timer", "chat", key, "err", err)
+	} else if applied {
+		slog.Info("message timer changed", "chat", key, "by", p.From, "ttl", t.TTL)
+	}
+}
+
+// stampExpiry sets the lifetime of an outgoing message from the open
+// chat's timer.
+func (m model) stampExpiry(chat *models.ChatPayload, msg *models.LocalChatMessage) {
+	if ttl := m.timer(); ttl > 0 {
+		chat.Expires = int64(ttl / time.Second)
+		at := msg.Timestamp.Add(ttl)
+		msg.ExpiresAt = &at
+	}
+}
+
+// expiresAt is when a received message disappears, or nil.
+func expiresAt(p models.Packet, chat models.ChatPayload) *time.Time {
+	if chat.Expires <= 0 {
+		return nil
+	}
+	at := p.Timestamp.Add(time.Duration(chat.Expires) * time.Second)
+	return &at
+}
+
+// purgeExpired deletes expired messages from storage in the background.
+func (m model) purgeExpired() tea.Cmd {
+	return func() tea.Msg {
+		n, err := storage.PurgeExpired(time.Now())
+		if err != nil {
+			slog.Warn("failed to purge expired messages", "err", err)
+		}
+		return purgedMsg{n: n}
+	}
+}
+
+// dropExpired clears expired messages from the screen and stops trying
+// to deliver any that are still queued.
+func (m *model) dropExpired(purged int) {
+	now := time.Now()
+	for id, e := range m.outbox {
+		var chat models.ChatPayload
+		var err error
+		switch e.Packet.Type {
+		case models.TypeChat:
+			chat, err = protocol.DecodeChat(e.Packet)
+		case models.TypeGroupChat:
+			chat, err = protocol.DecodeGroupChat(e.Packet)
+		default:
+			continue
+		}
+		if err == nil && protocol.Expired(e.Packet, chat, now) {
+			slog.Debug("undelivered message expired", "to", e.Packet.To, "id", id)
+			m.delivery.Forget(id)
+			m.settle(id)
+		}
+	}
+
+	kept := m.chatMessages[:0]
+	for _, msg := range m.chatMessages {
+		if !storage.Expired(msg, now) {
+			kept = append(kept, msg)
+		}
+	}
+	if len(kept) != len(m.chatMessages) || purged > 0 {
+		m.chatMessages = kept
+		if m.state == stateChat {
+			m.reloadMessages()
+		}
+		m.reloadChats()
+	}
+}
+
+// parseTimer reads a timer setting: "off", a Go duration, or whole days
+// ("1d") or weeks ("1w").
+func parseTimer(s string) (time.Duration, error) {
+	if s == "off" || s == "0" {
+		return 0, nil
+	}
+	var ttl time.Duration
+	var err error
+	if unit := s[len(s)-1]; unit == 'd' || unit == 'w' {
+		var n int
+		n, err = strconv.Atoi(s[:len(s)-1])
+		ttl = time.Duration(n) * 24 * time.Hour
+		if unit == 'w' {
+			ttl *= 7
+		}
+	} else {
+		ttl, err = time.ParseDuration(s)
+	}
+	if err != nil || ttl < time.Second || ttl > protocol.MaxExpirySeconds*time.Second {
+		return 0, fmt.Errorf("timer must be between 1s and 4w, or off")
+	}
+	return ttl, nil
+}
+
+// formatTimer renders a timer compactly, e.g. 30s, 5m, 1h30m, 2d.
+func formatTimer(ttl time.Duration) string {
+	switch {
+	case ttl%(7*24*time.Hour) == 0:
+		return fmt.Sprintf("%dw", ttl/(7*24*time.Hour))
+	case ttl%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", ttl/(24*time.Hour))
+	}
+	s := ttl.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syncra/internal/client/export"
+	"syncra/internal/client/storage"
+	"syncra/internal/config"
+	"syncra/internal/models"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Conversations are exported with `syncra export` or, from the chat
+// screen, with ctrl+x or /export. Unless told otherwise, files go to
+// syncra/exports in the workspace.
+
+const exportUsage = `syncra export - write a conversation to a file
+
+Usage:
+  syncra export [-format md|html|json] [-since DATE] [-until DATE]
+                [-manifest] [-o FILE] <username | #group>
+  syncra export -verify FILE.manifest.json
+
+Dates are YYYY-MM-DD, today or yesterday; -until includes that day.
+-manifest writes FILE.manifest.json next to the export: the hash of each
+message and of the file, signed with your identity key. Message hashes
+cover sender, text and reply, not times, so they match the other side's
+export. -o - writes the export to stdout.
+`
+
+// exportOptions says what to export and where.
+type exportOptions struct {
+	chat         string // Username, or group ID when group is set
+	group        *models.Group
+	format       export.Format
+	since, until time.Time // Zero for open
+	manifest     bool
+	out          string // File, "-" for stdout, "" for the exports directory
+}
+
+type exportedMsg struct {
+	path string
+	err  error
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, exportUsage) }
+	format := fs.String("format", "md", "md, html or json")
+	since := fs.String("since", "", "first day to include")
+	until := fs.String("until", "", "last day to include")
+	manifest := fs.Bool("manifest", false, "write a signed manifest")
+	out := fs.String("o", "", "output file")
+	verify := fs.String("verify", "", "manifest to check")
+	// Flags may come after the chat too
+	var targets []string
+	for fs.Parse(args); fs.NArg() > 0; fs.Parse(args) {
+		targets = append(targets, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if *verify != "" {
+		return verifyExport(*verify)
+	}
+	if len(targets) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil || cfg == nil {
+		return fmt.Errorf("no workspace: run syncra to set one up first")
+	}
+	o := exportOptions{out: *out, manifest: *manifest}
+	if o.format, err = export.ParseFormat(*format); err != nil {
+		return err
+	}
+	if *since != "" {
+		if o.since, err = parseSearchDate(*since); err != nil {
+			return err
+		}
+	}
+	if *until != "" {
+		day, err := parseSearchDate(*until)
+		if err != nil {
+			return err
+		}
+		o.until = day.AddDate(0, 0, 1)
+	}
+	if o.manifest && o.out == "-" {
+		return fmt.Errorf("-manifest needs a file to describe, not stdout")
+	}
+
+	target := targets[0]
+	if name, ok := strings.CutPrefix(target, "#"); ok {
+		groups, err := storage.ListGroups()
+		if err != nil {
+			return err
+		}
+		for i := range groups {
+			if strings.EqualFold(groups[i].Name, name) || groups[i].ID == name {
+				o.chat, o.group = groups[i].ID, &groups[i]
+			}
+		}
+		if o.group == nil {
+			return fmt.Errorf("no group named %q", name)
+		}
+	} else {
+		o.chat = strings.TrimPrefix(target, "@")
+	}
+
+	path, err := exportChat(cfg, o)
+	if err != nil {
+		return err
+	}
+	if path != "-" {
+		fmt.Println(path)
+	}
+	return nil
+}
+
+// exportChat writes the conversation as o asks, returning the file it
+// went to.
+func exportChat(cfg *config.Config, o exportOptions) (string, error) {
+	title := "@" + o.chat
+	load := storage.LoadMessages
+	if o.group != nil {
+		title = "#" + o.group.Name
+		load = storage.LoadGroupMessages
+	}
+	msgs, err := load(o.chat)
+	if err != nil {
+		return "", fmt.Errorf("failed to load messages: %v", err)
+	}
+	t := export.NewTranscript(title, cfg.Username, msgs, o.since, o.until)
+
+	var buf bytes.Buffer
+	if err := export.Write(&buf, o.format, t); err != nil {
+		return "", err
+	}
+	if o.out == "-" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return "-", err
+	}
+
+	path := o.out
+	if path == "" {
+		name := strings.TrimPrefix(title, "@")
+		if o.group != nil {
+			name = "group-" + o.group.Name
+		}
+		path = filepath.Join(cfg.WorkspacePath, "syncra", "exports",
+			fmt.Sprintf("%s-%s.%s", fileSafe(name), t.ExportedAt.Local().Format("20060102-150405"), o.format))
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create export directory: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return "", fmt.Errorf("failed to write export: %v", err)
+	}
+
+	if o.manifest {
+		identity, err := loadIdentity(cfg.WorkspacePath)
+		if err != nil {
+			return "", err
+		}
+		m, err := export.NewManifest(t, filepath.Base(path), o.format, buf.Bytes(), identity)
+		if err != nil {
+			return "", err
+		}
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal manifest: %v", err)
+		}
+		if err := os.WriteFile(path+".manifest.json", data, 0600); err != nil {
+			return "", fmt.Errorf("failed to write manifest: %v", err)
+		}
+	}
+	return path, nil
+}
+
+// verifyExport checks an export against its manifest, which names the
+// file next to it.
+func verifyExport(manifestPath string) error {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %v", err)
+	}
+	var m export.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("failed to parse manifest: %v", err)
+	}
+	file, err := os.ReadFile(filepath.Join(filepath.Dir(manifestPath), filepath.Base(m.File)))
+	if err != nil {
+		return fmt.Errorf("failed to read export: %v", err)
+	}
+	if err := m.Verify(file); err != nil {
+		return err
+	}
+	fmt.Printf("%s: ok, %d messages of %s exported by @%s\n", m.File, len(m.Messages), m.Chat, m.Owner)
+	fmt.Printf("signed by %s; check it matches @%s's public key\n", m.PublicKey, m.Owner)
+	return nil
+}
+
+// fileSafe keeps letters, digits, dashes and underscores of a name.
+func fileSafe(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// exportCommand handles /export [md|html|json] [since [until]] in the
+// chat input. The export is always signed.
+func (m *model) exportCommand(content string) (bool, tea.Cmd) {
+	fields := strings.Fields(content)
+	if len(fields) == 0 || fields[0] != "/export" {
+		return false, nil
+	}
+	o := exportOptions{format: export.Markdown, manifest: true}
+	args := fields[1:]
+	if len(args) > 0 {
+		if f, err := export.ParseFormat(args[0]); err == nil {
+			o.format, args = f, args[1:]
+		}
+	}
+	if len(args) > 2 {
+		m.err = fmt.Errorf("usage: /export [md|html|json] [since [until]], dates like 2006-01-02")
+		return true, nil
+	}
+	var err error
+	if len(args) > 0 {
+		if o.since, err = parseSearchDate(args[0]); err != nil {
+			m.err = err
+			return true, nil
+		}
+	}
+	if len(args) > 1 {
+		if o.until, err = parseSearchDate(args[1]); err != nil {
+			m.err = err
+			return true, nil
+		}
+		o.until = o.until.AddDate(0, 0, 1)
+	}
+	return true, m.exportOpenChat(o)
+}
+
+// exportOpenChat exports the open chat in the background.
+func (m *model) exportOpenChat(o exportOptions) tea.Cmd {
+	o.chat, o.group = m.chatTarget, m.chatGroup
+	cfg := m.cfg
+	m.err = nil
+	return func() tea.Msg {
+		path, err := exportChat(cfg, o)
+		return exportedMsg{path: path, err: err}
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syncra/internal/crypto"
+	"syncra/internal/models"
+	"syncra/internal/protocol"
+)
+
+// Group messages are encrypted under keys the members agree on with
+// crypto.GroupState. Whenever the relay's member list and our key tree
+// disagree, we commit the difference; the relay keeps the first commit per
+// epoch and rejects the rest, and the losers catch up with a group_sync.
+
+// maxAddsPerCommit keeps commits, which carry a welcome per added member,
+// under protocol.MaxHandshakeBytes. Larger changes take several epochs.
+const maxAddsPerCommit = 4
+
+// pendingCommit is a commit sent to the relay and the state it leads to.
+type pendingCommit struct {
+	packet models.Packet
+	epoch  uint64
+	next   *crypto.GroupState
+}
+
+// identityKey loads our Ed25519 identity from the workspace.
+func (m *model) identityKey() (ed25519.PrivateKey, error) {
+	return loadIdentity(m.cfg.WorkspacePath)
+}
+
+// loadIdentity loads the Ed25519 identity kept in the workspace at path.
+func loadIdentity(workspace string) (ed25519.PrivateKey, error) {
+	keyPath := filepath.Join(workspace, "syncra", "identities", "id_ed25519")
+	priv, err := crypto.LoadPrivateKey(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load identity key: %v", err)
+	}
+	return priv, nil
+}
+
+func (m *model) groupKeyPath(id string) string {
+	return filepath.Join(m.cfg.WorkspacePath, "syncra", "identities", "groups", id+".json")
+}
+
+// groupState returns our key state for a group, or nil before we joined.
+func (m *model) groupState(id string) *crypto.GroupState {
+	if st, ok := m.groupKeys[id]; ok {
+		return st
+	}
+	st, err := crypto.LoadGroupState(m.groupKeyPath(id))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("failed to load group keys", "group", id, "err", err)
+		}
+		return nil
+	}
+	m.groupKeys[id] = st
+	return st
+}
+
+// adoptGroupState makes st current and saves it, returning any save
+// error. Any commit of ours for an earlier epoch is moot from here on.
+func (m *model) adoptGroupState(st *crypto.GroupState) error {
+	m.groupKeys[st.GroupID()] = st
+	if pc, ok := m.pendingCommits[st.GroupID()]; ok && pc.epoch < st.Epoch() {
+		delete(m.pendingCommits, st.GroupID())
+	}
+	if err := crypto.SaveGroupState(m.groupKeyPath(st.GroupID()), st); err != nil {
+		slog.Error("failed to save group keys", "group", st.GroupID(), "err", err)
+		return err
+	}
+	return nil
+}
+
+// dropGroupState forgets the keys of a group we are no longer in.
+func (m *model) dropGroupState(id string) {
+	delete(m.groupKeys, id)
+	delete(m.pendingCommits, id)
+	if err := os.Remove(m.groupKeyPath(id)); err != nil && !os.IsNotExist(err) {
+		slog.Warn("failed to remove group keys", "group", id, "err", err)
+	}
+}
+
+// reconcileGroup brings our key state in line with the relay's view of g.
+func (m *model) reconcileGroup(g models.Group) {
+	st := m.groupState(g.ID)
+	if st == nil {
+		if g.Owner != m.cfg.Username || g.Epoch != 0 {
+			// Someone commits us in; ask for the welcome in case they did
+			m.syncGroup(g.ID, 0, true)
+			return
+		}
+		identity, err := m.identityKey()
+		if err != nil {
+			slog.Error("cannot start group keys", "group", g.ID, "err", err)
+			return
+		}
+		if st, err = crypto.NewGroup(g.ID, m.cfg.Username, identity); err != nil {
+			slog.Error("cannot start group keys", "group", g.ID, "err", err)
+			return
+		}
+		m.adoptGroupState(st)
+	}
+
+	if st.Epoch() < g.Epoch {
+		m.syncGroup(g.ID, st.Epoch(), false)
+		return
+	}
+	if pc, ok := m.pendingCommits[g.ID]; ok && pc.epoch == st.Epoch() {
+		// Still unacked, maybe lost with a connection
+		m.sendToRelay(pc.packet)
+		return
+	}
+	// Keys restored from a backup need an epoch of our own before we send
+	if err := m.commitGroup(st, g, st.Restored()); err != nil {
+		slog.Warn("group commit not sent", "group", g.ID, "err", err)
+	}
+}
+
+// commitGroup commits the membership difference between st and g. With
+// rotate set it commits even when there is none, to refresh our keys.
+func (m *model) commitGroup(st *crypto.GroupState, g models.Group, rotate bool) error {
+	inTree := make(map[string]bool)
+	var removes []string
+	for _, u := range st.Members() {
+		inTree[u] = true
+		if !g.HasMember(u) {
+			removes = append(removes, u)
+		}
+	}
+	var adds []crypto.Credential
+	for _, u := range g.Members {
+		if inTree[u] || len(adds) == maxAddsPerCommit {
+			continue
+		}
+		key, err := hex.DecodeString(g.Keys[u])
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			slog.Warn("group member has no usable key", "group", g.ID, "user", u)
+			continue
+		}
+		adds = append(adds, crypto.Credential{Username: u, SigningKey: key})
+	}
+	if len(adds) == 0 && len(removes) == 0 && !rotate {
+		return nil
+	}
+
+	identity, err := m.identityKey()
+	if err != nil {
+		return err
+	}
+	c, welcomes, next, err := st.Commit(identity, adds, removes)
+	if err != nil {
+		return fmt.Errorf("cannot commit group change: %v", err)
+	}
+	h := models.HandshakePayload{Group: g.ID, Epoch: st.Epoch(), Welcomes: make(map[string]json.RawMessage)}
+	h.Data, _ = json.Marshal(c)
+	for u, w := range welcomes {
+		h.Welcomes[u], _ = json.Marshal(w)
+	}
+	pkt, err := protocol.GroupCommit(m.cfg.Username, h)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("committing group change", "group", g.ID, "epoch", st.Epoch(), "adds", len(adds), "removes", len(removes))
+	m.pendingCommits[g.ID] = pendingCommit{packet: pkt, epoch: st.Epoch(), next: next}
+	if !m.sendToRelay(pkt) {
+		return fmt.Errorf("not connected to the relay")
+	}
+	return nil
+}
+
+func (m *model) syncGroup(id string, epoch uint64, join bool) {
+	if pkt, err := protocol.GroupSync(id, epoch, join); err == nil {
+		m.sendToRelay(pkt)
+	}
+}
+
+// commitAcked settles one of our commits. It reports whether ack was for
+// a commit at all.
+func (m *model) commitAcked(ack models.AckPayload) bool {
+	for id, pc := range m.pendingCommits {
+		if pc.packet.ID != ack.ID {
+			continue
+		}
+		if ack.Status != models.AckDelivered {
+			slog.Info("group commit rejected", "group", id, "reason", ack.Reason)
+			if ack.Reason != protocol.StaleEpoch {
+				delete(m.pendingCommits, id)
+				return true
+			}
+			// Someone else took the epoch, maybe us on a lost connection:
+			// keep the state until the sync shows whose commit it was
+			m.syncGroup(id, pc.epoch, false)
+			return true
+		}
+		m.adoptGroupState(pc.next)
+		m.reconcileKnownGroup(id)
+		return true
+	}
+	return false
+}
+
+// reconcileKnownGroup re-runs reconcileGroup against the last group_info,
+// for membership changes that took more than one commit.
+func (m *model) reconcileKnownGroup(id string) {
+	for _, g := range m.groups {
+		if g.ID == id {
+			m.reconcileGroup(g)
+			return
+		}
+	}
+}
+
+// applyGroupCommit processes a commit relayed live or replayed by a sync.
+func (m *model) applyGroupCommit(p models.Packet) {
+	h, err := protocol.DecodeHandshake(p)
+	if err != nil {
+		slog.Warn("invalid group commit", "err", err)
+		return
+	}
+	st := m.groupState(h.Group)
+	switch {
+	case st == nil || h.Epoch < st.Epoch():
+		// Not joined yet, or already applied
+		return
+	case h.Epoch > st.Epoch():
+		m.syncGroup(h.Group, st.Epoch(), false)
+		return
+	}
+
+	if p.From == m.cfg.Username {
+		// Our own commit, stored by the relay but never acked
+		if pc, ok := m.pendingCommits[h.Group]; ok && pc.epoch == h.Epoch {
+			m.adoptGroupState(pc.next)
+			m.reconcileKnownGroup(h.Group)
+		} else {
+			slog.Error("own group commit without its state", "group", h.Group, "epoch", h.Epoch)
+		}
+		return
+	}
+
+	var c crypto.Commit
+	if err := json.Unmarshal(h.Data, &c); err != nil {
+		slog.Warn("malformed group commit", "group", h.Group, "err", err)
+		return
+	}
+	next, err := st.Apply(&c)
+	if errors.Is(err, crypto.ErrRemoved) {
+		m.dropGroupState(h.Group)
+		return
+	}
+	if err != nil {
+		slog.Warn("cannot apply group commit", "group", h.Group, "epoch", h.Epoch, "from", p.From, "err", err)
+		return
+	}
+	slog.Debug("group epoch advanced", "group", h.Group, "epoch", next.Epoch(), "from", p.From)
+	m.adoptGroupState(next)
+	m.reconcileKnownGroup(h.Group)
+}
+
+// applyGroupWelcome joins a group's key tree.
+func (m *model) applyGroupWelcome(p models.Packet) {
+	h, err := protocol.DecodeHandshake(p)
+	if err != nil {
+		slog.Warn("invalid group welcome", "err", err)
+		return
+	}
+	if st := m.groupState(h.Group); st != nil && st.Epoch() >= h.Epoch {
+		return
+	}
+	var w crypto.Welcome
+	if err := json.Unmarshal(h.Data, &w); err != nil || w.GroupID != h.Group {
+		slog.Warn("malformed group welcome", "group", h.Group)
+		return
+	}
+	identity, err := m.identityKey()
+	if err != nil {
+		slog.Error("cannot join group", "group", h.Group, "err", err)
+		return
+	}
+	st, err := crypto.JoinGroup(&w, identity)
+	if err != nil {
+		slog.Warn("cannot join group", "group", h.Group, "err", err)
+		return
+	}
+	slog.Info("joined group keys", "group", h.Group, "epoch", st.Epoch())
+	m.adoptGroupState(st)
+}
+
+// sealGroupChat encrypts content for the open group.
+func (m *model) sealGroupChat(id, content string) (models.ChatPayload, error) {
+	st := m.groupState(id)
+	if st == nil {
+		return models.ChatPayload{}, fmt.Errorf("waiting for group keys; try again shortly")
+	}
+	identity, err := m.identityKey()
+	if err != nil {
+		return models.ChatPayload{}, err
+	}
+	msg, err := st.Encrypt([]byte(content), identity)
+	if errors.Is(err, crypto.ErrRestored) {
+		m.reconcileKnownGroup(id)
+		return models.ChatPayload{}, fmt.Errorf("renewing group keys after the restore; try again shortly")
+	}
+	if err != nil {
+		return models.ChatPayload{}, err
+	}
+	// The key and nonce follow from the generation, so it must be on disk
+	// as used before the message leaves; after a restart it would be
+	// handed out again
+	if err := m.adoptGroupState(st); err != nil {
+		return models.ChatPayload{}, fmt.Errorf("cannot save group keys, message not sent: %v", err)
+	}
+	data, _ := json.Marshal(msg)
+	return models.ChatPayload{Message: base64.StdEncoding.EncodeToString(data), Suite: models.SuiteGroup}, nil
+}
+
+// openGroupChat decrypts a group message for display. When it cannot be
+// decrypted or was not sent by p.From, it returns a placeholder to show
+// in its place along with the error.
+func (m *model) openGroupChat(p models.Packet, chat models.ChatPayload) (string, error) {
+	if chat.Suite != models.SuiteGroup {
+		return "[unencrypted] " + chat.Message, nil
+	}
+	st := m.groupState(p.To)
+	if st == nil {
+		return "[could not decrypt: no group keys yet]", fmt.Errorf("no keys for group %s", p.To)
+	}
+	var msg crypto.GroupMessage
+	data, err := base64.StdEncoding.DecodeString(chat.Message)
+	if err == nil {
+		err = json.Unmarshal(data, &msg)
+	}
+	if err != nil {
+		slog.Warn("malformed group message", "group", p.To, "from", p.From, "err", err)
+		return "[could not decrypt]", err
+	}
+	plaintext, sender, err := st.Decrypt(&msg)
+	if err == nil && sender != p.From {
+		err = fmt.Errorf("relayed as from %s but sent by %s", p.From, sender)
+	}
+	if err != nil {
+		slog.Warn("cannot decrypt group message", "group", p.To, "from", p.From, "err", err)
+		return "[could not decrypt]", err
+	}
+	return string(plaintext), nil
+}
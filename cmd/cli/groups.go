@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"syncra/internal/client/storage"
+	"syncra/internal/models"
+	"syncra/internal/protocol"
+)
+
+// openChat switches to the 1:1 conversation with username.
+func (m *model) openChat(username string) {
+	m.chatTarget = username
+	m.state = stateChat
+	m.chatGroup = nil
+	m.quoted = nil
+	if err := m.loadChat(0); err != nil {
+		slog.Warn("failed to load messages", "chat", username, "err", err)
+	}
+	m.msgCursor, m.editing, m.reacting = -1, "", false
+	m.replyTo, m.thread = "", ""
+	m.reloadExpiry()
+	m.scrollToBottom()
+	m.chatInput.Focus()
+	m.err, m.notice = nil, ""
+	m.markRead()
+}
+
+// openGroup switches to a group conversation. chatTarget holds the group
+// ID, so outbox and delivery code treat it like any other recipient.
+func (m *model) openGroup(g models.Group) {
+	m.chatTarget = g.ID
+	m.chatGroup = &g
+	m.state = stateChat
+	m.quoted = nil
+	if err := m.loadChat(0); err != nil {
+		slog.Warn("failed to load messages", "chat", g.ID, "err", err)
+	}
+	m.msgCursor, m.editing, m.reacting = -1, "", false
+	m.replyTo, m.thread = "", ""
+	m.reloadExpiry()
+	m.scrollToBottom()
+	m.groupState(g.ID) // Warm the cache for the header
+	m.chatInput.Focus()
+	m.err, m.notice = nil, ""
+}
+
+// reloadGroups refreshes the group list. Groups need the relay, so LAN
+// mode has none.
+func (m *model) reloadGroups() {
+	if m.isLocal {
+		return
+	}
+	groups, err := storage.ListGroups()
+	if err != nil {
+		slog.Warn("failed to list groups", "err", err)
+		return
+	}
+	m.groups = groups
+}
+
+// applyGroupInfo records a group notice from the relay. Being absent from
+// the member list means we were removed.
+func (m *model) applyGroupInfo(g models.Group) {
+	member := g.HasMember(m.cfg.Username)
+	var err error
+	if member {
+		err = storage.SaveGroup(g)
+	} else {
+		err = storage.RemoveGroup(g.ID)
+	}
+	if err != nil {
+		slog.Error("failed to store group", "group", g.ID, "err", err)
+	}
+	m.reloadGroups()
+	if member {
+		m.reconcileGroup(g)
+	} else {
+		m.dropGroupState(g.ID)
+	}
+
+	if m.chatGroup != nil && m.chatGroup.ID == g.ID {
+		if member {
+			m.chatGroup = &g
+		} else {
+			m.state = stateMain
+			m.chatGroup = nil
+			m.err = fmt.Errorf("you are no longer in %s", g.Name)
+		}
+	}
+}
+
+// groupCommand handles "/invite <user>", "/remove <user>", "/leave" and
+// "/rotate" typed in a group chat. It reports whether content was a command.
+func (m *model) groupCommand(content string) bool {
+	fields := strings.Fields(content)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return false
+	}
+
+	var pkt models.Packet
+	var err error
+	switch {
+	case fields[0] == "/invite" && len(fields) == 2:
+		pkt, err = protocol.GroupInvite(m.chatGroup.ID, fields[1])
+	case fields[0] == "/remove" && len(fields) == 2:
+		pkt, err = protocol.GroupRemove(m.chatGroup.ID, fields[1])
+	case fields[0] == "/leave" && len(fields) == 1:
+		pkt, err = protocol.GroupRemove(m.chatGroup.ID, m.cfg.Username)
+	case fields[0] == "/rotate" && len(fields) == 1:
+		// Fresh keys for us, without a membership change
+		if st := m.groupState(m.chatGroup.ID); st == nil {
+			m.err = fmt.Errorf("waiting for group keys; try again shortly")
+		} else {
+			m.err = m.commitGroup(st, *m.chatGroup, true)
+		}
+		return true
+	default:
+		err = fmt.Errorf("usage: /invite <user>, /remove <user>, /leave or /rotate")
+	}
+	if err == nil && !m.sendToRelay(pkt) {
+		err = fmt.Errorf("not connected to the relay")
+	}
+	m.err = err
+	return true
+}
+
+// createGroup asks the relay for a new group from the new-group form.
+func (m *model) createGroup(name, members string) error {
+	var usernames []string
+	for _, u := range strings.Split(members, ",") {
+		if u = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(u), "@")); u != "" {
+			usernames = append(usernames, u)
+		}
+	}
+	pkt, err := protocol.GroupCreate(strings.TrimSpace(name), usernames)
+	if err != nil {
+		return err
+	}
+	if !m.sendToRelay(pkt) {
+		return fmt.Errorf("not connected to the relay")
+	}
+	return nil
+}
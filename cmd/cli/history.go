@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"syncra/internal/client/storage"
+	"syncra/internal/models"
+)
+
+// An open chat holds only its most recent messages; scrolling past the
+// oldest one loads the page before it. Replies quoting a message that is
+// not loaded look it up through the chat's index instead.
+
+// chatPageSize is how many messages are loaded at a time.
+const chatPageSize = 100
+
+// loadChat loads the newest page of the open chat, or at least n messages
+// when more are already shown.
+func (m *model) loadChat(n int) error {
+	load := storage.LoadRecentMessages
+	if m.chatGroup != nil {
+		load = storage.LoadRecentGroupMessages
+	}
+	page, err := load(m.chatTarget, max(n, chatPageSize))
+	if err != nil {
+		return err
+	}
+	m.chatMessages, m.chatOlder = page.Messages, page.Before
+	return nil
+}
+
+// loadOlder prepends the page before the oldest loaded message, keeping
+// the selection and the scroll position on the same messages. It reports
+// whether there was anything to load.
+func (m *model) loadOlder() bool {
+	if m.chatOlder <= 0 {
+		return false
+	}
+	load := storage.LoadMessagesBefore
+	if m.chatGroup != nil {
+		load = storage.LoadGroupMessagesBefore
+	}
+	page, err := load(m.chatTarget, m.chatOlder, chatPageSize)
+	if err != nil {
+		slog.Warn("failed to load older messages", "chat", m.chatTarget, "err", err)
+		return false
+	}
+
+	loaded := make(map[string]bool, len(m.chatMessages))
+	for _, msg := range m.chatMessages {
+		loaded[msg.ID] = true
+	}
+	var older []models.LocalChatMessage
+	for _, msg := range page.Messages {
+		if !loaded[msg.ID] {
+			older = append(older, msg)
+		}
+	}
+	m.chatOlder = page.Before
+	if len(older) == 0 {
+		return m.loadOlder()
+	}
+
+	shownBefore := len(m.shownMessages())
+	m.chatMessages = append(older, m.chatMessages...)
+	added := len(m.shownMessages()) - shownBefore
+	if m.msgCursor >= 0 {
+		m.msgCursor += added
+		m.viewCursor = m.msgCursor
+	}
+	if m.viewSeen >= 0 {
+		m.viewSeen += added
+	}
+	m.keepScroll = true
+	return true
+}
+
+// resolveQuotes looks up, once each, the messages quoted by loaded replies
+// but not loaded themselves; findMessage then finds them in m.quoted.
+func (m *model) resolveQuotes() {
+	if m.quoted == nil {
+		m.quoted = make(map[string]models.LocalChatMessage)
+	}
+	find := storage.FindMessage
+	if m.chatGroup != nil {
+		find = storage.FindGroupMessage
+	}
+	for _, msg := range m.chatMessages {
+		id := msg.ReplyTo
+		if id == "" {
+			continue
+		}
+		if _, ok := m.quoted[id]; ok {
+			continue
+		}
+		if _, ok := m.findMessage(id); ok {
+			continue
+		}
+		quoted, err := find(m.chatTarget, id)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			slog.Warn("failed to look up quoted message", "chat", m.chatTarget, "id", id, "err", err)
+		}
+		// Misses are kept too, as a zero message, so each is looked up once
+		m.quoted[id] = quoted
+	}
+}
@@ -2,30 +2,68 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"math/rand"
 	"os"
 	"syncra/internal/client/storage"
 	clientWS "syncra/internal/client/websocket"
 	"syncra/internal/config"
+	"syncra/internal/crypto"
 	"syncra/internal/discovery"
+	"syncra/internal/logging"
 	"syncra/internal/models"
+	"syncra/internal/protocol"
 	"syncra/internal/ui"
 	"time"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
 
+// Open client log file, closed when the program exits
+var logFile io.Closer
+
+// initLogging points the default logger at the workspace's rotating log
+// file. Until a workspace exists everything is discarded, since stderr
+// belongs to the TUI.
+func initLogging(workspacePath string) {
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+	slog.SetDefault(logging.Discard())
+	if workspacePath == "" {
+		return
+	}
+	logger, f, err := logging.NewClientLogger(workspacePath)
+	if err != nil {
+		return
+	}
+	logFile = f
+	slog.SetDefault(logger)
+}
+
 func initialModel(isLocal bool) model {
 	// Try to load existing config
-	cfg, _ := config.LoadConfig()
+	cfg, err := config.LoadConfig()
+	if cfg != nil {
+		initLogging(cfg.WorkspacePath)
+	} else {
+		initLogging("")
+	}
+	if err != nil {
+		slog.Error("failed to load config", "err", err)
+	}
 
 	ti := textinput.New()
 	ti.Placeholder = "workspace path..."
-	ti.CharLimit = 156
+	ti.CharLimit = defaultCharLimit
 	ti.Width = 50
 	ti.TextStyle = ui.InputStyle
 
@@ -46,21 +84,40 @@ func initialModel(isLocal bool) model {
 	si.Width = 50
 	si.TextStyle = ui.InputStyle
 
+	fi := textinput.New()
+	fi.Placeholder = "words, from:, in:, after:, before:, on:..."
+	fi.CharLimit = 256
+	fi.Width = 60
+	fi.TextStyle = ui.InputStyle
+
 	ci := textinput.New()
 	ci.Placeholder = "type a message..."
 	ci.CharLimit = 1000
 	ci.Width = 60
 	ci.TextStyle = ui.InputStyle
 
+	mi := textinput.New()
+	mi.Placeholder = "alice, bob..."
+	mi.CharLimit = 1000
+	mi.Width = 50
+	mi.TextStyle = ui.InputStyle
+
 	m := model{
 		startTime:     time.Now(),
 		cfg:           cfg,
 		textInput:     ti,
 		nameInput:     ni,
 		searchInput:   si,
+		findInput:     fi,
 		chatInput:     ci,
+		memberInput:   mi,
 		searchResults: []*models.User{},
 		isLocal:       isLocal,
+		delivery:      clientWS.NewTracker(),
+		viewport:      viewport.New(defaultChatWidth, defaultChatHeight),
+
+		groupKeys:      make(map[string]*crypto.GroupState),
+		pendingCommits: make(map[string]pendingCommit),
 	}
 
 	s := spinner.New()
@@ -68,22 +125,27 @@ func initialModel(isLocal bool) model {
 	s.Style = lipgloss.NewStyle().Foreground(ui.Primary)
 	m.spinner = s
 
-	chats, _ := storage.ListChats()
-	m.chats = chats
-
 	if cfg == nil || cfg.Username == "" {
 		m.state = stateSetupWorkspace
 		m.textInput.Focus()
 	} else {
 		m.state = stateMain
+		m.reloadChats()
+		m.reloadGroups()
+		m.reloadRequests()
+		m.reloadExpiry()
 		if m.isLocal {
+			m.reloadOutbox()
 			startLocalNode(&m)
 		} else {
+			m.trackOutbox()
 			// Auto-connect
 			conn, err := clientWS.Connect("localhost:8080")
 			if err == nil {
 				m.conn = conn
 				go m.conn.WritePump()
+			} else {
+				slog.Info("relay unreachable at startup", "err", err)
 			}
 		}
 	}
@@ -96,36 +158,113 @@ func startLocalNode(m *model) {
 		// random port for the local TCP/HTTP server
 		port := fmt.Sprintf("%d", 8000+rand.Intn(1000))
 		m.localNode = discovery.NewNode(m.cfg.Username, m.cfg.FullName, port)
-		m.localNode.Start()
-		go m.localNode.StartServer(func(data []byte) {
-			// Actually we need to send this data to the model.
-			// Since StartServer runs in background, we let the standard listen routine or Bubbletea command handle it,
-			// but we can't easily send tea.Msg without the program reference.
-			// For simplicity, we can do it by saving to local DB and updating chat messages.
-			var packet models.Packet
-			json.Unmarshal(data, &packet)
-			if packet.Type == models.TypeChat {
-				var chat models.ChatPayload
-				json.Unmarshal(packet.Payload, &chat)
-				localMsg := models.LocalChatMessage{
-					From:      packet.From,
-					Content:   chat.Message,
-					Timestamp: packet.Timestamp,
-					IsMe:      false,
+		if err := m.localNode.Start(); err != nil {
+			slog.Error("lan discovery failed to start", "err", err)
+		}
+		slog.Info("lan node started", "port", port)
+		go func() {
+			err := m.localNode.StartServer(func(data []byte) {
+				// Actually we need to send this data to the model.
+				// Since StartServer runs in background, we let the standard listen routine or Bubbletea command handle it,
+				// but we can't easily send tea.Msg without the program reference.
+				// For simplicity, we can do it by saving to local DB and updating chat messages.
+				var packet models.Packet
+				if err := json.Unmarshal(data, &packet); err != nil {
+					slog.Warn("lan: dropping malformed packet", "err", err, "bytes", len(data))
+					return
+				}
+				// No relay vouches for the sender here, and the history it
+				// lands in is named after it
+				if err := protocol.ValidateExistingUsername(packet.From); err != nil {
+					slog.Warn("lan: dropping packet with invalid sender", "from", packet.From, "err", err)
+					return
 				}
-				storage.AppendMessage(packet.From, localMsg)
+				if packet.Type == models.TypeChat {
+					chat, err := protocol.DecodeChat(packet)
+					if err != nil {
+						slog.Warn("lan: invalid chat payload", "from", packet.From, "err", err)
+						return
+					}
+					localMsg := models.LocalChatMessage{
+						ID:        packet.ID,
+						From:      packet.From,
+						Content:   chat.Message,
+						Timestamp: packet.Timestamp,
+						IsMe:      false,
+						ReplyTo:   chat.ReplyTo,
+						ExpiresAt: expiresAt(packet, chat),
+					}
+					if _, err := storage.ReceiveMessage(packet.From, localMsg); errors.Is(err, storage.ErrDuplicate) {
+						slog.Debug("lan: duplicate message dropped", "from", packet.From, "id", packet.ID)
+					} else if errors.Is(err, storage.ErrBlocked) {
+						slog.Debug("lan: message from blocked sender dropped", "from", packet.From)
+					} else if err != nil {
+						slog.Error("lan: failed to store message", "from", packet.From, "err", err)
+					}
+				}
+				// Edits, deletions, reactions and timers; the chat view
+				// picks them up on reload
+				switch packet.Type {
+				case models.TypeEdit:
+					if e, err := protocol.DecodeEdit(packet); err != nil || e.Group {
+						slog.Warn("lan: invalid edit payload", "from", packet.From, "err", err)
+					} else {
+						storeEdit(packet, e, e.Message)
+					}
+				case models.TypeDelete:
+					if d, err := protocol.DecodeDelete(packet); err != nil || d.Group {
+						slog.Warn("lan: invalid delete payload", "from", packet.From, "err", err)
+					} else {
+						storeDelete(packet, d)
+					}
+				case models.TypeExpiry:
+					if x, err := protocol.DecodeExpiry(packet); err != nil || x.Group {
+						slog.Warn("lan: invalid expiry payload", "from", packet.From, "err", err)
+					} else {
+						storeExpiry(packet, x)
+					}
+				case models.TypeReaction:
+					if r, err := protocol.DecodeReaction(packet); err != nil || r.Group {
+						slog.Warn("lan: invalid reaction payload", "from", packet.From, "err", err)
+					} else {
+						storeReaction(packet, r)
+					}
+				}
+			})
+			if err != nil {
+				slog.Error("lan server stopped", "err", err)
 			}
-		})
+		}()
 	}
 }
 func main() {
+	if len(os.Args) > 1 {
+		commands := map[string]func([]string) error{
+			"export":  runExport,
+			"backup":  runBackup,
+			"restore": runRestore,
+			"phrase":  runPhrase,
+		}
+		if run, ok := commands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "syncra %s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	var isLocal bool
 	if len(os.Args) > 1 && os.Args[1] == "local" {
 		isLocal = true
 	}
 
-	p := tea.NewProgram(initialModel(isLocal))
-	if _, err := p.Run(); err != nil {
+	p := tea.NewProgram(initialModel(isLocal), tea.WithMouseCellMotion())
+	_, err := p.Run()
+	if logFile != nil {
+		logFile.Close()
+	}
+	if err != nil {
 		fmt.Printf("Error running Syncra: %v\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"syncra/internal/client/storage"
+	"syncra/internal/models"
+	"syncra/internal/protocol"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Edits and deletions refer to a message by its packet ID. Each side
+// applies them to its own stored copy; only the author may change a
+// message, so one from anyone else is dropped.
+
+var errNotAuthor = errors.New("message has a different author")
+
+// reloadMessages rereads the loaded part of the open chat's history,
+// keeping the selection.
+func (m *model) reloadMessages() {
+	if err := m.loadChat(len(m.chatMessages)); err != nil {
+		slog.Warn("failed to load messages", "chat", m.chatTarget, "err", err)
+		return
+	}
+	m.quoted = nil
+	if n := len(m.shownMessages()); m.msgCursor >= n {
+		m.msgCursor = n - 1
+	}
+}
+
+// moveCursor selects the previous (delta < 0) or next message. Moving down
+// past the newest message clears the selection.
+func (m *model) moveCursor(delta int) {
+	n := len(m.shownMessages())
+	switch {
+	case n == 0:
+		return
+	case m.msgCursor < 0 && delta < 0:
+		m.msgCursor = n - 1
+	case m.msgCursor < 0:
+		return
+	default:
+		m.msgCursor = max(m.msgCursor+delta, 0)
+		if m.msgCursor >= n {
+			m.msgCursor = -1
+		}
+	}
+}
+
+// selection returns the selected message, if any.
+func (m *model) selection() (models.LocalChatMessage, bool) {
+	shown := m.shownMessages()
+	if m.msgCursor < 0 || m.msgCursor >= len(shown) {
+		return models.LocalChatMessage{}, false
+	}
+	return shown[m.msgCursor], true
+}
+
+// ownSelection returns the selected message if we wrote it and it still
+// stands.
+func (m *model) ownSelection() (models.LocalChatMessage, bool) {
+	msg, ok := m.selection()
+	return msg, ok && msg.IsMe && !msg.Deleted && msg.ID != ""
+}
+
+// startEdit loads the selected message into the input for editing.
+func (m *model) startEdit() {
+	msg, ok := m.ownSelection()
+	if !ok {
+		m.err = fmt.Errorf("only your own messages can be edited")
+		return
+	}
+	m.editing = msg.ID
+	m.chatInput.SetValue(msg.Content)
+	m.chatInput.CursorEnd()
+	m.err = nil
+}
+
+// cancelEdit leaves edit mode without sending.
+func (m *model) cancelEdit() {
+	m.editing = ""
+	m.chatInput.Reset()
+}
+
+// sendEdit replaces the text of the message being edited, here and for
+// the other side.
+func (m *model) sendEdit(content string) tea.Cmd {
+	target := m.editing
+	group := m.chatGroup != nil
+	chat := models.ChatPayload{Message: content}
+	if group {
+		var err error
+		if chat, err = m.sealGroupChat(m.chatTarget, content); err != nil {
+			m.err = err
+			return nil
+		}
+	}
+	pkg, err := protocol.Edit(m.cfg.Username, m.chatTarget, group, target, chat)
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	sendCmd := m.queueOutgoing(pkg)
+
+	now := time.Now()
+	err = updateStored(m.chatTarget, group, target, m.cfg.Username, func(msg *models.LocalChatMessage) {
+		msg.Content = content
+		msg.EditedAt = &now
+	})
+	if err != nil {
+		slog.Error("failed to store edit", "chat", m.chatTarget, "id", target, "err", err)
+	}
+	m.cancelEdit()
+	m.reloadMessages()
+	m.reloadChats()
+	return sendCmd
+}
+
+// deleteSelected retracts the selected message on both sides.
+func (m *model) deleteSelected() tea.Cmd {
+	msg, ok := m.ownSelection()
+	if !ok {
+		m.err = fmt.Errorf("only your own messages can be deleted")
+		return nil
+	}
+	group := m.chatGroup != nil
+	pkg, err := protocol.Delete(m.cfg.Username, m.chatTarget, group, msg.ID)
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	sendCmd := m.queueOutgoing(pkg)
+
+	if err := updateStored(m.chatTarget, group, msg.ID, m.cfg.Username, markDeleted); err != nil {
+		slog.Error("failed to store deletion", "chat", m.chatTarget, "id", msg.ID, "err", err)
+	}
+	if m.editing == msg.ID {
+		m.cancelEdit()
+	}
+	m.err = nil
+	m.reloadMessages()
+	m.reloadChats()
+	return sendCmd
+}
+
+// applyEdit stores an edit received from the relay.
+func (m *model) applyEdit(p models.Packet) {
+	e, err := protocol.DecodeEdit(p)
+	if err != nil {
+		slog.Warn("invalid edit payload", "from", p.From, "err", err)
+		return
+	}
+	content := e.Message
+	if e.Group {
+		// An edit that cannot be opened must not replace the good text
+		// with a placeholder
+		if content, err = m.openGroupChat(p, e.ChatPayload); err != nil {
+			slog.Warn("dropping group edit", "group", p.To, "from", p.From, "target", e.Target)
+			return
+		}
+	}
+	m.applyUpdate(p, e.Group, storeEdit(p, e, content))
+}
+
+// applyDelete stores a deletion received from the relay.
+func (m *model) applyDelete(p models.Packet) {
+	d, err := protocol.DecodeDelete(p)
+	if err != nil {
+		slog.Warn("invalid delete payload", "from", p.From, "err", err)
+		return
+	}
+	m.applyUpdate(p, d.Group, storeDelete(p, d))
+}
+
+// applyUpdate refreshes whatever shows the chat an edit, deletion or
+// reaction touched.
+func (m *model) applyUpdate(p models.Packet, group bool, err error) {
+	if err != nil {
+		return
+	}
+	chat := p.From
+	if group {
+		chat = p.To
+	}
+	if m.state == stateChat && m.chatTarget == chat && (m.chatGroup != nil) == group {
+		m.reloadMessages()
+	}
+	if !group {
+		m.reloadChats()
+	}
+}
+
+// storeEdit applies a received edit with its already decrypted content.
+// It is shared with the LAN receiver, which has no model to refresh.
+func storeEdit(p models.Packet, e models.EditPayload, content string) error {
+	editedAt := p.Timestamp
+	return logUpdate(p, e.Group, e.Target, updateStored(chatOf(p, e.Group), e.Group, e.Target, p.From, func(msg *models.LocalChatMessage) {
+		msg.Content = content
+		msg.EditedAt = &editedAt
+	}))
+}
+
+// storeDelete applies a received deletion.
+func storeDelete(p models.Packet, d models.DeletePayload) error {
+	return logUpdate(p, d.Group, d.Target, updateStored(chatOf(p, d.Group), d.Group, d.Target, p.From, markDeleted))
+}
+
+func chatOf(p models.Packet, group bool) string {
+	if group {
+		return p.To
+	}
+	return p.From
+}
+
+func logUpdate(p models.Packet, group bool, target string, err error) error {
+	switch {
+	case err == nil:
+		slog.Debug("message updated", "type", p.Type, "chat", chatOf(p, group), "id", target)
+	case errors.Is(err, storage.ErrNotFound):
+		slog.Debug("update for unknown message dropped", "type", p.Type, "from", p.From, "id", target)
+	case errors.Is(err, errNotAuthor):
+		slog.Warn("update from non-author dropped", "type", p.Type, "from", p.From, "id", target)
+	default:
+		slog.Error("failed to store message update", "type", p.Type, "from", p.From, "err", err)
+	}
+	return err
+}
+
+// updateStored changes message id in a chat history, provided author
+// wrote it.
+func updateStored(chat string, group bool, id, author string, fn func(*models.LocalChatMessage)) error {
+	update := storage.UpdateMessage
+	if group {
+		update = storage.UpdateGroupMessage
+	}
+	return update(chat, id, func(msg *models.LocalChatMessage) error {
+		if msg.From != author {
+			return errNotAuthor
+		}
+		fn(msg)
+		return nil
+	})
+}
+
+func markDeleted(msg *models.LocalChatMessage) {
+	msg.Deleted = true
+	msg.Content = ""
+	msg.Reactions = nil
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"syncra/internal/client/storage"
 	clientWS "syncra/internal/client/websocket"
 	"syncra/internal/config"
+	"syncra/internal/crypto"
 	"syncra/internal/discovery"
 	"syncra/internal/models"
 	"time"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
 )
 
 type state int
@@ -25,8 +29,25 @@ const (
 	stateChat
 	stateConfirmPurge
 	stateLanNetwork
+	stateNewGroup
+	stateRequests
+	stateNickname
+	stateMessageSearch
+	stateSetupPhrase
+	stateConfirmMove
 )
 
+// typing reports whether keys go to a text field, where "q" is a letter
+// rather than the quit key.
+func (m model) typing() bool {
+	switch m.state {
+	case stateSetupWorkspace, stateSetupUsername, stateSetupFullName, stateSetupPhrase,
+		stateSettings, stateSearch, stateChat, stateNewGroup, stateNickname, stateMessageSearch:
+		return true
+	}
+	return false
+}
+
 type model struct {
 	state         state
 	textInput     textinput.Model
@@ -43,16 +64,55 @@ type model struct {
 	tempWorkspace string
 	tempUsername  string
 	tempFullName  string
+	restoreKey    ed25519.PrivateKey // Rebuilt from a recovery phrase, nil for a new identity
 
 	// Search data
 	searchInput   textinput.Model
 	searchResults []*models.User
 
+	// Message search; findQuery is what findHits were found for
+	findInput textinput.Model
+	findHits  []storage.SearchHit
+	findIndex int
+	findQuery string
+
 	// Chat data
 	chatInput    textinput.Model
 	chatTarget   string
-	chatMessages []models.LocalChatMessage
+	chatMessages []models.LocalChatMessage // The newest pages of the chat
+	chatOlder    int64                     // Where the page before them ends, 0 at the start
+	quoted       map[string]models.LocalChatMessage
+	chatGroup    *models.Group // Set when chatTarget is a group ID
+	msgCursor    int           // Selected message, -1 for none
+	editing      string        // ID of the message being edited, if any
+	reacting     bool          // Reaction picker open for the selection
+	reactIndex   int
+	replyTo      string // ID of the message the next one answers
+	thread       string // Root ID when showing a single thread
 	conn         *clientWS.Connection
+	notice       string // Shown under the input until the next key
+
+	// Scrolling message list; viewSeen and viewCursor are the message
+	// count and selection it last showed, newBelow what arrived since
+	// while scrolled up
+	viewport   viewport.Model
+	keepScroll bool // Older messages went in above; hold the view still
+	viewSeen   int
+	viewCursor int
+	newBelow   int
+	width      int // Terminal size, zero until known
+	height     int
+
+	// Disappearing message timers by chat key
+	expiry map[string]storage.ExpiryTimer
+
+	// Relay delivery: unacked packets survive reconnects in the tracker
+	delivery      *clientWS.Tracker
+	authenticated bool
+
+	// Messages not yet delivered, by packet ID, persisted in the workspace
+	outbox      map[string]storage.OutboxEntry
+	lanFlushing bool
 
 	// App state
 	reconnecting bool
@@ -60,16 +120,34 @@ type model struct {
 	isLocal      bool
 	localNode    *discovery.Node
 
-	// Friends list
-	chats              []string
+	// Friends list, followed by groups; one selection spans both
+	chats              []storage.ChatSummary
+	contacts           map[string]storage.Contact
+	showArchived       bool
+	groups             []models.Group
 	chatSelectionIndex int
+	nicknameTarget     string // Username being renamed in stateNickname
+
+	// Group key state by group ID, and our commits awaiting the relay
+	groupKeys      map[string]*crypto.GroupState
+	pendingCommits map[string]pendingCommit
+
+	// Message requests from unknown senders, followed by blocked users;
+	// one selection spans both
+	requests     []storage.Request
+	blocked      []string
+	requestIndex int
+
+	// New group form; the name goes in textInput
+	memberInput textinput.Model
 
 	// LAN Network list
-	lanPeers           []discovery.Peer
-	lanSelectionIndex  int
+	lanPeers          []discovery.Peer
+	lanSelectionIndex int
 }
 
 type reconnectMsg struct{}
+type retryTickMsg struct{}
 type wsMessage struct {
 	packet models.Packet
 }
@@ -78,8 +156,9 @@ type wsErrorMsg struct {
 	err error
 }
 type setupResult struct {
-	err error
-	cfg *config.Config
+	err      error
+	cfg      *config.Config
+	restored bool
 }
 type searchResult struct {
 	users []*models.User
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"syncra/internal/client/storage"
+	"syncra/internal/discovery"
+	"syncra/internal/models"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Give up on a LAN message after this many failed posts to a visible peer.
+const maxLANAttempts = 5
+
+type lanFlushedMsg struct{}
+
+// reloadOutbox refreshes the copy of queued messages behind the chat view's
+// pending and failed markers.
+func (m *model) reloadOutbox() {
+	entries, err := storage.LoadOutbox()
+	if err != nil {
+		slog.Warn("failed to load outbox", "err", err)
+		return
+	}
+	m.outbox = make(map[string]storage.OutboxEntry, len(entries))
+	for _, e := range entries {
+		m.outbox[e.Packet.ID] = e
+	}
+}
+
+// trackOutbox hands queued relay messages from a previous run to the
+// delivery tracker, so they go out once the relay authenticates us.
+func (m *model) trackOutbox() {
+	m.reloadOutbox()
+	for _, e := range m.outbox {
+		if e.Mode == storage.OutboxRelay && !e.Failed {
+			m.delivery.Track(e.Packet)
+		}
+	}
+}
+
+// queueOutgoing persists pkg in the outbox before trying to send it, so a
+// dead connection or an absent peer never loses it.
+func (m *model) queueOutgoing(pkg models.Packet) tea.Cmd {
+	entry := storage.OutboxEntry{Packet: pkg, Mode: storage.OutboxRelay}
+	if m.isLocal {
+		entry.Mode = storage.OutboxLAN
+	}
+	if err := storage.QueueOutbox(entry); err != nil {
+		slog.Error("failed to queue message", "to", pkg.To, "err", err)
+	}
+
+	if m.isLocal {
+		m.reloadOutbox()
+		return m.flushLAN()
+	}
+	if m.sendToRelay(pkg) && !m.receipts() {
+		// Nothing will ack it; handing it over is as good as it gets
+		m.settle(pkg.ID)
+		return nil
+	}
+	m.delivery.Track(pkg)
+	m.reloadOutbox()
+	return nil
+}
+
+// settle removes a delivered message from the outbox.
+func (m *model) settle(id string) {
+	if err := storage.RemoveOutbox(id); err != nil {
+		slog.Error("failed to update outbox", "id", id, "err", err)
+	}
+	m.reloadOutbox()
+}
+
+// failOutgoing marks a message as failed until the user retries it.
+func (m *model) failOutgoing(id, reason string) {
+	err := storage.UpdateOutbox(id, func(e *storage.OutboxEntry) {
+		e.Failed = true
+		e.LastError = reason
+	})
+	if err != nil {
+		slog.Error("failed to update outbox", "id", id, "err", err)
+	}
+	m.reloadOutbox()
+}
+
+// retryFailed re-queues every failed message in the open chat.
+func (m *model) retryFailed() tea.Cmd {
+	for id, e := range m.outbox {
+		if !e.Failed || e.Packet.To != m.chatTarget {
+			continue
+		}
+		err := storage.UpdateOutbox(id, func(e *storage.OutboxEntry) {
+			e.Failed = false
+			e.Attempts = 0
+			e.LastError = ""
+		})
+		if err != nil {
+			slog.Error("failed to update outbox", "id", id, "err", err)
+			continue
+		}
+		slog.Info("retrying message", "to", e.Packet.To, "id", id)
+		if e.Mode == storage.OutboxRelay && !m.isLocal {
+			m.delivery.Track(e.Packet)
+			m.sendToRelay(e.Packet)
+		}
+	}
+	m.reloadOutbox()
+	if m.isLocal {
+		return m.flushLAN()
+	}
+	return nil
+}
+
+// pendingLAN reports whether any LAN message is waiting for its peer.
+func (m model) pendingLAN() bool {
+	for _, e := range m.outbox {
+		if e.Mode == storage.OutboxLAN && !e.Failed {
+			return true
+		}
+	}
+	return false
+}
+
+// flushLAN posts queued LAN messages to whichever recipients are visible
+// right now. Others stay queued until discovery sees them again.
+func (m *model) flushLAN() tea.Cmd {
+	if m.localNode == nil || m.lanFlushing {
+		return nil
+	}
+	m.lanFlushing = true
+	node := m.localNode
+	return func() tea.Msg {
+		entries, err := storage.LoadOutbox()
+		if err != nil {
+			slog.Warn("lan: failed to load outbox", "err", err)
+			return lanFlushedMsg{}
+		}
+		peers := make(map[string]discovery.Peer)
+		for _, p := range node.GetPeers() {
+			peers[p.Username] = p
+		}
+
+		for _, e := range entries {
+			if e.Mode != storage.OutboxLAN || e.Failed {
+				continue
+			}
+			peer, ok := peers[e.Packet.To]
+			if !ok {
+				continue
+			}
+			data, _ := json.Marshal(e.Packet)
+			if err := node.SendMessage(peer.IP, peer.Port, data); err != nil {
+				slog.Warn("lan: send failed", "to", e.Packet.To, "addr", peer.IP+":"+peer.Port, "attempt", e.Attempts+1, "err", err)
+				storage.UpdateOutbox(e.Packet.ID, func(e *storage.OutboxEntry) {
+					e.Attempts++
+					e.LastError = err.Error()
+					e.Failed = e.Attempts >= maxLANAttempts
+				})
+				continue
+			}
+			if err := storage.RemoveOutbox(e.Packet.ID); err != nil {
+				slog.Error("lan: failed to update outbox", "id", e.Packet.ID, "err", err)
+			}
+		}
+		return lanFlushedMsg{}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"syncra/internal/client/storage"
+	"syncra/internal/models"
+	"syncra/internal/protocol"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// reactionChoices are offered by the picker, in order; digits 1-6 pick
+// them directly.
+var reactionChoices = []string{"👍", "❤️", "😂", "😮", "😢", "🎉"}
+
+// startReaction opens the picker for the selected message.
+func (m *model) startReaction() {
+	msg, ok := m.selection()
+	if !ok {
+		return
+	}
+	if msg.Deleted || msg.ID == "" {
+		m.err = fmt.Errorf("this message cannot take reactions")
+		return
+	}
+	m.reacting = true
+	m.reactIndex = 0
+	m.err = nil
+}
+
+// pickReaction handles a key while the picker is open.
+func (m *model) pickReaction(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyLeft:
+		m.reactIndex = (m.reactIndex + len(reactionChoices) - 1) % len(reactionChoices)
+	case tea.KeyRight, tea.KeyTab:
+		m.reactIndex = (m.reactIndex + 1) % len(reactionChoices)
+	case tea.KeyEnter:
+		m.reacting = false
+		return m.react(reactionChoices[m.reactIndex])
+	case tea.KeyEsc:
+		m.reacting = false
+	case tea.KeyRunes:
+		if n := int(msg.Runes[0] - '1'); len(msg.Runes) == 1 && n >= 0 && n < len(reactionChoices) {
+			m.reacting = false
+			return m.react(reactionChoices[n])
+		}
+	}
+	return nil
+}
+
+// react toggles our emoji on the selected message, here and for the other
+// side.
+func (m *model) react(emoji string) tea.Cmd {
+	msg, ok := m.selection()
+	if !ok {
+		return nil
+	}
+	on := !storage.HasReacted(msg, m.cfg.Username, emoji)
+	group := m.chatGroup != nil
+	var pkg models.Packet
+	var err error
+	if group {
+		var sealed models.ChatPayload
+		if sealed, err = m.sealGroupChat(m.chatTarget, emoji); err == nil {
+			pkg, err = protocol.GroupReaction(m.cfg.Username, m.chatTarget, msg.ID, sealed, !on)
+		}
+	} else {
+		pkg, err = protocol.Reaction(m.cfg.Username, m.chatTarget, msg.ID, emoji, !on)
+	}
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	sendCmd := m.queueOutgoing(pkg)
+
+	if err := setReaction(m.chatTarget, group, msg.ID, m.cfg.Username, emoji, on); err != nil {
+		slog.Error("failed to store reaction", "chat", m.chatTarget, "id", msg.ID, "err", err)
+	}
+	m.reloadMessages()
+	return sendCmd
+}
+
+// applyReaction stores a reaction received from the relay.
+func (m *model) applyReaction(p models.Packet) {
+	r, err := protocol.DecodeReaction(p)
+	if err != nil {
+		slog.Warn("invalid reaction payload", "from", p.From, "err", err)
+		return
+	}
+	if r.Group {
+		emoji, err := m.openGroupChat(p, *r.Sealed)
+		if err == nil {
+			err = protocol.ValidateEmoji(emoji)
+		}
+		if err != nil {
+			slog.Warn("dropping group reaction", "group", p.To, "from", p.From, "target", r.Target, "err", err)
+			return
+		}
+		r.Emoji = emoji
+	}
+	m.applyUpdate(p, r.Group, storeReaction(p, r))
+}
+
+// storeReaction applies a received reaction; shared with the LAN receiver.
+func storeReaction(p models.Packet, r models.ReactionPayload) error {
+	return logUpdate(p, r.Group, r.Target, setReaction(chatOf(p, r.Group), r.Group, r.Target, p.From, r.Emoji, !r.Removed))
+}
+
+func setReaction(chat string, group bool, id, user, emoji string, on bool) error {
+	if group {
+		return storage.SetGroupReaction(chat, id, user, emoji, on)
+	}
+	return storage.SetReaction(chat, id, user, emoji, on)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"syncra/internal/config"
+	"syncra/internal/crypto"
+)
+
+// An identity can be written down as a recovery phrase with `syncra
+// phrase` and brought back in the setup wizard: ctrl+r on the username
+// step asks for the phrase instead of creating a new key.
+
+const phraseUsage = `syncra phrase - show the recovery phrase for your identity
+
+Usage:
+  syncra phrase
+
+The 24 words rebuild your identity key. Write them down and keep them
+offline: anyone who has them can sign in as you. To use them, press
+ctrl+r on the username step when setting up syncra.
+`
+
+// The setup input is widened while it takes a recovery phrase
+const (
+	defaultCharLimit = 156
+	phraseCharLimit  = 400
+)
+
+func runPhrase(args []string) error {
+	fs := flag.NewFlagSet("phrase", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, phraseUsage) }
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil || cfg == nil {
+		return fmt.Errorf("no workspace: run syncra to set one up first")
+	}
+	priv, err := loadIdentity(cfg.WorkspacePath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Recovery phrase for @%s. Anyone with these words can sign in as you.\n\n", cfg.Username)
+	words := crypto.RecoveryPhrase(priv)
+	for i, w := range words {
+		if (i+1)%4 == 0 {
+			fmt.Printf("%2d. %s\n", i+1, w)
+		} else {
+			fmt.Printf("%2d. %-10s", i+1, w)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"syncra/internal/client/storage"
+	"syncra/internal/protocol"
+)
+
+// reloadRequests refreshes the message requests inbox and block list.
+func (m *model) reloadRequests() {
+	requests, err := storage.ListRequests()
+	if err != nil {
+		slog.Warn("failed to list message requests", "err", err)
+		return
+	}
+	blocked, err := storage.LoadBlocked()
+	if err != nil {
+		slog.Warn("failed to load block list", "err", err)
+		return
+	}
+	m.requests, m.blocked = requests, blocked
+	if n := len(m.requests) + len(m.blocked); m.requestIndex >= n {
+		m.requestIndex = max(n-1, 0)
+	}
+}
+
+// acceptRequest turns a sender's request into a regular chat and opens it.
+func (m *model) acceptRequest(from string) {
+	if err := storage.AcceptRequest(from); err != nil {
+		m.err = err
+		return
+	}
+	m.reloadRequests()
+	m.reloadChats()
+	m.openChat(from)
+}
+
+// ignoreRequest discards a sender's pending messages. They can still write
+// again, which opens a new request.
+func (m *model) ignoreRequest(from string) {
+	if err := storage.DropRequest(from); err != nil {
+		m.err = err
+		return
+	}
+	m.reloadRequests()
+}
+
+// setBlocked blocks or unblocks username. The local list takes effect at
+// once and covers LAN mode; with a relay, its block_list reply replaces it.
+func (m *model) setBlocked(username string, blocked bool) {
+	list := make([]string, 0, len(m.blocked)+1)
+	for _, u := range m.blocked {
+		if u != username {
+			list = append(list, u)
+		}
+	}
+	if blocked {
+		list = append(list, username)
+		if err := storage.DropRequest(username); err != nil {
+			slog.Warn("failed to drop message request", "from", username, "err", err)
+		}
+	}
+	if err := storage.SaveBlocked(list); err != nil {
+		m.err = err
+		return
+	}
+	slog.Info("block list changed", "user", username, "blocked", blocked)
+	m.reloadRequests()
+
+	if m.isLocal {
+		return
+	}
+	pkt, err := protocol.Block(username, blocked)
+	if err == nil && !m.sendToRelay(pkt) {
+		if blocked {
+			err = fmt.Errorf("blocked locally; the relay will be told once connected")
+		} else {
+			err = fmt.Errorf("not connected to the relay; the block may come back on reconnect")
+		}
+	}
+	m.err = err
+}
+
+// applyBlockList stores the relay's block list. Blocks made while offline
+// are pushed to the relay first and kept, so they are enforced there too.
+func (m *model) applyBlockList(relay []string) {
+	onRelay := make(map[string]bool, len(relay))
+	for _, u := range relay {
+		onRelay[u] = true
+	}
+	blocked := append([]string{}, relay...)
+	for _, u := range m.blocked {
+		if onRelay[u] {
+			continue
+		}
+		if pkt, err := protocol.Block(u, true); err == nil && m.sendToRelay(pkt) {
+			blocked = append(blocked, u)
+		}
+	}
+	if err := storage.SaveBlocked(blocked); err != nil {
+		slog.Error("failed to save block list", "err", err)
+	}
+	m.reloadRequests()
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syncra/internal/client/storage"
+	"syncra/internal/models"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Message search looks through every local history via the index in the
+// workspace. Besides words, a query takes from:<user>, in:<user> or
+// in:#<group>, and after:, before: and on: with a date, today or
+// yesterday. Picking a hit opens its chat with the message selected.
+
+// maxSearchHits is how many hits a search shows.
+const maxSearchHits = 50
+
+type messageSearchResult struct {
+	query string
+	hits  []storage.SearchHit
+	err   error
+}
+
+// openMessageSearch switches to the search screen, starting from query.
+func (m *model) openMessageSearch(query string) {
+	m.state = stateMessageSearch
+	m.findInput.SetValue(query)
+	m.findInput.CursorEnd()
+	m.findInput.Focus()
+	m.findHits, m.findIndex, m.findQuery = nil, 0, ""
+	m.err = nil
+}
+
+// searchScope is the in: filter naming the open chat.
+func (m model) searchScope() string {
+	if m.chatGroup == nil {
+		return "in:@" + m.chatTarget + " "
+	}
+	if strings.ContainsAny(m.chatGroup.Name, " \t") {
+		return "in:#" + m.chatGroup.ID + " "
+	}
+	return "in:#" + m.chatGroup.Name + " "
+}
+
+// searchMessages runs the query in the background.
+func (m model) searchMessages(input string) tea.Cmd {
+	q, err := m.parseSearch(input)
+	if err != nil {
+		return func() tea.Msg { return messageSearchResult{query: input, err: err} }
+	}
+	return func() tea.Msg {
+		hits, err := storage.Search(q, maxSearchHits)
+		return messageSearchResult{query: input, hits: hits, err: err}
+	}
+}
+
+// parseSearch turns the search box into a query.
+func (m model) parseSearch(input string) (storage.SearchQuery, error) {
+	var q storage.SearchQuery
+	for _, field := range strings.Fields(input) {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok || value == "" {
+			q.Terms = append(q.Terms, storage.SearchTerms(field)...)
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "from":
+			q.From = strings.TrimPrefix(value, "@")
+			if q.From == "me" {
+				q.From = m.cfg.Username
+			}
+		case "in":
+			chat, err := m.searchChat(value)
+			if err != nil {
+				return q, err
+			}
+			q.Chat = chat
+		case "after", "before", "on":
+			day, err := parseSearchDate(value)
+			if err != nil {
+				return q, err
+			}
+			switch strings.ToLower(key) {
+			case "after":
+				q.After = day
+			case "before":
+				q.Before = day
+			default:
+				q.After, q.Before = day, day.AddDate(0, 0, 1)
+			}
+		default:
+			q.Terms = append(q.Terms, storage.SearchTerms(field)...)
+		}
+	}
+	if len(q.Terms) == 0 && q.From == "" {
+		return q, fmt.Errorf("search for a word or from:user")
+	}
+	return q, nil
+}
+
+// searchChat resolves an in: value: #name or #id for a group, otherwise
+// a username.
+func (m model) searchChat(value string) (string, error) {
+	name, group := strings.CutPrefix(value, "#")
+	if !group {
+		return strings.TrimPrefix(value, "@"), nil
+	}
+	for _, g := range m.groups {
+		if strings.EqualFold(g.Name, name) || g.ID == name {
+			return storage.GroupKey(g.ID), nil
+		}
+	}
+	return "", fmt.Errorf("no group named %q", name)
+}
+
+// parseSearchDate reads a day as YYYY-MM-DD, today or yesterday, giving
+// its local midnight.
+func parseSearchDate(value string) (time.Time, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	switch strings.ToLower(value) {
+	case "today":
+		return today, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("dates look like 2006-01-02, today or yesterday")
+	}
+	return day, nil
+}
+
+// openHit opens the chat of the selected hit, loading older pages until
+// the message is there to select.
+func (m *model) openHit() {
+	if m.findIndex >= len(m.findHits) {
+		return
+	}
+	hit := m.findHits[m.findIndex]
+	if id, ok := strings.CutPrefix(hit.Chat, storage.GroupKey("")); ok {
+		var group *models.Group
+		for i := range m.groups {
+			if m.groups[i].ID == id {
+				group = &m.groups[i]
+			}
+		}
+		if group == nil {
+			m.err = fmt.Errorf("you are no longer in that group")
+			return
+		}
+		m.openGroup(*group)
+	} else {
+		m.openChat(hit.Chat)
+	}
+
+	for {
+		for i, msg := range m.chatMessages {
+			if msg.ID == hit.Message.ID {
+				// Scroll to the selection rather than the bottom
+				m.msgCursor = i
+				m.viewSeen, m.viewCursor = len(m.chatMessages), -1
+				m.keepScroll = false
+				return
+			}
+		}
+		if !m.loadOlder() {
+			m.err = fmt.Errorf("that message is no longer in the chat")
+			return
+		}
+	}
+}
+
+// hitLine renders one search hit: where, when, who and what.
+func hitLine(hit storage.SearchHit, chats map[string]string) string {
+	where := chats[hit.Chat]
+	if where == "" {
+		where = "@" + hit.Chat
+	}
+	when := hit.Message.Timestamp.Local()
+	format := "Jan 2 15:04"
+	if when.Year() != time.Now().Year() {
+		format = "Jan 2 2006"
+	}
+	from := "@" + hit.Message.From
+	if hit.Message.IsMe {
+		from = "you"
+	}
+	snippet := strings.Join(strings.Fields(hit.Message.Content), " ")
+	if r := []rune(snippet); len(r) > 48 {
+		snippet = string(r[:48]) + "…"
+	}
+	return fmt.Sprintf("%s  %s  %s: %s", where, when.Format(format), from, snippet)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"syncra/internal/client/storage"
+	"syncra/internal/config"
+	"syncra/internal/server/database"
+)
+
+// saveSettings applies the settings form. A new workspace path moves the
+// workspace there; a syncra folder already in the way waits in
+// stateConfirmMove until the user says what to do with it, and is then
+// saved again with their choice.
+func (m *model) saveSettings(mode storage.MoveMode) {
+	path := m.textInput.Value()
+	fullName := m.nameInput.Value()
+
+	if path == "" || fullName == "" {
+		m.err = fmt.Errorf("fields cannot be empty")
+		return
+	}
+
+	// 1. Move Workspace (Local Only)
+	moved := false
+	if path != m.cfg.WorkspacePath {
+		rel, err := m.moveWorkspace(path, mode)
+		if errors.Is(err, storage.ErrWorkspaceExists) {
+			m.state = stateConfirmMove
+			m.err = nil
+			return
+		}
+		if err != nil {
+			m.err = fmt.Errorf("failed to move workspace: %v", err)
+			return
+		}
+		m.cfg.WorkspacePath = rel.Config.WorkspacePath
+		m.textInput.SetValue(m.cfg.WorkspacePath)
+		m.notice = movedNotice(rel)
+		moved = true
+	}
+
+	// 2. Update Full Name (Local & Server)
+	if fullName != m.cfg.FullName {
+		db, err := database.Connect()
+		if err != nil {
+			m.state = stateSettings
+			m.err = fmt.Errorf("failed to connect to server: %v", err)
+			return
+		}
+		defer db.Close()
+
+		err = db.UpdateFullName(context.Background(), m.cfg.Username, fullName)
+		if err != nil {
+			m.state = stateSettings
+			m.err = fmt.Errorf("failed to update server: %v", err)
+			return
+		}
+		m.cfg.FullName = fullName
+	}
+
+	if err := config.SaveConfig(m.cfg); err != nil {
+		slog.Error("failed to save config", "err", err)
+		m.state = stateSettings
+		m.err = fmt.Errorf("failed to save config: %v", err)
+		return
+	}
+	m.err = nil
+	if moved {
+		// Stay to show where everything went
+		m.state = stateSettings
+		return
+	}
+	m.state = stateMain
+}
+
+// moveWorkspace moves the workspace to path, taking the log file along.
+func (m *model) moveWorkspace(path string, mode storage.MoveMode) (*storage.Relocation, error) {
+	from := m.cfg.WorkspacePath
+	initLogging("")
+	rel, err := storage.MoveWorkspace(path, mode)
+	if err != nil {
+		initLogging(from)
+		if !errors.Is(err, storage.ErrWorkspaceExists) {
+			slog.Error("workspace move failed", "from", from, "to", path, "err", err)
+		}
+		return nil, err
+	}
+	initLogging(rel.Config.WorkspacePath)
+	slog.Info("workspace moved", "from", from, "to", rel.Config.WorkspacePath,
+		"files", rel.Files, "bytes", rel.Bytes, "set_aside", rel.SetAside, "left", rel.Left)
+	return rel, nil
+}
+
+// movedNotice says where a move left things.
+func movedNotice(rel *storage.Relocation) string {
+	dst := filepath.Join(rel.Config.WorkspacePath, "syncra")
+	if rel.Files == 0 && rel.Left != "" {
+		return fmt.Sprintf("now using %s; the old folder is still at %s", dst, rel.Left)
+	}
+	s := fmt.Sprintf("moved %d files to %s", rel.Files, dst)
+	if rel.SetAside != "" {
+		s += fmt.Sprintf("; the folder that was there is now %s", filepath.Base(rel.SetAside))
+	}
+	if rel.Left != "" {
+		s += fmt.Sprintf("; %s could not be removed", rel.Left)
+	}
+	return s
+}
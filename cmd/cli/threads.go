@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"syncra/internal/models"
+)
+
+// A reply names the message it answers in reply_to. Following those links
+// up from any message reaches the root of its thread; the thread view
+// shows the root and everything that leads back to it.
+
+// shownMessages is the open chat as displayed: everything, or one thread.
+func (m model) shownMessages() []models.LocalChatMessage {
+	if m.thread == "" {
+		return m.chatMessages
+	}
+	return replyChain(m.chatMessages, m.thread)
+}
+
+// startReply makes the next message a reply to the selected one.
+func (m *model) startReply() {
+	msg, ok := m.selection()
+	if !ok || msg.Deleted || msg.ID == "" {
+		m.err = fmt.Errorf("this message cannot be replied to")
+		return
+	}
+	m.replyTo = msg.ID
+	m.msgCursor = -1
+	m.err = nil
+}
+
+// openThread narrows the chat to the selected message's thread.
+func (m *model) openThread() {
+	msg, ok := m.selection()
+	if !ok {
+		return
+	}
+	m.thread = threadRoot(replyParents(m.chatMessages), msg.ID)
+	m.msgCursor = -1
+	m.scrollToBottom()
+	m.err = nil
+}
+
+// replyTarget is what an outgoing message answers: the chosen message, or
+// in the thread view the thread's latest message, so it stays in there.
+func (m model) replyTarget() string {
+	if m.replyTo != "" || m.thread == "" {
+		return m.replyTo
+	}
+	if shown := m.shownMessages(); len(shown) > 0 {
+		return shown[len(shown)-1].ID
+	}
+	return m.thread
+}
+
+// findMessage looks up a message of the open chat by ID, among those
+// loaded or resolved for quoting.
+func (m model) findMessage(id string) (models.LocalChatMessage, bool) {
+	for _, msg := range m.chatMessages {
+		if msg.ID == id {
+			return msg, true
+		}
+	}
+	msg, ok := m.quoted[id]
+	return msg, ok && msg.ID != ""
+}
+
+// replyParents maps each message ID to the ID it replies to.
+func replyParents(messages []models.LocalChatMessage) map[string]string {
+	parent := make(map[string]string, len(messages))
+	for _, msg := range messages {
+		parent[msg.ID] = msg.ReplyTo
+	}
+	return parent
+}
+
+// threadRoot follows reply_to links up from id as far as the history
+// goes.
+func threadRoot(parent map[string]string, id string) string {
+	seen := make(map[string]bool)
+	for !seen[id] {
+		seen[id] = true
+		up, ok := parent[id]
+		if !ok || up == "" {
+			break
+		}
+		if _, known := parent[up]; !known {
+			break
+		}
+		id = up
+	}
+	return id
+}
+
+// replyChain returns the messages whose thread root is root, in order.
+func replyChain(messages []models.LocalChatMessage, root string) []models.LocalChatMessage {
+	parent := replyParents(messages)
+	var chain []models.LocalChatMessage
+	for _, msg := range messages {
+		if threadRoot(parent, msg.ID) == root {
+			chain = append(chain, msg)
+		}
+	}
+	return chain
+}
@@ -1,16 +1,16 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"path/filepath"
+	"log/slog"
+	"strings"
+	"syncra/internal/client/export"
 	"syncra/internal/client/storage"
 	clientWS "syncra/internal/client/websocket"
-	"syncra/internal/config"
 	"syncra/internal/crypto"
 	"syncra/internal/models"
-	"syncra/internal/server/database"
+	"syncra/internal/protocol"
 	"time"
 
 	"github.com/charmbracelet/bubbles/textinput"
@@ -31,6 +31,10 @@ func (m model) Init() tea.Cmd {
 			cmds = append(cmds, func() tea.Msg { return reconnectMsg{} })
 		}
 	}
+	cmds = append(cmds, m.retryTick())
+	if m.cfg != nil && m.cfg.Username != "" {
+		cmds = append(cmds, m.purgeExpired(), m.purgeTick())
+	}
 	if m.isLocal {
 		// Periodically poll for messages or peer changes if we wanted.
 		cmds = append(cmds, m.pollLocalChats())
@@ -40,7 +44,36 @@ func (m model) Init() tea.Cmd {
 	}
 	return tea.Batch(cmds...)
 }
+
+// Update runs update and then brings the chat viewport in line with
+// whatever it changed.
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case tea.MouseMsg:
+		if m.state != stateChat {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.viewport, cmd = m.viewport.Update(msg)
+		if m.viewport.AtTop() && m.loadOlder() {
+			m.syncViewport()
+		}
+		if m.viewport.AtBottom() {
+			m.newBelow = 0
+		}
+		return m, cmd
+	}
+	next, cmd := m.update(msg)
+	if nm, ok := next.(model); ok && nm.state == stateChat {
+		nm.syncViewport()
+		return nm, cmd
+	}
+	return next, cmd
+}
+
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 
 	switch msg := msg.(type) {
@@ -52,65 +85,270 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.err != nil {
 			m.err = msg.err
 			m.state = stateSetupFullName // Fallback to last valid state or error state
+			if m.restoreKey != nil {
+				m.state = stateSetupPhrase
+				m.restoreKey = nil
+				if m.isLocal {
+					m.textInput.Reset() // Holds the name
+				}
+				m.textInput.CharLimit = phraseCharLimit
+			}
 			return m, nil
 		}
 		m.cfg = msg.cfg
+		m.restoreKey = nil
+		m.textInput.CharLimit = defaultCharLimit
+		initLogging(m.cfg.WorkspacePath)
+		if msg.restored {
+			slog.Info("identity restored", "user", m.cfg.Username, "local", m.isLocal)
+		} else {
+			slog.Info("identity created", "user", m.cfg.Username, "local", m.isLocal)
+		}
 		m.state = stateSuccess
 		return m, nil
 
 	case wsMessage:
 		p := msg.packet
 		switch p.Type {
+		case models.TypeHello:
+			agreed, err := protocol.DecodeHello(p)
+			if err != nil {
+				slog.Warn("invalid hello reply", "err", err)
+				break
+			}
+			if m.conn != nil {
+				m.conn.Protocol = agreed
+				slog.Info("protocol negotiated", "version", agreed.Version, "features", agreed.Features, "codec", m.conn.Codec.Name())
+			}
 		case models.TypeChallenge:
-			var challenge string
-			json.Unmarshal(p.Payload, &challenge)
+			challenge, err := protocol.DecodeChallenge(p)
+			if err != nil {
+				slog.Warn("invalid challenge payload", "err", err)
+				break
+			}
 			// Sign challenge
-			keyPath := filepath.Join(m.cfg.WorkspacePath, "syncra", "identities", "id_ed25519")
-			priv, _ := crypto.LoadPrivateKey(keyPath)
+			priv, err := m.identityKey()
+			if err != nil {
+				slog.Error("cannot load identity key", "err", err)
+				m.err = err
+				break
+			}
 			sig := crypto.Sign(priv, []byte(challenge))
 			// Send Auth
-			auth := models.AuthPayload{Username: m.cfg.Username, Signature: sig}
-			authData, _ := json.Marshal(auth)
-			m.conn.Send <- models.Packet{Type: models.TypeAuth, Payload: authData}
+			auth, err := protocol.Auth(m.cfg.Username, sig)
+			if err != nil {
+				slog.Error("cannot build auth packet", "err", err)
+				m.err = err
+				break
+			}
+			m.conn.Send <- auth
 		case models.TypeChat:
-			var chat models.ChatPayload
-			json.Unmarshal(p.Payload, &chat)
+			chat, err := protocol.DecodeChat(p)
+			if err != nil {
+				slog.Warn("invalid chat payload", "from", p.From, "err", err)
+				break
+			}
 			localMsg := models.LocalChatMessage{
+				ID:        p.ID,
 				From:      p.From,
 				Content:   chat.Message,
 				Timestamp: p.Timestamp,
 				IsMe:      false,
+				ReplyTo:   chat.ReplyTo,
+				ExpiresAt: expiresAt(p, chat),
+			}
+			open := m.state == stateChat && m.chatGroup == nil && m.chatTarget == p.From
+			request, err := storage.ReceiveMessage(p.From, localMsg)
+			if errors.Is(err, storage.ErrDuplicate) {
+				// Redelivered after a lost ack; already shown once
+				slog.Debug("duplicate message dropped", "from", p.From, "id", p.ID)
+				break
+			} else if errors.Is(err, storage.ErrBlocked) {
+				slog.Debug("message from blocked sender dropped", "from", p.From)
+				break
+			} else if err != nil {
+				slog.Error("failed to store message", "from", p.From, "err", err)
+			}
+			if request {
+				if !open {
+					m.reloadRequests()
+					break
+				}
+				// We opened this conversation ourselves, so no need to ask
+				if err := storage.AcceptRequest(p.From); err != nil {
+					slog.Error("failed to accept message request", "from", p.From, "err", err)
+				}
 			}
-			storage.AppendMessage(p.From, localMsg)
-			if m.state == stateChat && m.chatTarget == p.From {
+			if open {
 				m.chatMessages = append(m.chatMessages, localMsg)
+				m.markRead()
+			} else if c := m.contacts[p.From]; c.Archived && !c.Muted {
+				// New activity brings an archived chat back, unless muted
+				storage.UpdateContact(p.From, func(c *storage.Contact) { c.Archived = false })
 			}
 			// Refresh chats list
-			m.chats, _ = storage.ListChats()
+			m.reloadChats()
+		case models.TypeGroupChat:
+			chat, err := protocol.DecodeGroupChat(p)
+			if err != nil {
+				slog.Warn("invalid group chat payload", "from", p.From, "err", err)
+				break
+			}
+			// Kept even when unreadable, so the gap shows
+			content, _ := m.openGroupChat(p, chat)
+			localMsg := models.LocalChatMessage{
+				ID:        p.ID,
+				From:      p.From,
+				Content:   content,
+				Timestamp: p.Timestamp,
+				IsMe:      false,
+				ReplyTo:   chat.ReplyTo,
+				ExpiresAt: expiresAt(p, chat),
+			}
+			if err := storage.AppendGroupMessage(p.To, localMsg); errors.Is(err, storage.ErrDuplicate) {
+				slog.Debug("duplicate group message dropped", "group", p.To, "id", p.ID)
+				break
+			} else if err != nil {
+				slog.Error("failed to store group message", "group", p.To, "err", err)
+			}
+			if m.state == stateChat && m.chatGroup != nil && m.chatTarget == p.To {
+				m.chatMessages = append(m.chatMessages, localMsg)
+			}
+		case models.TypeEdit:
+			m.applyEdit(p)
+		case models.TypeDelete:
+			m.applyDelete(p)
+		case models.TypeReaction:
+			m.applyReaction(p)
+		case models.TypeExpiry:
+			m.applyExpiry(p)
+		case models.TypeGroupInfo:
+			g, err := protocol.DecodeGroupInfo(p)
+			if err != nil {
+				slog.Warn("invalid group info", "err", err)
+				break
+			}
+			m.applyGroupInfo(g)
+		case models.TypeBlockList:
+			users, err := protocol.DecodeBlockList(p)
+			if err != nil {
+				slog.Warn("invalid block list", "err", err)
+				break
+			}
+			m.applyBlockList(users)
+		case models.TypeGroupCommit:
+			m.applyGroupCommit(p)
+		case models.TypeGroupWelcome:
+			m.applyGroupWelcome(p)
+		case models.TypeAck:
+			ack, err := protocol.DecodeAck(p)
+			if err != nil {
+				slog.Warn("invalid ack payload", "err", err)
+				break
+			}
+			if m.commitAcked(ack) {
+				break
+			}
+			sent, ok := m.delivery.Ack(ack)
+			if !ok {
+				break
+			}
+			switch ack.Status {
+			case models.AckDelivered:
+				m.settle(ack.ID)
+			case models.AckOffline:
+				slog.Debug("recipient offline, will retry", "to", sent.To, "id", ack.ID)
+			case models.AckRejected:
+				slog.Warn("message rejected by relay", "to", sent.To, "id", ack.ID, "reason", ack.Reason)
+				m.failOutgoing(ack.ID, ack.Reason)
+				m.err = fmt.Errorf("message to %s not delivered: %s", sent.To, ack.Reason)
+			}
+		case models.TypeSystem:
+			if sysMsg, err := protocol.DecodeText(p); err == nil {
+				slog.Info("relay notice", "message", sysMsg)
+				if sysMsg == protocol.Authenticated {
+					m.authenticated = true
+					// Flush the outbox: replay whatever was sent but never
+					// acked. Without receipts this is the last attempt, as no
+					// ack will come.
+					if m.receipts() {
+						for _, pkt := range m.delivery.Resend() {
+							m.sendToRelay(pkt)
+						}
+					} else {
+						for _, pkt := range m.delivery.Drain() {
+							if m.sendToRelay(pkt) {
+								m.settle(pkt.ID)
+							}
+						}
+					}
+				}
+			}
 		case models.TypeError:
-			var errMsg string
-			json.Unmarshal(p.Payload, &errMsg)
+			errMsg, err := protocol.DecodeText(p)
+			if err != nil {
+				slog.Warn("invalid error payload", "err", err)
+				break
+			}
+			slog.Warn("relay error", "message", errMsg)
 			m.err = fmt.Errorf("%s", errMsg)
 		}
 		return m, m.listenWS()
 
 	case wsErrorMsg:
+		if m.conn != nil {
+			slog.Warn("relay connection lost", "err", msg.err)
+		}
 		m.conn = nil
+		m.authenticated = false
 		// Try to reconnect after 2 seconds
 		return m, tea.Tick(time.Second*2, func(t time.Time) tea.Msg {
 			return reconnectMsg{}
 		})
 
+	case retryTickMsg:
+		now := time.Now()
+		for _, p := range m.delivery.Expired(now) {
+			slog.Warn("giving up on message", "to", p.To, "id", p.ID)
+			m.failOutgoing(p.ID, "not delivered: recipient stayed offline")
+		}
+		if m.authenticated && m.receipts() {
+			for _, p := range m.delivery.Due(now) {
+				m.sendToRelay(p)
+			}
+		}
+		if m.isLocal && m.pendingLAN() {
+			return m, tea.Batch(m.retryTick(), m.flushLAN())
+		}
+		return m, m.retryTick()
+
+	case purgeTickMsg:
+		if m.cfg == nil || m.cfg.Username == "" {
+			return m, m.purgeTick()
+		}
+		return m, tea.Batch(m.purgeTick(), m.purgeExpired())
+
+	case purgedMsg:
+		m.dropExpired(msg.n)
+		return m, nil
+
+	case lanFlushedMsg:
+		m.lanFlushing = false
+		m.reloadOutbox()
+		return m, nil
+
 	case reconnectMsg:
 		if m.cfg == nil || m.cfg.Username == "" {
 			return m, nil
 		}
 		conn, err := clientWS.Connect("localhost:8080")
 		if err == nil {
+			slog.Info("reconnected to relay")
 			m.conn = conn
 			go m.conn.WritePump()
 			return m, m.listenWS()
 		}
+		slog.Debug("reconnect failed", "err", err)
 		// If failed, wait and try again
 		return m, tea.Tick(time.Second*5, func(t time.Time) tea.Msg {
 			return reconnectMsg{}
@@ -134,8 +372,29 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.textInput.Focus()
 		return m, nil
 
+	case exportedMsg:
+		if msg.err != nil {
+			slog.Error("export failed", "chat", m.chatTarget, "err", msg.err)
+			m.err = fmt.Errorf("export failed: %v", msg.err)
+			return m, nil
+		}
+		slog.Info("conversation exported", "path", msg.path)
+		m.notice = "exported to " + msg.path
+		return m, nil
+
+	case messageSearchResult:
+		if msg.err != nil {
+			m.err = msg.err
+			m.findHits, m.findQuery = nil, ""
+			return m, nil
+		}
+		m.findHits, m.findIndex, m.findQuery = msg.hits, 0, msg.query
+		m.err = nil
+		return m, nil
+
 	case searchResult:
 		if msg.err != nil {
+			slog.Warn("search failed", "err", msg.err)
 			m.err = msg.err
 			return m, nil
 		}
@@ -145,6 +404,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "ctrl+c", "q":
+			if msg.String() == "q" && m.typing() {
+				break // Typed into a text field
+			}
 			m.quitting = true
 			return m, tea.Quit
 		case "ctrl+z":
@@ -154,6 +416,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if m.state == stateMain {
 				m.state = stateSettings
 				m.settingsIndex = 0
+				m.notice = ""
 				m.textInput.SetValue(m.cfg.WorkspacePath)
 				m.nameInput.SetValue(m.cfg.FullName)
 				m.textInput.Focus()
@@ -169,6 +432,31 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.err = nil
 				return m, textinput.Blink
 			}
+		case "/":
+			if m.state == stateMain {
+				m.openMessageSearch("")
+				return m, textinput.Blink
+			}
+		case "g":
+			if m.state == stateMain && !m.isLocal {
+				m.state = stateNewGroup
+				m.settingsIndex = 0
+				m.textInput.Reset()
+				m.textInput.Placeholder = "group name..."
+				m.textInput.Focus()
+				m.memberInput.Reset()
+				m.memberInput.Blur()
+				m.err = nil
+				return m, textinput.Blink
+			}
+		case "r":
+			if m.state == stateMain {
+				m.state = stateRequests
+				m.reloadRequests()
+				m.requestIndex = 0
+				m.err = nil
+				return m, nil
+			}
 		case "l":
 			if m.state == stateMain && m.isLocal {
 				m.state = stateLanNetwork
@@ -179,8 +467,31 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 		case "esc":
-			if m.state == stateSettings || m.state == stateSearch || m.state == stateChat || m.state == stateLanNetwork {
+			if m.state == stateSettings || m.state == stateSearch || m.state == stateChat || m.state == stateLanNetwork || m.state == stateNewGroup || m.state == stateRequests || m.state == stateNickname || m.state == stateMessageSearch {
+				if m.state == stateChat {
+					if m.reacting {
+						m.reacting = false
+						return m, nil
+					}
+					if m.editing != "" || m.replyTo != "" || m.msgCursor >= 0 {
+						// Leave edit mode, the reply and the selection first,
+						// then the thread view, then the chat
+						if m.editing != "" {
+							m.cancelEdit()
+						}
+						m.replyTo = ""
+						m.msgCursor = -1
+						return m, nil
+					}
+					if m.thread != "" {
+						m.thread = ""
+						m.scrollToBottom()
+						return m, nil
+					}
+					m.markRead()
+				}
 				m.state = stateMain
+				m.reloadChats()
 				return m, nil
 			}
 		case "x":
@@ -197,16 +508,79 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.chatSelectionIndex--
 				}
 			} else if msg.Type == tea.KeyDown {
-				if m.chatSelectionIndex < len(m.chats)-1 {
+				if m.chatSelectionIndex < len(m.chats)+len(m.groups)-1 {
 					m.chatSelectionIndex++
 				}
-			} else if msg.Type == tea.KeyEnter && len(m.chats) > 0 {
-				m.chatTarget = m.chats[m.chatSelectionIndex]
-				m.state = stateChat
-				m.chatMessages, _ = storage.LoadMessages(m.chatTarget)
-				m.chatInput.Focus()
+			} else if msg.Type == tea.KeyEnter {
+				if i := m.chatSelectionIndex; i < len(m.chats) {
+					m.openChat(m.chats[i].Username)
+				} else if i-len(m.chats) < len(m.groups) {
+					m.openGroup(m.groups[i-len(m.chats)])
+				}
+				return m, nil
+			}
+
+			// Contact management for the selected 1:1 chat
+			switch msg.String() {
+			case "p":
+				m.updateContact(func(c *storage.Contact) { c.Pinned = !c.Pinned })
+			case "m":
+				m.updateContact(func(c *storage.Contact) { c.Muted = !c.Muted })
+			case "a":
+				m.updateContact(func(c *storage.Contact) { c.Archived = !c.Archived })
+			case "v":
+				m.showArchived = !m.showArchived
+				m.chatSelectionIndex = 0
+				m.reloadChats()
+			case "n":
+				if c, ok := m.selectedContact(); ok {
+					m.state = stateNickname
+					m.nicknameTarget = c.Username
+					m.textInput.Reset()
+					m.textInput.Placeholder = "nickname for @" + c.Username + "..."
+					m.textInput.SetValue(c.Nickname)
+					m.textInput.Focus()
+					m.err = nil
+					return m, textinput.Blink
+				}
+			}
+			return m, nil
+
+		case stateNickname:
+			if msg.Type == tea.KeyEnter {
+				m.setNickname(m.nicknameTarget, m.textInput.Value())
+				m.state = stateMain
 				return m, nil
 			}
+			m.textInput, cmd = m.textInput.Update(msg)
+			return m, cmd
+
+		case stateRequests:
+			if msg.Type == tea.KeyUp {
+				if m.requestIndex > 0 {
+					m.requestIndex--
+				}
+				return m, nil
+			} else if msg.Type == tea.KeyDown {
+				if m.requestIndex < len(m.requests)+len(m.blocked)-1 {
+					m.requestIndex++
+				}
+				return m, nil
+			}
+			if i := m.requestIndex; i < len(m.requests) {
+				from := m.requests[i].From
+				switch msg.String() {
+				case "enter", "a":
+					m.acceptRequest(from)
+				case "i":
+					m.ignoreRequest(from)
+				case "b":
+					m.setBlocked(from, true)
+				}
+			} else if i-len(m.requests) < len(m.blocked) && msg.String() == "u" {
+				m.setBlocked(m.blocked[i-len(m.requests)], false)
+			}
+			return m, nil
 
 		case stateLanNetwork:
 			if msg.Type == tea.KeyUp {
@@ -218,10 +592,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.lanSelectionIndex++
 				}
 			} else if msg.Type == tea.KeyEnter && len(m.lanPeers) > 0 {
-				m.chatTarget = m.lanPeers[m.lanSelectionIndex].Username
-				m.state = stateChat
-				m.chatMessages, _ = storage.LoadMessages(m.chatTarget)
-				m.chatInput.Focus()
+				m.openChat(m.lanPeers[m.lanSelectionIndex].Username)
 				return m, nil
 			}
 		case stateSetupWorkspace:
@@ -247,10 +618,61 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.err = fmt.Errorf("username cannot be empty")
 					return m, nil
 				}
+				if err := protocol.ValidateUsername(username); err != nil {
+					m.err = err
+					return m, nil
+				}
 				m.tempUsername = username
 				m.err = nil
 				return m, checkUsername(username, m.isLocal)
 			}
+			if msg.String() == "ctrl+r" {
+				// Restore an existing identity from its recovery phrase
+				// Accounts from before the username rules still restore
+				username := m.textInput.Value()
+				if err := protocol.ValidateExistingUsername(username); err != nil {
+					m.err = fmt.Errorf("type the username to restore first")
+					return m, nil
+				}
+				m.tempUsername = username
+				m.err = nil
+				m.state = stateSetupPhrase
+				m.textInput.Reset()
+				m.textInput.CharLimit = phraseCharLimit
+				m.textInput.Placeholder = "recovery phrase..."
+				m.textInput.Focus()
+				return m, nil
+			}
+
+		case stateSetupPhrase:
+			if msg.Type == tea.KeyEsc {
+				m.err = nil
+				m.state = stateSetupUsername
+				m.textInput.CharLimit = defaultCharLimit
+				m.textInput.SetValue(m.tempUsername)
+				m.textInput.Placeholder = "choose username..."
+				return m, nil
+			}
+			if msg.Type == tea.KeyEnter {
+				key, err := crypto.KeyFromRecoveryPhrase(m.textInput.Value())
+				if err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.restoreKey = key
+				m.err = nil
+				if m.isLocal {
+					// No server to take the name from
+					m.state = stateSetupFullName
+					m.textInput.CharLimit = defaultCharLimit
+					m.textInput.Reset()
+					m.textInput.Placeholder = "full name..."
+					m.textInput.Focus()
+					return m, nil
+				}
+				m.state = stateSetupProcessing
+				return m, tea.Batch(m.performSetup(), m.spinner.Tick)
+			}
 
 		case stateSetupFullName:
 			if msg.Type == tea.KeyEnter {
@@ -280,44 +702,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 
 			if msg.Type == tea.KeyEnter {
-				path := m.textInput.Value()
-				fullName := m.nameInput.Value()
-
-				if path == "" || fullName == "" {
-					m.err = fmt.Errorf("fields cannot be empty")
-					return m, nil
-				}
-
-				// 1. Update Workspace (Local Only)
-				if path != m.cfg.WorkspacePath {
-					err := config.InitializeStructure(path)
-					if err != nil {
-						m.err = err
-						return m, nil
-					}
-					m.cfg.WorkspacePath = path
-				}
-
-				// 2. Update Full Name (Local & Server)
-				if fullName != m.cfg.FullName {
-					db, err := database.Connect()
-					if err != nil {
-						m.err = fmt.Errorf("failed to connect to server: %v", err)
-						return m, nil
-					}
-					defer db.Close()
-
-					err = db.UpdateFullName(context.Background(), m.cfg.Username, fullName)
-					if err != nil {
-						m.err = fmt.Errorf("failed to update server: %v", err)
-						return m, nil
-					}
-					m.cfg.FullName = fullName
-				}
-
-				config.SaveConfig(m.cfg)
-				m.state = stateMain
-				m.err = nil
+				m.saveSettings(storage.MoveRefuse)
 				return m, nil
 			}
 
@@ -333,10 +718,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				query := m.searchInput.Value()
 				// If we have search results, enter starts a chat with the first one for now
 				if len(m.searchResults) > 0 {
-					m.chatTarget = m.searchResults[0].Username
-					m.state = stateChat
-					m.chatMessages, _ = storage.LoadMessages(m.chatTarget)
-					m.chatInput.Focus()
+					m.openChat(m.searchResults[0].Username)
 					return m, nil
 				}
 				// Searching locally or remotely depending on isLocal
@@ -345,54 +727,202 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.searchInput, cmd = m.searchInput.Update(msg)
 			return m, cmd
 
+		case stateMessageSearch:
+			switch msg.Type {
+			case tea.KeyUp:
+				if m.findIndex > 0 {
+					m.findIndex--
+				}
+				return m, nil
+			case tea.KeyDown:
+				if m.findIndex < len(m.findHits)-1 {
+					m.findIndex++
+				}
+				return m, nil
+			case tea.KeyEnter:
+				// Enter searches, and on the same query again opens the hit
+				query := m.findInput.Value()
+				if query == m.findQuery && len(m.findHits) > 0 {
+					m.openHit()
+					return m, nil
+				}
+				return m, m.searchMessages(query)
+			}
+			m.findInput, cmd = m.findInput.Update(msg)
+			return m, cmd
+
 		case stateChat:
+			m.notice = ""
+			if m.reacting {
+				return m, m.pickReaction(msg)
+			}
+			switch msg.Type {
+			case tea.KeyCtrlF:
+				m.openMessageSearch(m.searchScope())
+				return m, textinput.Blink
+			case tea.KeyUp:
+				if m.msgCursor == 0 {
+					m.loadOlder()
+				}
+				m.moveCursor(-1)
+				return m, nil
+			case tea.KeyDown:
+				m.moveCursor(1)
+				return m, nil
+			case tea.KeyPgUp:
+				if !m.viewport.AtTop() || !m.loadOlder() {
+					m.viewport.PageUp()
+				}
+				return m, nil
+			case tea.KeyPgDown:
+				m.viewport.PageDown()
+				return m, nil
+			case tea.KeyCtrlE, tea.KeyCtrlD, tea.KeyCtrlT, tea.KeyTab, tea.KeyCtrlO:
+				if m.msgCursor < 0 {
+					break
+				}
+				switch msg.Type {
+				case tea.KeyCtrlE:
+					m.startEdit()
+				case tea.KeyTab:
+					m.startReply()
+				case tea.KeyCtrlO:
+					m.openThread()
+				case tea.KeyCtrlT:
+					m.startReaction()
+				default:
+					return m, m.deleteSelected()
+				}
+				return m, nil
+			}
+			if msg.Type == tea.KeyEnter && m.editing != "" {
+				if content := m.chatInput.Value(); content != "" {
+					return m, m.sendEdit(content)
+				}
+				m.cancelEdit()
+				return m, nil
+			}
 			if msg.Type == tea.KeyEnter {
 				content := m.chatInput.Value()
+				if ok, cmd := m.timerCommand(content); ok {
+					m.chatInput.Reset()
+					return m, cmd
+				}
+				if ok, cmd := m.exportCommand(content); ok {
+					m.chatInput.Reset()
+					return m, cmd
+				}
+				if m.chatGroup != nil && m.groupCommand(content) {
+					m.chatInput.Reset()
+					return m, nil
+				}
+				if m.chatGroup == nil && strings.TrimSpace(content) == "/block" {
+					m.chatInput.Reset()
+					m.setBlocked(m.chatTarget, true)
+					m.state = stateMain
+					return m, nil
+				}
 				if content != "" {
 					// 1. Send via Mode
-					pkg := models.Packet{
-						Type:      models.TypeChat,
-						From:      m.cfg.Username,
-						To:        m.chatTarget,
-						Payload:   json.RawMessage(`{"message": "` + content + `"}`),
-						Timestamp: time.Now(),
-					}
-
-					if m.isLocal {
-						if m.localNode != nil {
-							// Find the peer by username from active peers
-							peers := m.localNode.GetPeers()
-							for _, p := range peers {
-								if p.Username == m.chatTarget {
-									data, _ := json.Marshal(pkg)
-									go m.localNode.SendMessage(p.IP, p.Port, data)
-									break
-								}
-							}
-						}
-					} else {
-						if m.conn != nil {
-							m.conn.Send <- pkg
+					build := protocol.Chat
+					chat := models.ChatPayload{Message: content}
+					var err error
+					if m.chatGroup != nil {
+						build = protocol.GroupChat
+						if chat, err = m.sealGroupChat(m.chatTarget, content); err != nil {
+							m.err = err
+							return m, nil
 						}
 					}
-
-					// 2. Storage Locally
+					chat.ReplyTo = m.replyTarget()
 					localMsg := models.LocalChatMessage{
 						From:      m.cfg.Username,
 						Content:   content,
 						Timestamp: time.Now(),
 						IsMe:      true,
+						ReplyTo:   chat.ReplyTo,
+					}
+					m.stampExpiry(&chat, &localMsg)
+					pkg, err := build(m.cfg.Username, m.chatTarget, chat)
+					if err != nil {
+						m.err = err
+						return m, nil
+					}
+
+					// Queued first, so nothing is lost while offline
+					sendCmd := m.queueOutgoing(pkg)
+
+					// 2. Storage Locally
+					localMsg.ID = pkg.ID
+					store := storage.AppendMessage
+					if m.chatGroup != nil {
+						store = storage.AppendGroupMessage
+					}
+					if err := store(m.chatTarget, localMsg); err != nil {
+						slog.Error("failed to store message", "to", m.chatTarget, "err", err)
 					}
-					storage.AppendMessage(m.chatTarget, localMsg)
 					m.chatMessages = append(m.chatMessages, localMsg)
 					m.chatInput.Reset()
+					m.replyTo = ""
+					m.scrollToBottom()
 					// Refresh chats list
-					m.chats, _ = storage.ListChats()
+					m.reloadChats()
+					return m, sendCmd
 				}
 			}
+			if msg.Type == tea.KeyCtrlR {
+				return m, m.retryFailed()
+			}
+			if msg.Type == tea.KeyCtrlX {
+				return m, m.exportOpenChat(exportOptions{format: export.Markdown, manifest: true})
+			}
 			m.chatInput, cmd = m.chatInput.Update(msg)
 			return m, cmd
 
+		case stateNewGroup:
+			if msg.Type == tea.KeyTab || msg.Type == tea.KeyShiftTab || msg.Type == tea.KeyUp || msg.Type == tea.KeyDown {
+				if m.settingsIndex == 0 {
+					m.settingsIndex = 1
+					m.textInput.Blur()
+					m.memberInput.Focus()
+				} else {
+					m.settingsIndex = 0
+					m.memberInput.Blur()
+					m.textInput.Focus()
+				}
+				return m, textinput.Blink
+			}
+
+			if msg.Type == tea.KeyEnter {
+				if err := m.createGroup(m.textInput.Value(), m.memberInput.Value()); err != nil {
+					m.err = err
+					return m, nil
+				}
+				// The relay answers with group_info, which adds it to the list
+				m.state = stateMain
+				m.err = nil
+				return m, nil
+			}
+
+			if m.settingsIndex == 0 {
+				m.textInput, cmd = m.textInput.Update(msg)
+			} else {
+				m.memberInput, cmd = m.memberInput.Update(msg)
+			}
+			return m, cmd
+
+		case stateConfirmMove:
+			switch msg.String() {
+			case "r":
+				m.saveSettings(storage.MoveReplace)
+			case "u":
+				m.saveSettings(storage.MoveAdopt)
+			case "esc":
+				m.state = stateSettings
+				m.err = nil
+			}
+			return m, nil
+
 		case stateConfirmPurge:
 			if msg.String() == "y" {
 				return m, m.performSelfDestruct()
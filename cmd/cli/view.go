@@ -2,6 +2,10 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
+	"syncra/internal/client/storage"
+	"syncra/internal/models"
 	"syncra/internal/ui"
 	"time"
 
@@ -41,7 +45,18 @@ func (m model) View() string {
 			inner += "\n\n" + ui.ErrorTextStyle.Render("! "+m.err.Error())
 		}
 		content = inner
-		footer = ui.FooterStyle.Render("enter: check • esc: quit")
+		footer = ui.FooterStyle.Render("enter: check • ctrl+r: restore existing identity • esc: quit")
+
+	case stateSetupPhrase:
+		subHeader = ui.SubHeaderStyle.Render("setup / restore") + "\n"
+		inner := ui.MutedStyle.Render("Enter the 24-word recovery phrase for "+m.tempUsername+".") + "\n"
+		inner += ui.MutedStyle.Render("`syncra phrase` shows it on a machine that is still set up.") + "\n\n"
+		inner += ui.InfoKeyStyle.Render("phrase") + "\n" + m.textInput.View()
+		if m.err != nil {
+			inner += "\n\n" + ui.ErrorTextStyle.Render("! "+m.err.Error())
+		}
+		content = inner
+		footer = ui.FooterStyle.Render("enter: restore • esc: back")
 
 	case stateSetupFullName:
 		subHeader = ui.SubHeaderStyle.Render("setup / profile") + "\n"
@@ -55,7 +70,15 @@ func (m model) View() string {
 
 	case stateSetupProcessing:
 		subHeader = ui.SubHeaderStyle.Render("creating identity...") + "\n"
-		content = fmt.Sprintf("\n  %s %s\n", m.spinner.View(), ui.MutedStyle.Render("Generating cryptographic keys & registering..."))
+		status := "Generating cryptographic keys & registering..."
+		if m.restoreKey != nil {
+			subHeader = ui.SubHeaderStyle.Render("restoring identity...") + "\n"
+			status = "Checking your key with the server..."
+			if m.isLocal {
+				status = "Restoring your key..."
+			}
+		}
+		content = fmt.Sprintf("\n  %s %s\n", m.spinner.View(), ui.MutedStyle.Render(status))
 		footer = ""
 
 	case stateSuccess:
@@ -81,28 +104,102 @@ func (m model) View() string {
 
 		// Chats Section
 		friendsList := ""
+		title := "RECENT CHATS"
+		if m.showArchived {
+			title = "ARCHIVED CHATS"
+		}
 		if len(m.chats) > 0 {
-			friendsList = "\n" + ui.SectionTitleStyle.Render(fmt.Sprintf("RECENT CHATS (%d)", len(m.chats))) + "\n"
-			for i, friend := range m.chats {
+			friendsList = "\n" + ui.SectionTitleStyle.Render(fmt.Sprintf("%s (%d)", title, len(m.chats))) + "\n"
+			for i, chat := range m.chats {
 				cursor := "  "
 				style := ui.InfoValueStyle
 				if i == m.chatSelectionIndex {
 					cursor = lipgloss.NewStyle().Foreground(ui.Primary).Render("» ")
 					style = ui.SelectedStyle
 				}
-				friendsList += fmt.Sprintf("%s %s\n", cursor, style.Render(friend))
+				friendsList += fmt.Sprintf("%s %s\n", cursor, chatRow(chat, style))
 			}
-		} else {
+		} else if m.showArchived {
+			friendsList = "\n" + ui.MutedStyle.Render("No archived conversations.")
+		} else if len(m.groups) == 0 {
 			friendsList = "\n" + ui.MutedStyle.Render("No recent conversations.")
 		}
+		if len(m.groups) > 0 {
+			friendsList += "\n" + ui.SectionTitleStyle.Render(fmt.Sprintf("GROUPS (%d)", len(m.groups))) + "\n"
+			for i, g := range m.groups {
+				cursor := "  "
+				style := ui.InfoValueStyle
+				if len(m.chats)+i == m.chatSelectionIndex {
+					cursor = lipgloss.NewStyle().Foreground(ui.Primary).Render("» ")
+					style = ui.SelectedStyle
+				}
+				friendsList += fmt.Sprintf("%s %s %s\n", cursor, style.Render("#"+g.Name), ui.MutedStyle.Render(fmt.Sprintf("%d members", len(g.Members))))
+			}
+		}
+
+		if len(m.requests) > 0 {
+			friendsList = "\n" + ui.StatusLabelStyle.Foreground(ui.Warning).Render(fmt.Sprintf("✉ %d message requests", len(m.requests))) +
+				ui.MutedStyle.Render("  (r to review)") + "\n" + friendsList
+		}
 
 		content = statusContent + "\n" + friendsList
 		if m.isLocal {
-			footer = ui.FooterStyle.Render("↑/↓: select chat • s: settings • f: find • l: lan peers • q: quit")
+			footer = ui.FooterStyle.Render("↑/↓: select chat • p/m/a/n: pin/mute/archive/nickname • v: archived • r: requests • s: settings • f: find • /: search messages • l: lan peers • q: quit")
 		} else {
-			footer = ui.FooterStyle.Render("↑/↓: select chat • s: settings • f: find • q: quit")
+			footer = ui.FooterStyle.Render("↑/↓: select chat • p/m/a/n: pin/mute/archive/nickname • v: archived • g: new group • r: requests • s: settings • f: find • /: search messages • q: quit")
+		}
+
+	case stateNickname:
+		subHeader = ui.SubHeaderStyle.Render("contacts / @"+m.nicknameTarget) + "\n"
+		inner := ui.SectionTitleStyle.Render("NICKNAME") + "\n" + m.textInput.View() + "\n"
+		inner += ui.MutedStyle.Render("only you see this; leave blank to use their username") + "\n"
+		content = inner
+		footer = ui.FooterStyle.Render("enter: save • esc: back")
+
+	case stateRequests:
+		subHeader = ui.SubHeaderStyle.Render("message requests") + "\n"
+
+		inner := ui.SectionTitleStyle.Render(fmt.Sprintf("REQUESTS (%d)", len(m.requests))) + "\n"
+		if len(m.requests) == 0 {
+			inner += ui.MutedStyle.Render("  No one new has written to you.") + "\n"
+		}
+		for i, r := range m.requests {
+			cursor := "  "
+			style := ui.InfoValueStyle
+			if i == m.requestIndex {
+				cursor = lipgloss.NewStyle().Foreground(ui.Primary).Render("» ")
+				style = ui.SelectedStyle
+			}
+			preview := ""
+			if n := len(r.Messages); n > 0 {
+				preview = r.Messages[n-1].Content
+				if len([]rune(preview)) > 40 {
+					preview = string([]rune(preview)[:40]) + "…"
+				}
+			}
+			inner += fmt.Sprintf("%s %s %s\n", cursor, style.Render("@"+r.From),
+				ui.MutedStyle.Render(fmt.Sprintf("(%d) %s", len(r.Messages), preview)))
 		}
 
+		if len(m.blocked) > 0 {
+			inner += "\n" + ui.SectionTitleStyle.Render(fmt.Sprintf("BLOCKED (%d)", len(m.blocked))) + "\n"
+			for i, u := range m.blocked {
+				cursor := "  "
+				style := ui.MutedStyle
+				if len(m.requests)+i == m.requestIndex {
+					cursor = lipgloss.NewStyle().Foreground(ui.Primary).Render("» ")
+					style = ui.SelectedStyle
+				}
+				inner += fmt.Sprintf("%s %s\n", cursor, style.Render("@"+u))
+			}
+		}
+
+		if m.err != nil {
+			inner += "\n" + ui.ErrorTextStyle.Render("! "+m.err.Error())
+		}
+		content = inner
+		footer = ui.FooterStyle.Render("a/enter: accept • i: ignore • b: block • u: unblock • esc: back")
+
 	case stateLanNetwork:
 		subHeader = ui.SubHeaderStyle.Render("network / lan peers") + "\n"
 
@@ -145,34 +242,106 @@ func (m model) View() string {
 		content = inner
 		footer = ui.FooterStyle.Render("enter: search/chat • esc: back")
 
+	case stateMessageSearch:
+		subHeader = ui.SubHeaderStyle.Render("search messages") + "\n"
+		inner := ui.InfoKeyStyle.Render("query") + "\n" + m.findInput.View() + "\n\n"
+
+		if len(m.findHits) > 0 {
+			chats := make(map[string]string, len(m.groups))
+			for _, g := range m.groups {
+				chats[storage.GroupKey(g.ID)] = "#" + g.Name
+			}
+			inner += ui.SectionTitleStyle.Render(fmt.Sprintf("RESULTS (%d)", len(m.findHits))) + "\n"
+			for i, hit := range m.findHits {
+				cursor := "  "
+				style := ui.InfoValueStyle
+				if i == m.findIndex {
+					cursor = lipgloss.NewStyle().Foreground(ui.Primary).Render("» ")
+					style = ui.SelectedStyle
+				}
+				inner += fmt.Sprintf("%s %s\n", cursor, style.Render(hitLine(hit, chats)))
+			}
+		} else if m.findQuery != "" {
+			inner += ui.MutedStyle.Render("No messages found.") + "\n"
+		}
+
+		if m.err != nil {
+			inner += "\n\n" + ui.ErrorTextStyle.Render("! "+m.err.Error())
+		}
+		content = inner
+		footer = ui.FooterStyle.Render("enter: search/open • ↑/↓: select • esc: back")
+
 	case stateChat:
 		statusStr := ui.StatusLabelStyle.Foreground(ui.Success).Render("● online")
 		if m.conn == nil {
 			statusStr = ui.StatusLabelStyle.Foreground(ui.ErrorCol).Render("○ offline")
 		}
-		subHeader = ui.SubHeaderStyle.Render("chat / "+m.chatTarget+"  "+statusStr) + "\n"
+		if ttl := m.timer(); ttl > 0 {
+			statusStr += "  " + ui.StatusLabelStyle.Foreground(ui.Warning).Render("⏱ "+formatTimer(ttl))
+		}
+		subHeader = ui.SubHeaderStyle.Render("chat / "+m.displayName(m.chatTarget)+"  "+statusStr) + "\n"
+		if m.chatGroup != nil {
+			members := ui.MutedStyle.Render(strings.Join(m.chatGroup.Members, ", "))
+			keys := ui.StatusLabelStyle.Foreground(ui.Warning).Render("○ waiting for keys")
+			if st, ok := m.groupKeys[m.chatGroup.ID]; ok {
+				keys = ui.StatusLabelStyle.Foreground(ui.Success).Render(fmt.Sprintf("● encrypted, epoch %d", st.Epoch()))
+			}
+			subHeader = ui.SubHeaderStyle.Render("group / #"+m.chatGroup.Name+"  "+statusStr+"  "+keys) + "\n" + members + "\n"
+		}
 
 		var chatContent string
-		if len(m.chatMessages) == 0 {
-			chatContent = ui.MutedStyle.Render("No messages yet. Say hello!")
+		if m.thread != "" {
+			chatContent = ui.SectionTitleStyle.Render(fmt.Sprintf("THREAD · %d messages", len(m.shownMessages()))) + "\n"
+		}
+		chatContent += m.viewport.View() + "\n"
+		if m.newBelow > 0 {
+			chatContent += ui.StatusLabelStyle.Foreground(ui.Warning).Render(fmt.Sprintf("↓ %d new below", m.newBelow)) + ui.MutedStyle.Render(" • pgdn") + "\n"
+		} else if !m.viewport.AtBottom() {
+			chatContent += ui.MutedStyle.Render("↓ more below") + "\n"
 		} else {
-			// Show last 12 messages for better vertical space
-			start := 0
-			if len(m.chatMessages) > 12 {
-				start = len(m.chatMessages) - 12
-			}
-			for i := start; i < len(m.chatMessages); i++ {
-				msg := m.chatMessages[i]
-				prefix := lipgloss.NewStyle().Foreground(ui.Secondary).Render("@" + msg.From + ":")
-				if msg.IsMe {
-					prefix = ui.SelectedStyle.Render("You:")
-				}
-				chatContent += fmt.Sprintf("%s %s\n", prefix, msg.Content)
-			}
+			chatContent += "\n"
+		}
+
+		content = chatContent
+		if m.reacting {
+			content += m.reactionPicker() + "\n"
+		}
+		if m.replyTo != "" {
+			content += ui.MutedStyle.Render("replying to ") + m.quote(m.replyTo) + ui.MutedStyle.Render(" • esc: cancel") + "\n"
+		}
+		if m.editing != "" {
+			content += ui.MutedStyle.Render("editing message • esc: cancel") + "\n"
+		}
+		content += m.chatInput.View()
+		if m.err != nil {
+			content += "\n" + ui.ErrorTextStyle.Render("! "+m.err.Error())
+		} else if m.notice != "" {
+			content += "\n" + ui.MutedStyle.Render("✓ "+m.notice)
+		}
+		back := "esc: back"
+		if m.thread != "" {
+			back = "esc: all messages"
+		}
+		footer = ui.FooterStyle.Render("enter: send • /block, /timer, /export • ↑: select • pgup/pgdn: scroll • ctrl+r: retry failed • ctrl+f: search • ctrl+x: export • " + back)
+		if m.chatGroup != nil {
+			footer = ui.FooterStyle.Render("enter: send • /invite, /remove, /leave, /rotate, /timer, /export • ↑: select • pgup/pgdn: scroll • ctrl+r: retry failed • ctrl+f: search • ctrl+x: export • " + back)
+		}
+		if m.msgCursor >= 0 {
+			footer = ui.FooterStyle.Render("↑/↓: select • tab: reply • ctrl+o: thread • ctrl+t: react • ctrl+e: edit • ctrl+d: delete • esc: unselect")
 		}
 
-		content = chatContent + "\n" + m.chatInput.View()
-		footer = ui.FooterStyle.Render("enter: send • esc: back")
+	case stateNewGroup:
+		subHeader = ui.SubHeaderStyle.Render("new group") + "\n"
+
+		inner := ui.SectionTitleStyle.Render("NAME") + "\n" + m.textInput.View() + "\n\n"
+		inner += ui.SectionTitleStyle.Render("MEMBERS") + "\n" + m.memberInput.View() + "\n"
+		inner += ui.MutedStyle.Render("comma separated usernames; you are added as owner") + "\n"
+
+		if m.err != nil {
+			inner += "\n" + ui.ErrorTextStyle.Render("! "+m.err.Error())
+		}
+		content = inner
+		footer = ui.FooterStyle.Render("tab: switch field • enter: create • esc: back")
 
 	case stateSettings:
 		subHeader = ui.SubHeaderStyle.Render("settings") + "\n"
@@ -182,10 +351,24 @@ func (m model) View() string {
 
 		if m.err != nil {
 			inner += "\n\n" + ui.ErrorTextStyle.Render("! "+m.err.Error())
+		} else if m.notice != "" {
+			inner += ui.MutedStyle.Render("✓ "+m.notice) + "\n"
 		}
 		content = inner
 		footer = ui.FooterStyle.Render("enter: save • x: self-destruct • esc: back")
 
+	case stateConfirmMove:
+		subHeader = ui.SubHeaderStyle.Render("settings / move workspace") + "\n"
+		dst := filepath.Join(m.textInput.Value(), "syncra")
+		inner := ui.ErrorTextStyle.Render(dst+" already exists.") + "\n\n"
+		inner += " r  replace it; it is kept beside it as syncra-replaced-<time>\n"
+		inner += " u  use it as it is, if it holds your identity; nothing is moved\n"
+		if m.err != nil {
+			inner += "\n" + ui.ErrorTextStyle.Render("! "+m.err.Error())
+		}
+		content = inner
+		footer = ui.FooterStyle.Render("r: replace • u: use existing • esc: cancel")
+
 	case stateConfirmPurge:
 		subHeader = ui.SubHeaderStyle.Render("danger / self-destruct") + "\n"
 		inner := ui.ErrorTextStyle.Render("ARE YOU ABSOLUTELY SURE?") + "\n\n"
@@ -194,13 +377,99 @@ func (m model) View() string {
 		inner += " - Delete your local chat history\n"
 		inner += " - Delete your cryptographic keys\n"
 		inner += " - Reset this application\n\n"
+		inner += ui.MutedStyle.Render("Moving to another machine? Quit and run 'syncra backup' instead.") + "\n"
 		inner += ui.MutedStyle.Render("Press 'y' to confirm or 'n' to cancel")
 
 		content = inner
 		footer = ui.FooterStyle.Render("y/n")
 	}
 
-	body = ui.MainContainerStyle.Render(subHeader + content)
+	container := ui.MainContainerStyle
+	if m.state == stateChat && m.width > 0 {
+		// Messages wrap to the terminal; see chatWidth
+		container = container.Width(m.width)
+	}
+	body = container.Render(subHeader + content)
 
 	return fmt.Sprintf("%s%s\n%s", header, body, footer)
 }
+
+// quote renders a one-line snippet of the message with the given ID.
+func (m model) quote(id string) string {
+	msg, ok := m.findMessage(id)
+	switch {
+	case !ok:
+		return ui.MutedStyle.Render("┃ original message not available")
+	case msg.Deleted:
+		return ui.MutedStyle.Render("┃ message deleted")
+	}
+	snippet := strings.Join(strings.Fields(msg.Content), " ")
+	if r := []rune(snippet); len(r) > 40 {
+		snippet = string(r[:40]) + "…"
+	}
+	return ui.MutedStyle.Render("┃ @" + msg.From + ": " + snippet)
+}
+
+// reactionsLine aggregates msg's reactions, ours highlighted.
+func (m model) reactionsLine(msg models.LocalChatMessage) string {
+	var parts []string
+	for _, r := range storage.Reactions(msg) {
+		style := ui.MutedStyle
+		if storage.HasReacted(msg, m.cfg.Username, r.Emoji) {
+			style = ui.SelectedStyle
+		}
+		parts = append(parts, style.Render(fmt.Sprintf("%s %d", r.Emoji, len(r.Users))))
+	}
+	return strings.Join(parts, "  ")
+}
+
+// reactionPicker renders the emoji choices with the current one marked.
+func (m model) reactionPicker() string {
+	var parts []string
+	for i, emoji := range reactionChoices {
+		item := fmt.Sprintf("%d %s", i+1, emoji)
+		if i == m.reactIndex {
+			item = ui.SelectedStyle.Render("[" + item + "]")
+		} else {
+			item = ui.MutedStyle.Render(" " + item + " ")
+		}
+		parts = append(parts, item)
+	}
+	return "react: " + strings.Join(parts, " ") + ui.MutedStyle.Render("  ←/→ • enter • esc")
+}
+
+// chatRow renders one entry of the chat list: name, flags, unread count and
+// a preview of the last message.
+func chatRow(c storage.ChatSummary, style lipgloss.Style) string {
+	row := style.Render(c.DisplayName())
+	if c.Nickname != "" {
+		row += " " + ui.MutedStyle.Render("@"+c.Username)
+	}
+	if c.Pinned {
+		row += " " + lipgloss.NewStyle().Foreground(ui.Warning).Render("★")
+	}
+	if c.Unread > 0 {
+		badge := lipgloss.NewStyle().Foreground(ui.Success).Bold(true)
+		if c.Muted {
+			badge = ui.MutedStyle
+		}
+		row += " " + badge.Render(fmt.Sprintf("(%d)", c.Unread))
+	}
+	if c.Muted {
+		row += " " + ui.MutedStyle.Render("muted")
+	}
+	if c.Last != nil {
+		preview := c.Last.Content
+		if c.Last.Deleted {
+			preview = "message deleted"
+		}
+		if c.Last.IsMe {
+			preview = "You: " + preview
+		}
+		if r := []rune(preview); len(r) > 32 {
+			preview = string(r[:32]) + "…"
+		}
+		row += "\n     " + ui.MutedStyle.Render(preview+" · "+c.Last.Timestamp.Local().Format("Jan 2 15:04"))
+	}
+	return row
+}
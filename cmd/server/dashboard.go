@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"syncra/internal/server/database"
+	"syncra/internal/server/router"
+	"syncra/internal/server/websocket"
+	"syncra/internal/ui"
+	"time"
+
+	"github.com/charmbracelet/bubbles/table"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	// Throughput samples kept for the sparkline, one per tick.
+	sparkWidth = 48
+
+	// Log lines shown under the session table.
+	logLines = 6
+)
+
+var sparkLevels = []rune("▁▂▃▄▅▆▇█")
+
+type errMsg error
+
+type dbConnectedMsg struct {
+	db *database.DB
+}
+
+type serverModel struct {
+	db        *database.DB
+	hub       *websocket.Hub
+	srv       *http.Server
+	adminSrv  *http.Server
+	err       error
+	loading   bool
+	startTime time.Time
+	tick      int
+	port      string
+
+	// Live session table
+	sessions table.Model
+	notice   string
+	kicking  string // Session awaiting confirmation of a kick
+
+	// Packets relayed per tick, newest last
+	throughput  []uint64
+	lastRelayed uint64
+
+	logs *logBuffer
+}
+
+func initialModel(logs *logBuffer) serverModel {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	t := table.New(
+		table.WithColumns([]table.Column{
+			{Title: "USER", Width: 18},
+			{Title: "REMOTE", Width: 22},
+			{Title: "CONNECTED", Width: 12},
+		}),
+		table.WithHeight(8),
+		table.WithFocused(true),
+	)
+	styles := table.DefaultStyles()
+	styles.Header = styles.Header.Foreground(ui.Secondary).Bold(true).BorderForeground(ui.Muted)
+	styles.Selected = styles.Selected.Foreground(ui.Primary).Bold(true)
+	t.SetStyles(styles)
+
+	return serverModel{
+		loading:   true,
+		startTime: time.Now(),
+		port:      port,
+		sessions:  t,
+		logs:      logs,
+	}
+}
+
+type tickMsg time.Time
+
+func tick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
+		return tickMsg(t)
+	})
+}
+
+func connectToDB() tea.Msg {
+	db, err := database.Connect()
+	if err != nil {
+		return errMsg(err)
+	}
+	return dbConnectedMsg{db: db}
+}
+
+func (m serverModel) Init() tea.Cmd {
+	return tea.Batch(connectToDB, tick())
+}
+
+// refresh pulls the session list and throughput counter from the hub.
+func (m *serverModel) refresh() {
+	if m.hub == nil {
+		return
+	}
+
+	var rows []table.Row
+	for _, s := range m.hub.Sessions() {
+		rows = append(rows, table.Row{
+			s.Username,
+			s.RemoteAddr,
+			time.Since(s.ConnectedAt).Truncate(time.Second).String(),
+		})
+	}
+	m.sessions.SetRows(rows)
+	if m.sessions.Cursor() >= len(rows) && len(rows) > 0 {
+		m.sessions.SetCursor(len(rows) - 1)
+	}
+
+	relayed := m.hub.Stats().PacketsRelayed
+	m.throughput = append(m.throughput, relayed-m.lastRelayed)
+	if len(m.throughput) > sparkWidth {
+		m.throughput = m.throughput[len(m.throughput)-sparkWidth:]
+	}
+	m.lastRelayed = relayed
+}
+
+func (m serverModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "ctrl+c":
+			// Draining happens in main once the program has exited
+			return m, tea.Quit
+		}
+		if m.kicking != "" {
+			// Any key other than y backs out of the kick
+			if msg.String() == "y" && m.hub != nil {
+				if m.hub.Kick(m.kicking, "disconnected by operator") {
+					m.notice = "kicked " + m.kicking
+				} else {
+					m.notice = m.kicking + " already left"
+				}
+				m.refresh()
+			} else {
+				m.notice = ""
+			}
+			m.kicking = ""
+			return m, nil
+		}
+		switch msg.String() {
+		case "x", "delete":
+			if row := m.sessions.SelectedRow(); row != nil && m.hub != nil {
+				m.kicking = row[0]
+				m.notice = fmt.Sprintf("kick %s? y to confirm, any other key to cancel", row[0])
+			}
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.sessions, cmd = m.sessions.Update(msg)
+		return m, cmd
+
+	case tickMsg:
+		m.tick++
+		m.refresh()
+		return m, tick()
+
+	case dbConnectedMsg:
+		m.loading = false
+		m.db = msg.db
+		m.hub = websocket.NewHub()
+		m.srv = &http.Server{Addr: ":" + m.port, Handler: router.New(m.hub, m.db)}
+		m.adminSrv = newAdminServer(m.hub, m.db)
+		startAdmin(m.adminSrv)
+		return m, startRelay(m.hub, m.srv)
+
+	case errMsg:
+		m.loading = false
+		m.err = msg
+		return m, nil
+	}
+	return m, nil
+}
+
+func (m serverModel) View() string {
+	// 1. Header Section
+	header := ui.HeaderStyle.Render(" SYNCRA NODE v1.0.0 ") + "\n"
+	subHeader := ui.SubHeaderStyle.Render("Secure Relay Infrastructure • Zero Knowledge") + "\n"
+
+	// 2. Status Content
+	var statusContent string
+	if m.loading {
+		statusContent = fmt.Sprintf("\n  %s\n  %s",
+			ui.StatusLabelStyle.Background(ui.Warning).Foreground(lipgloss.Color("#000000")).Render(" CONNECTING "),
+			ui.MutedStyle.Render("Orchestrating database handshake..."))
+	} else if m.err != nil {
+		statusContent = fmt.Sprintf("\n  %s\n  %s",
+			ui.StatusLabelStyle.Background(ui.ErrorCol).Foreground(lipgloss.Color("#FFFFFF")).Render(" FATAL ERROR "),
+			ui.ErrorTextStyle.Render(m.err.Error()))
+	} else {
+		onlineTag := " ONLINE "
+		if m.tick%2 == 0 {
+			onlineTag = " • ONLINE "
+		}
+
+		stats := m.hub.Stats()
+		admin := "disabled"
+		if m.adminSrv != nil {
+			admin = m.adminSrv.Addr
+		}
+
+		statusContent = fmt.Sprintf("%s\n\n%s %s\n%s %s\n%s %s\n%s %s\n%s %s\n%s %s",
+			ui.StatusLabelStyle.Background(ui.Success).Foreground(lipgloss.Color("#FFFFFF")).Render(onlineTag),
+			ui.InfoKeyStyle.Render("Database"), ui.InfoValueStyle.Render(m.dbDetails()),
+			ui.InfoKeyStyle.Render("Endpoint"), ui.InfoValueStyle.Render("ws://"+m.srv.Addr+"/ws"),
+			ui.InfoKeyStyle.Render("Transport"), ui.InfoValueStyle.Render("WebSocket, JSON or MessagePack (TLS terminated upstream)"),
+			ui.InfoKeyStyle.Render("Admin API"), ui.InfoValueStyle.Render(admin),
+			ui.InfoKeyStyle.Render("Connections"), ui.InfoValueStyle.Render(fmt.Sprintf("%d open, %d authenticated", stats.Connections, stats.Sessions)),
+			ui.InfoKeyStyle.Render("Uptime"), ui.InfoValueStyle.Foreground(ui.Secondary).Render(time.Since(m.startTime).Truncate(time.Second).String()),
+		)
+
+		// Sessions Section
+		statusContent += "\n\n" + ui.SectionTitleStyle.Render(fmt.Sprintf("SESSIONS (%d)", stats.Sessions)) + "\n"
+		if stats.Sessions == 0 {
+			statusContent += ui.MutedStyle.Render("No authenticated sessions.") + "\n"
+		} else {
+			statusContent += m.sessions.View() + "\n"
+		}
+		if m.notice != "" {
+			statusContent += ui.MutedStyle.Render("» "+m.notice) + "\n"
+		}
+
+		// Throughput Section
+		statusContent += "\n" + ui.SectionTitleStyle.Render("THROUGHPUT") + "\n"
+		statusContent += lipgloss.NewStyle().Foreground(ui.Secondary).Render(sparkline(m.throughput)) + "\n"
+		statusContent += ui.MutedStyle.Render(fmt.Sprintf("%d pkt/s now • %d relayed total", last(m.throughput), stats.PacketsRelayed)) + "\n"
+	}
+
+	// Log Section
+	if m.logs != nil {
+		if lines := m.logs.Tail(logLines); len(lines) > 0 {
+			statusContent += "\n" + ui.SectionTitleStyle.Render("LOG") + "\n"
+			statusContent += ui.MutedStyle.Render(strings.Join(lines, "\n"))
+		}
+	}
+
+	body := ui.CardStyle.Width(88).Render(statusContent)
+
+	// 3. Footer Section
+	footer := ui.FooterStyle.Render("↑/↓: select session • x: kick • q: graceful shutdown")
+
+	return fmt.Sprintf("%s%s%s\n%s", header, subHeader, body, footer)
+}
+
+// dbDetails describes the connected database from the live pool.
+func (m serverModel) dbDetails() string {
+	if m.db == nil || m.db.Pool == nil {
+		return "not connected"
+	}
+	cc := m.db.Pool.Config().ConnConfig
+	st := m.db.Pool.Stat()
+	return fmt.Sprintf("postgres://%s/%s (%d/%d conns)", cc.Host, cc.Database, st.AcquiredConns(), st.TotalConns())
+}
+
+// sparkline renders samples scaled to the largest one, padded to
+// sparkWidth so the graph scrolls in from the right.
+func sparkline(samples []uint64) string {
+	var peak uint64
+	for _, s := range samples {
+		if s > peak {
+			peak = s
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.Repeat(" ", sparkWidth-len(samples)))
+	for _, s := range samples {
+		level := 0
+		if peak > 0 {
+			level = int(s * uint64(len(sparkLevels)-1) / peak)
+		}
+		b.WriteRune(sparkLevels[level])
+	}
+	return b.String()
+}
+
+func last(samples []uint64) uint64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	return samples[len(samples)-1]
+}
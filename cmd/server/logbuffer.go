@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// logBuffer keeps the most recent log lines so the dashboard can show them
+// instead of letting the standard logger scribble over the TUI.
+type logBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	max   int
+}
+
+func newLogBuffer(max int) *logBuffer {
+	return &logBuffer{max: max}
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		b.lines = append(b.lines, line)
+	}
+	if over := len(b.lines) - b.max; over > 0 {
+		b.lines = append([]string(nil), b.lines[over:]...)
+	}
+	return len(p), nil
+}
+
+// Tail returns up to n of the newest lines, oldest first.
+func (b *logBuffer) Tail(n int) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n > len(b.lines) {
+		n = len(b.lines)
+	}
+	return append([]string(nil), b.lines[len(b.lines)-n:]...)
+}
@@ -1,148 +1,87 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"log"
+	"log/slog"
 	"net/http"
+	"syncra/internal/logging"
+	"syncra/internal/server/admin"
 	"syncra/internal/server/database"
+	"syncra/internal/server/router"
 	"syncra/internal/server/websocket"
-	"syncra/internal/ui"
 
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/charmbracelet/lipgloss"
 )
 
-type errMsg error
+// Time allowed for in-flight sessions to drain on shutdown.
+const shutdownTimeout = 15 * time.Second
 
-type dbConnectedMsg struct {
-	db *database.DB
-}
+func startRelay(hub *websocket.Hub, srv *http.Server) tea.Cmd {
+	return func() tea.Msg {
+		go hub.Run()
 
-type serverModel struct {
-	db        *database.DB
-	hub       *websocket.Hub
-	err       error
-	loading   bool
-	startTime time.Time
-	tick      int
-	port      string
+		slog.Info("starting relay server", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			return errMsg(fmt.Errorf("server failed: %v", err))
+		}
+		return nil
+	}
 }
 
-func initialModel() serverModel {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+// newAdminServer returns the operator API listener, or nil when ADMIN_TOKEN
+// is unset. It binds to loopback unless ADMIN_ADDR says otherwise.
+func newAdminServer(hub *websocket.Hub, db *database.DB) *http.Server {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		return nil
 	}
-	return serverModel{
-		loading:   true,
-		startTime: time.Now(),
-		port:      port,
+	addr := os.Getenv("ADMIN_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:9090"
 	}
+	return &http.Server{Addr: addr, Handler: admin.NewHandler(hub, db, token)}
 }
 
-type tickMsg time.Time
-
-func tick() tea.Cmd {
-	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
-		return tickMsg(t)
-	})
-}
-
-func connectToDB() tea.Msg {
-	db, err := database.Connect()
-	if err != nil {
-		return errMsg(err)
+func startAdmin(srv *http.Server) {
+	if srv == nil {
+		return
 	}
-	return dbConnectedMsg{db: db}
-}
-
-func (m serverModel) Init() tea.Cmd {
-	return tea.Batch(connectToDB, tick())
+	go func() {
+		slog.Info("starting admin API", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("admin API failed", "err", err)
+		}
+	}()
 }
 
-func startRelay(hub *websocket.Hub, port string) tea.Cmd {
-	return func() tea.Msg {
-		go hub.Run()
-
-		http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-			websocket.ServeWs(hub, w, r)
-		})
+// shutdownRelay stops accepting new upgrades, lets every session flush its
+// queue and receive a going-away close frame, then releases the database.
+func shutdownRelay(hub *websocket.Hub, db *database.DB, servers ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-		log.Printf("Starting relay server on :%s", port)
-		if err := http.ListenAndServe(":"+port, nil); err != nil {
-			return errMsg(fmt.Errorf("server failed: %v", err))
+	for _, srv := range servers {
+		if srv == nil {
+			continue
 		}
-		return nil
-	}
-}
-
-func (m serverModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		if msg.String() == "q" || msg.String() == "ctrl+c" {
-			if m.db != nil {
-				m.db.Close()
-			}
-			return m, tea.Quit
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Warn("HTTP shutdown incomplete", "addr", srv.Addr, "err", err)
 		}
-
-	case tickMsg:
-		m.tick++
-		return m, tick()
-
-	case dbConnectedMsg:
-		m.loading = false
-		m.db = msg.db
-		m.hub = websocket.NewHub()
-		return m, startRelay(m.hub, m.port)
-
-	case errMsg:
-		m.loading = false
-		m.err = msg
-		return m, nil
 	}
-	return m, nil
-}
-
-func (m serverModel) View() string {
-	// 1. Header Section
-	header := ui.HeaderStyle.Render(" SYNCRA NODE v1.0.0 ") + "\n"
-	subHeader := ui.SubHeaderStyle.Render("Secure Relay Infrastructure • Zero Knowledge") + "\n"
-
-	// 2. Status Content
-	var statusContent string
-	if m.loading {
-		statusContent = fmt.Sprintf("\n  %s\n  %s",
-			ui.StatusLabelStyle.Background(ui.Warning).Foreground(lipgloss.Color("#000000")).Render(" CONNECTING "),
-			ui.MutedStyle.Render("Orchestrating database handshake..."))
-	} else if m.err != nil {
-		statusContent = fmt.Sprintf("\n  %s\n  %s",
-			ui.StatusLabelStyle.Background(ui.ErrorCol).Foreground(lipgloss.Color("#FFFFFF")).Render(" FATAL ERROR "),
-			ui.ErrorTextStyle.Render(m.err.Error()))
-	} else {
-		onlineTag := " ONLINE "
-		if m.tick%2 == 0 {
-			onlineTag = " • ONLINE "
+	if hub != nil {
+		if err := hub.Shutdown(ctx); err != nil {
+			slog.Warn("drain incomplete", "err", err)
 		}
-
-		statusContent = fmt.Sprintf("%s\n\n%s %s\n%s %s\n%s %s\n%s %s",
-			ui.StatusLabelStyle.Background(ui.Success).Foreground(lipgloss.Color("#FFFFFF")).Render(onlineTag),
-			ui.InfoKeyStyle.Render("Database"), ui.InfoValueStyle.Render("Neon PostreSQL (Cloud)"),
-			ui.InfoKeyStyle.Render("Endpoint"), ui.InfoValueStyle.Render(":"+m.port+"/ws"),
-			ui.InfoKeyStyle.Render("Protocol"), ui.InfoValueStyle.Render("WSS / AES-256-GCM"),
-			ui.InfoKeyStyle.Render("Uptime"), ui.InfoValueStyle.Foreground(ui.Secondary).Render(time.Since(m.startTime).Truncate(time.Second).String()),
-		)
 	}
-
-	body := ui.CardStyle.Render(statusContent)
-
-	// 3. Footer Section
-	footer := ui.FooterStyle.Render("▸ Press 'q' to gracefully shutdown")
-
-	return fmt.Sprintf("%s%s%s\n%s", header, subHeader, body, footer)
+	if db != nil {
+		db.Close()
+	}
 }
 
 func main() {
@@ -153,29 +92,48 @@ func main() {
 			port = "8080"
 		}
 
+		slog.SetDefault(logging.NewServerLogger(os.Stderr, true))
+
 		db, err := database.Connect()
 		if err != nil {
-			log.Fatalf("Production DB connection failed: %v", err)
+			slog.Error("production DB connection failed", "err", err)
+			os.Exit(1)
 		}
-		defer db.Close()
 
 		hub := websocket.NewHub()
 		go hub.Run()
 
-		http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-			websocket.ServeWs(hub, w, r)
-		})
+		srv := &http.Server{Addr: ":" + port, Handler: router.New(hub, db)}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("server failed", "err", err)
+				os.Exit(1)
+			}
+		}()
+		adminSrv := newAdminServer(hub, db)
+		startAdmin(adminSrv)
+		slog.Info("syncra secure relay started", "mode", "headless", "port", port)
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
 
-		fmt.Printf("🚀 Syncra Secure Relay started in HEADLESS mode on :%s\n", port)
-		if err := http.ListenAndServe(":"+port, nil); err != nil {
-			log.Fatalf("Server failed: %v", err)
-		}
+		slog.Info("signal received, shutting down")
+		shutdownRelay(hub, db, srv, adminSrv)
 		return
 	}
 
-	// Dashboard mode for local monitoring
-	p := tea.NewProgram(initialModel())
-	if _, err := p.Run(); err != nil {
+	// Dashboard mode for local monitoring; keep log output off the TUI
+	logs := newLogBuffer(200)
+	slog.SetDefault(logging.NewServerLogger(logs, false))
+
+	p := tea.NewProgram(initialModel(logs))
+	final, err := p.Run()
+	slog.SetDefault(logging.NewServerLogger(os.Stderr, false))
+	if m, ok := final.(serverModel); ok {
+		shutdownRelay(m.hub, m.db, m.srv, m.adminSrv)
+	}
+	if err != nil {
 		fmt.Printf("Error: %v", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syncra/internal/models"
+	"syncra/internal/server/admin"
+	"syncra/internal/server/websocket"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `syncra-admin - operate a Syncra relay
+
+Usage:
+  syncra-admin [-addr URL] [-token TOKEN] <command> [args]
+
+Commands:
+  users list [-limit N] [-offset N]
+  users show <username>
+  users ban <username>
+  users unban <username>
+  users delete [-y] <username>
+  sessions list
+  sessions kick <username> [reason]
+  stats
+
+The admin URL and token default to SYNCRA_ADMIN_URL and ADMIN_TOKEN.
+`
+
+func main() {
+	_ = godotenv.Load()
+
+	addr := os.Getenv("SYNCRA_ADMIN_URL")
+	if addr == "" {
+		addr = "http://127.0.0.1:9090"
+	}
+
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.StringVar(&addr, "addr", addr, "admin API base URL")
+	token := flag.String("token", os.Getenv("ADMIN_TOKEN"), "admin API bearer token")
+	flag.Parse()
+
+	if *token == "" {
+		fatal(fmt.Errorf("no admin token: set ADMIN_TOKEN or pass -token"))
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c := newAPIClient(addr, *token)
+	args := flag.Args()
+
+	var err error
+	switch args[0] {
+	case "users":
+		err = runUsers(c, args[1:])
+	case "sessions":
+		err = runSessions(c, args[1:])
+	case "stats":
+		err = runStats(c)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func runUsers(c *apiClient, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("users: missing subcommand")
+	}
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("users list", flag.ExitOnError)
+		limit := fs.Int("limit", 50, "page size")
+		offset := fs.Int("offset", 0, "rows to skip")
+		fs.Parse(args[1:])
+
+		var users []*models.User
+		q := url.Values{"limit": {strconv.Itoa(*limit)}, "offset": {strconv.Itoa(*offset)}}
+		if err := c.do("GET", "/admin/users", q, &users); err != nil {
+			return err
+		}
+		tw := newTable()
+		fmt.Fprintln(tw, "USERNAME\tFULL NAME\tCREATED\tSTATUS")
+		for _, u := range users {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", u.Username, u.FullName, u.CreatedAt.Format(time.DateOnly), userStatus(u))
+		}
+		return tw.Flush()
+
+	case "show":
+		username, err := argAt(args, 1, "username")
+		if err != nil {
+			return err
+		}
+		var u admin.UserResponse
+		if err := c.do("GET", userPath(username, ""), nil, &u); err != nil {
+			return err
+		}
+		tw := newTable()
+		fmt.Fprintf(tw, "Username\t%s\n", u.Username)
+		fmt.Fprintf(tw, "Full name\t%s\n", u.FullName)
+		fmt.Fprintf(tw, "ID\t%s\n", u.ID)
+		fmt.Fprintf(tw, "Public key\t%s\n", u.PublicKey)
+		fmt.Fprintf(tw, "Created\t%s\n", u.CreatedAt.Format(time.RFC3339))
+		fmt.Fprintf(tw, "Status\t%s\n", userStatus(u.User))
+		fmt.Fprintf(tw, "Online\t%t\n", u.Online)
+		return tw.Flush()
+
+	case "ban", "unban":
+		username, err := argAt(args, 1, "username")
+		if err != nil {
+			return err
+		}
+		if err := c.do("POST", userPath(username, "/"+args[0]), nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("%s: %sned\n", username, args[0])
+		return nil
+
+	case "delete":
+		fs := flag.NewFlagSet("users delete", flag.ExitOnError)
+		yes := fs.Bool("y", false, "skip confirmation")
+		fs.Parse(args[1:])
+		username, err := argAt(fs.Args(), 0, "username")
+		if err != nil {
+			return err
+		}
+		if !*yes && !confirm(fmt.Sprintf("Permanently delete %q? [y/N] ", username)) {
+			return fmt.Errorf("aborted")
+		}
+		if err := c.do("DELETE", userPath(username, ""), nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("%s: deleted\n", username)
+		return nil
+	}
+
+	return fmt.Errorf("users: unknown subcommand %q", args[0])
+}
+
+func runSessions(c *apiClient, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("sessions: missing subcommand")
+	}
+
+	switch args[0] {
+	case "list":
+		var sessions []websocket.Session
+		if err := c.do("GET", "/admin/sessions", nil, &sessions); err != nil {
+			return err
+		}
+		tw := newTable()
+		fmt.Fprintln(tw, "ID\tUSERNAME\tREMOTE ADDR\tCONNECTED")
+		for _, s := range sessions {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.ID, s.Username, s.RemoteAddr, time.Since(s.ConnectedAt).Truncate(time.Second))
+		}
+		return tw.Flush()
+
+	case "kick":
+		username, err := argAt(args, 1, "username")
+		if err != nil {
+			return err
+		}
+		q := url.Values{}
+		if len(args) > 2 {
+			q.Set("reason", strings.Join(args[2:], " "))
+		}
+		if err := c.do("DELETE", "/admin/sessions/"+url.PathEscape(username), q, nil); err != nil {
+			return err
+		}
+		fmt.Printf("%s: kicked\n", username)
+		return nil
+	}
+
+	return fmt.Errorf("sessions: unknown subcommand %q", args[0])
+}
+
+func runStats(c *apiClient) error {
+	var s admin.StatsResponse
+	if err := c.do("GET", "/admin/stats", nil, &s); err != nil {
+		return err
+	}
+	tw := newTable()
+	fmt.Fprintf(tw, "Uptime\t%s\n", time.Since(s.StartedAt).Truncate(time.Second))
+	fmt.Fprintf(tw, "Connections\t%d\n", s.Connections)
+	fmt.Fprintf(tw, "Sessions\t%d\n", s.Sessions)
+	fmt.Fprintf(tw, "Rooms\t%d\n", s.Rooms)
+	fmt.Fprintf(tw, "Packets relayed\t%d\n", s.PacketsRelayed)
+	fmt.Fprintf(tw, "Registered users\t%d\n", s.RegisteredUsers)
+	return tw.Flush()
+}
+
+func userStatus(u *models.User) string {
+	if u.BannedAt != nil {
+		return "banned since " + u.BannedAt.Format(time.DateOnly)
+	}
+	return "active"
+}
+
+func argAt(args []string, i int, name string) (string, error) {
+	if len(args) <= i || args[i] == "" {
+		return "", fmt.Errorf("missing %s", name)
+	}
+	return args[i], nil
+}
+
+func confirm(prompt string) bool {
+	fmt.Print(prompt)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "syncra-admin: %v\n", err)
+	os.Exit(1)
+}
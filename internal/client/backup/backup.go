@@ -0,0 +1,312 @@
+// Package backup packs a workspace into one passphrase-encrypted file and
+// unpacks it again, on this machine or another. A backup holds everything
+// that cannot be recovered elsewhere: the identity and group keys, chat
+// histories, local data such as contacts and the outbox, and config.json.
+// Logs, exports and indexes are left out; the indexes rebuild themselves.
+//
+// Restored group keys are marked with crypto.GroupState.MarkRestored: the
+// backup may be older than messages already sent under them, so the client
+// commits a fresh epoch of its own before it sends to the group again.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syncra/internal/config"
+	"syncra/internal/crypto"
+	"syncra/internal/protocol"
+	"time"
+)
+
+// Inside the encryption, a backup is a gzipped tar. config.json comes
+// first, without the workspace path; every other entry sits under syncra/
+// as in the workspace.
+
+// included lists the workspace folders a backup takes.
+var included = []string{"syncra/identities", "syncra/chats", "syncra/data", "syncra/config"}
+
+const (
+	configEntry  = "config.json"
+	identityFile = "syncra/identities/id_ed25519"
+	groupKeysDir = "syncra/identities/groups"
+)
+
+// ErrWorkspaceInUse is returned by Restore when the target workspace
+// already holds a syncra folder.
+var ErrWorkspaceInUse = errors.New("workspace already has a syncra folder")
+
+// Summary describes a backup that was written or restored
+type Summary struct {
+	Username string
+	Files    int
+	Bytes    int64 // Before compression
+}
+
+// Create writes an encrypted backup of the workspace in cfg to w
+func Create(w io.Writer, cfg *config.Config, passphrase []byte) (Summary, error) {
+	sum := Summary{Username: cfg.Username}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	portable := *cfg
+	portable.WorkspacePath = ""
+	data, err := json.MarshalIndent(portable, "", "  ")
+	if err != nil {
+		return sum, fmt.Errorf("failed to marshal config: %v", err)
+	}
+	if err := writeEntry(tw, configEntry, data, time.Now()); err != nil {
+		return sum, err
+	}
+
+	if _, err := os.Stat(filepath.Join(cfg.WorkspacePath, filepath.FromSlash(identityFile))); err != nil {
+		return sum, fmt.Errorf("workspace has no identity key: %v", err)
+	}
+	for _, dir := range included {
+		root := filepath.Join(cfg.WorkspacePath, filepath.FromSlash(dir))
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && p == root {
+					return nil
+				}
+				return err
+			}
+			rel, err := filepath.Rel(cfg.WorkspacePath, p)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(rel)
+			if d.IsDir() {
+				if name == "syncra/data/search" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || skipped(name) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			sum.Files++
+			sum.Bytes += int64(len(data))
+			return writeEntry(tw, name, data, info.ModTime())
+		})
+		if err != nil {
+			return sum, fmt.Errorf("failed to read %s: %v", dir, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return sum, fmt.Errorf("failed to write archive: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return sum, fmt.Errorf("failed to compress archive: %v", err)
+	}
+	sealed, err := crypto.SealWithPassphrase(buf.Bytes(), passphrase)
+	if err != nil {
+		return sum, err
+	}
+	if _, err := w.Write(sealed); err != nil {
+		return sum, fmt.Errorf("failed to write backup: %v", err)
+	}
+	return sum, nil
+}
+
+// skipped reports whether a workspace file is left out: indexes, which
+// are rebuilt, and half-written temporaries.
+func skipped(name string) bool {
+	return strings.HasSuffix(name, ".idx") || strings.HasSuffix(name, ".tmp")
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0600,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write archive: %v", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write archive: %v", err)
+	}
+	return nil
+}
+
+// Restore decrypts a backup and unpacks it into workspace, which must not
+// have a syncra folder yet. It returns the config to save, pointing at
+// workspace. Nothing is left behind if the backup turns out to be bad.
+func Restore(r io.Reader, passphrase []byte, workspace string) (*config.Config, Summary, error) {
+	var sum Summary
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, sum, fmt.Errorf("failed to read backup: %v", err)
+	}
+	archive, err := crypto.OpenWithPassphrase(sealed, passphrase)
+	if err != nil {
+		return nil, sum, err
+	}
+
+	target := filepath.Join(workspace, "syncra")
+	if entries, err := os.ReadDir(target); err == nil && len(entries) > 0 {
+		return nil, sum, ErrWorkspaceInUse
+	}
+	if err := os.MkdirAll(workspace, 0755); err != nil {
+		return nil, sum, fmt.Errorf("failed to create workspace: %v", err)
+	}
+	staging, err := os.MkdirTemp(workspace, ".syncra-restore-")
+	if err != nil {
+		return nil, sum, fmt.Errorf("failed to create workspace: %v", err)
+	}
+	defer os.RemoveAll(staging) // Gone by then on success
+
+	cfg, sum, err := unpack(archive, staging)
+	if err != nil {
+		return nil, sum, err
+	}
+	if err := checkIdentity(filepath.Join(staging, filepath.FromSlash(identityFile))); err != nil {
+		return nil, sum, err
+	}
+	if err := markGroupKeys(filepath.Join(staging, filepath.FromSlash(groupKeysDir))); err != nil {
+		return nil, sum, err
+	}
+
+	// An empty syncra folder, as a fresh setup leaves, makes way
+	os.Remove(target)
+	if err := os.Rename(filepath.Join(staging, "syncra"), target); err != nil {
+		return nil, sum, fmt.Errorf("failed to move restored workspace in place: %v", err)
+	}
+	if err := config.InitializeStructure(workspace); err != nil {
+		return nil, sum, err
+	}
+	cfg.WorkspacePath = workspace
+	return cfg, sum, nil
+}
+
+// unpack extracts the archive into dir, checking every entry.
+func unpack(archive []byte, dir string) (*config.Config, Summary, error) {
+	var sum Summary
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, sum, fmt.Errorf("backup is damaged: %v", err)
+	}
+	tr := tar.NewReader(gz)
+
+	var cfg *config.Config
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, sum, fmt.Errorf("backup is damaged: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, sum, fmt.Errorf("backup holds an unexpected entry %q", hdr.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, sum, fmt.Errorf("backup is damaged: %v", err)
+		}
+
+		if hdr.Name == configEntry {
+			cfg = &config.Config{}
+			if err := json.Unmarshal(data, cfg); err != nil {
+				return nil, sum, fmt.Errorf("backup has an invalid config: %v", err)
+			}
+			if err := protocol.ValidateExistingUsername(cfg.Username); err != nil {
+				return nil, sum, fmt.Errorf("backup has an invalid config: %v", err)
+			}
+			sum.Username = cfg.Username
+			continue
+		}
+		if !allowed(hdr.Name) {
+			return nil, sum, fmt.Errorf("backup holds an unexpected entry %q", hdr.Name)
+		}
+		p := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return nil, sum, fmt.Errorf("failed to create folder: %v", err)
+		}
+		if err := os.WriteFile(p, data, 0600); err != nil {
+			return nil, sum, fmt.Errorf("failed to write %s: %v", hdr.Name, err)
+		}
+		sum.Files++
+		sum.Bytes += int64(len(data))
+	}
+	if cfg == nil {
+		return nil, sum, fmt.Errorf("backup has no config")
+	}
+	return cfg, sum, nil
+}
+
+// allowed reports whether name is a clean path inside one of the included
+// folders, so no entry can land outside the workspace.
+func allowed(name string) bool {
+	if path.Clean(name) != name || path.IsAbs(name) || strings.Contains(name, "\\") {
+		return false
+	}
+	for _, dir := range included {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// markGroupKeys marks every group key state in dir as restored.
+func markGroupKeys(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read group keys: %v", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		p := filepath.Join(dir, e.Name())
+		st, err := crypto.LoadGroupState(p)
+		if err != nil {
+			return fmt.Errorf("backup has invalid group keys %s: %v", e.Name(), err)
+		}
+		st.MarkRestored()
+		if err := crypto.SaveGroupState(p, st); err != nil {
+			return fmt.Errorf("failed to write group keys %s: %v", e.Name(), err)
+		}
+	}
+	return nil
+}
+
+// checkIdentity makes sure the restored identity key is whole.
+func checkIdentity(p string) error {
+	priv, err := crypto.LoadPrivateKey(p)
+	if err != nil {
+		return fmt.Errorf("backup has no usable identity key: %v", err)
+	}
+	derived := ed25519.NewKeyFromSeed(priv.Seed())
+	if !bytes.Equal(derived, priv) {
+		return fmt.Errorf("backup has a damaged identity key")
+	}
+	return nil
+}
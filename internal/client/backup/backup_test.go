@@ -0,0 +1,225 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"syncra/internal/config"
+	"syncra/internal/crypto"
+	"testing"
+)
+
+var testPassphrase = []byte("backup passphrase")
+
+func TestAllowed(t *testing.T) {
+	cases := map[string]bool{
+		"syncra/chats/bob.json":                true,
+		"syncra/chats/groups/g.json":           true,
+		"syncra/identities/id_ed25519":         true,
+		"syncra/data/contacts.json":            true,
+		"../syncra/chats/bob.json":             false,
+		"syncra/chats/../../evil":              false,
+		"syncra/chats/../data/x":               false,
+		"syncra/chats/./bob.json":              false,
+		"syncra//chats/bob.json":               false,
+		"/syncra/chats/bob.json":               false,
+		"/etc/passwd":                          false,
+		`syncra\chats\bob.json`:                false,
+		`syncra/chats/..\..\evil`:              false,
+		"syncra/logs/client.log":               false,
+		"syncra/chats":                         false,
+		"syncra/chatsx/bob.json":               false,
+		"config.json/../syncra/chats/bob.json": false,
+		"":                                     false,
+	}
+	for name, want := range cases {
+		if got := allowed(name); got != want {
+			t.Errorf("allowed(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// archive builds a backup archive from headers, each with body as content.
+func archive(t *testing.T, headers ...*tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, h := range headers {
+		body := []byte("x")
+		if h.Typeflag == tar.TypeReg {
+			h.Size = int64(len(body))
+		}
+		if h.Mode == 0 {
+			h.Mode = 0600
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUnpackRejects(t *testing.T) {
+	reg := func(name string) *tar.Header { return &tar.Header{Name: name, Typeflag: tar.TypeReg} }
+	cases := map[string]*tar.Header{
+		"parent":    reg("../evil.json"),
+		"nested up": reg("syncra/chats/../../../evil.json"),
+		"absolute":  reg("/tmp/evil.json"),
+		"backslash": reg(`syncra\..\..\evil.json`),
+		"outside":   reg("syncra/logs/evil.log"),
+		"symlink":   {Name: "syncra/chats/bob.json", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		"hard link": {Name: "syncra/chats/bob.json", Typeflag: tar.TypeLink, Linkname: "../../evil"},
+		"folder":    {Name: "syncra/chats/x/", Typeflag: tar.TypeDir, Mode: 0755},
+		"device":    {Name: "syncra/chats/null", Typeflag: tar.TypeChar},
+		"fifo":      {Name: "syncra/chats/fifo", Typeflag: tar.TypeFifo},
+	}
+	for name, hdr := range cases {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(root, "staging")
+			data := archive(t, reg("syncra/chats/ok.json"), hdr)
+			if _, _, err := unpack(data, dir); err == nil {
+				t.Fatal("unpacked")
+			}
+			// Nothing may appear beside the staging folder
+			entries, err := os.ReadDir(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if e.Name() != "staging" {
+					t.Errorf("wrote %s outside the staging folder", e.Name())
+				}
+			}
+		})
+	}
+
+	if _, _, err := unpack(archive(t, reg("syncra/chats/ok.json")), t.TempDir()); err == nil {
+		t.Error("backup without a config unpacked")
+	}
+	if _, _, err := unpack([]byte("not gzip"), t.TempDir()); err == nil {
+		t.Error("garbage unpacked")
+	}
+}
+
+// workspace sets up a workspace with an identity, a chat, a group key
+// state and files a backup leaves out.
+func workspace(t *testing.T) *config.Config {
+	t.Helper()
+	dir := t.TempDir()
+	if err := config.InitializeStructure(dir); err != nil {
+		t.Fatal(err)
+	}
+	_, priv, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crypto.SavePrivateKey(filepath.Join(dir, filepath.FromSlash(identityFile)), priv); err != nil {
+		t.Fatal(err)
+	}
+	st, err := crypto.NewGroup("g1", "alice", priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := filepath.Join(dir, filepath.FromSlash(groupKeysDir))
+	if err := os.MkdirAll(groups, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := crypto.SaveGroupState(filepath.Join(groups, "g1.json"), st); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"syncra/chats/bob.json":         `{"id":"1","from":"bob","content":"hi"}` + "\n",
+		"syncra/chats/bob.json.idx":     `{}`,
+		"syncra/data/contacts.json":     `{}`,
+		"syncra/data/search/index.json": `{}`,
+		"syncra/logs/client.log":        "log line\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &config.Config{WorkspacePath: dir, Username: "alice", FullName: "Alice"}
+}
+
+func TestCreateRestore(t *testing.T) {
+	cfg := workspace(t)
+	var backup bytes.Buffer
+	created, err := Create(&backup, cfg, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Files != 4 {
+		t.Errorf("backed up %d files, want identity, group keys, chat and contacts", created.Files)
+	}
+
+	// A wrong passphrase leaves nothing behind
+	target := filepath.Join(t.TempDir(), "restored")
+	if _, _, err := Restore(bytes.NewReader(backup.Bytes()), []byte("guess"), target); !errors.Is(err, crypto.ErrWrongPassphrase) {
+		t.Fatalf("wrong passphrase: %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("failed restore left %s", target)
+	}
+
+	restored, sum, err := Restore(bytes.NewReader(backup.Bytes()), testPassphrase, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.WorkspacePath != target || restored.Username != "alice" || restored.FullName != "Alice" {
+		t.Errorf("config %+v", restored)
+	}
+	if sum.Files != created.Files || sum.Bytes != created.Bytes {
+		t.Errorf("restored %+v, backed up %+v", sum, created)
+	}
+	for _, name := range []string{identityFile, "syncra/chats/bob.json", "syncra/data/contacts.json"} {
+		want, err := os.ReadFile(filepath.Join(cfg.WorkspacePath, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s: restored %q, %v", name, got, err)
+		}
+	}
+	for _, name := range []string{"syncra/chats/bob.json.idx", "syncra/data/search/index.json", "syncra/logs/client.log"} {
+		if _, err := os.Stat(filepath.Join(target, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s restored", name)
+		}
+	}
+	st, err := crypto.LoadGroupState(filepath.Join(target, filepath.FromSlash(groupKeysDir), "g1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Restored() {
+		t.Error("restored group keys not marked")
+	}
+	if entries, _ := filepath.Glob(filepath.Join(target, ".syncra-restore-*")); len(entries) > 0 {
+		t.Errorf("staging left behind: %v", entries)
+	}
+
+	// A workspace in use is never overwritten
+	if _, _, err := Restore(bytes.NewReader(backup.Bytes()), testPassphrase, target); !errors.Is(err, ErrWorkspaceInUse) {
+		t.Errorf("restore over a workspace: %v", err)
+	}
+}
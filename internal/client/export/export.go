@@ -0,0 +1,284 @@
+// Package export renders a stored conversation as a transcript to keep or
+// share: Markdown, a self-contained HTML page, or normalized JSON. A
+// signed manifest of message hashes can go with it so later changes to the
+// file are evident.
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"syncra/internal/models"
+	"time"
+)
+
+// Format is a transcript file format
+type Format string
+
+const (
+	Markdown Format = "md"
+	HTML     Format = "html"
+	JSON     Format = "json"
+)
+
+// ParseFormat accepts a format by name or file extension
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(s, ".")) {
+	case "md", "markdown":
+		return Markdown, nil
+	case "html", "htm":
+		return HTML, nil
+	case "json":
+		return JSON, nil
+	}
+	return "", fmt.Errorf("unknown export format %q: use md, html or json", s)
+}
+
+// Message is the normalized form of a stored message: local-only fields
+// dropped, times in UTC. Manifest hashes are taken over the fields its
+// author set; see HashMessage.
+type Message struct {
+	ID        string              `json:"id"`
+	From      string              `json:"from"`
+	Sent      time.Time           `json:"sent"`
+	Content   string              `json:"content,omitempty"`
+	EditedAt  *time.Time          `json:"edited_at,omitempty"`
+	Deleted   bool                `json:"deleted,omitempty"`
+	ReplyTo   string              `json:"reply_to,omitempty"`
+	Reactions map[string][]string `json:"reactions,omitempty"`
+}
+
+// Transcript is the part of a conversation being exported
+type Transcript struct {
+	Version    int       `json:"version"`
+	Chat       string    `json:"chat"` // @username or #group
+	Owner      string    `json:"owner"`
+	ExportedAt time.Time `json:"exported_at"`
+	Since      time.Time `json:"since,omitzero"` // Zero when open
+	Until      time.Time `json:"until,omitzero"`
+	Messages   []Message `json:"messages"`
+}
+
+// NewTranscript normalizes the messages sent in [since, until) of a chat
+// exported by owner. Zero bounds are open.
+func NewTranscript(chat, owner string, msgs []models.LocalChatMessage, since, until time.Time) Transcript {
+	t := Transcript{
+		Version:    1,
+		Chat:       chat,
+		Owner:      owner,
+		ExportedAt: time.Now().UTC().Truncate(time.Second),
+		Messages:   []Message{},
+	}
+	if !since.IsZero() {
+		t.Since = since.UTC()
+	}
+	if !until.IsZero() {
+		t.Until = until.UTC()
+	}
+	for _, msg := range msgs {
+		if !since.IsZero() && msg.Timestamp.Before(since) || !until.IsZero() && !msg.Timestamp.Before(until) {
+			continue
+		}
+		n := Message{
+			ID:        msg.ID,
+			From:      msg.From,
+			Sent:      msg.Timestamp.UTC(),
+			Content:   msg.Content,
+			Deleted:   msg.Deleted,
+			ReplyTo:   msg.ReplyTo,
+			Reactions: msg.Reactions,
+		}
+		if msg.EditedAt != nil {
+			edited := msg.EditedAt.UTC()
+			n.EditedAt = &edited
+		}
+		if msg.Deleted {
+			n.Content, n.Reactions = "", nil
+		}
+		t.Messages = append(t.Messages, n)
+	}
+	return t
+}
+
+// Write renders t to w in format
+func Write(w io.Writer, format Format, t Transcript) error {
+	switch format {
+	case Markdown:
+		return writeMarkdown(w, t)
+	case HTML:
+		return writeHTML(w, t)
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(t)
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// days splits the messages by local calendar day, in order.
+func (t Transcript) days() [][]Message {
+	var days [][]Message
+	var last string
+	for _, msg := range t.Messages {
+		day := msg.Sent.Local().Format(time.DateOnly)
+		if len(days) == 0 || day != last {
+			days = append(days, nil)
+			last = day
+		}
+		days[len(days)-1] = append(days[len(days)-1], msg)
+	}
+	return days
+}
+
+// summary is the line under the title: who exported what, when.
+func (t Transcript) summary() string {
+	s := fmt.Sprintf("Exported by @%s on %s · %d messages", t.Owner, t.ExportedAt.Local().Format("Jan 2 2006 15:04 MST"), len(t.Messages))
+	switch {
+	case !t.Since.IsZero() && !t.Until.IsZero():
+		s += fmt.Sprintf(" · %s to %s", t.Since.Local().Format(time.DateOnly), t.Until.Add(-time.Second).Local().Format(time.DateOnly))
+	case !t.Since.IsZero():
+		s += " · since " + t.Since.Local().Format(time.DateOnly)
+	case !t.Until.IsZero():
+		s += " · until " + t.Until.Add(-time.Second).Local().Format(time.DateOnly)
+	}
+	return s
+}
+
+// quoted is a one-line snippet of the message id, for replies.
+func (t Transcript) quoted(id string) string {
+	for _, msg := range t.Messages {
+		if msg.ID != id {
+			continue
+		}
+		if msg.Deleted {
+			return "@" + msg.From + ": message deleted"
+		}
+		snippet := strings.Join(strings.Fields(msg.Content), " ")
+		if r := []rune(snippet); len(r) > 60 {
+			snippet = string(r[:60]) + "…"
+		}
+		return "@" + msg.From + ": " + snippet
+	}
+	return "an earlier message"
+}
+
+// reactions lists a message's reactions as "👍 2 · 🎉 1".
+func reactions(msg Message) string {
+	var parts []string
+	for emoji, users := range msg.Reactions {
+		if len(users) > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", emoji, len(users)))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " · ")
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`, "~", `\~`,
+)
+
+func writeMarkdown(w io.Writer, t Transcript) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n_%s_\n", markdownEscaper.Replace(t.Chat), markdownEscaper.Replace(t.summary()))
+	for _, day := range t.days() {
+		fmt.Fprintf(&b, "\n## %s\n\n", day[0].Sent.Local().Format("Monday, Jan 2 2006"))
+		for _, msg := range day {
+			if msg.ReplyTo != "" {
+				fmt.Fprintf(&b, "> ↪ %s\n\n", markdownEscaper.Replace(t.quoted(msg.ReplyTo)))
+			}
+			text := "_message deleted_"
+			if !msg.Deleted {
+				// Hard line breaks keep multi-line messages in one item
+				text = strings.ReplaceAll(markdownEscaper.Replace(msg.Content), "\n", "  \n")
+			}
+			if msg.EditedAt != nil && !msg.Deleted {
+				text += " _(edited)_"
+			}
+			fmt.Fprintf(&b, "**%s @%s:** %s  \n", msg.Sent.Local().Format("15:04"), markdownEscaper.Replace(msg.From), text)
+			if r := reactions(msg); r != "" {
+				fmt.Fprintf(&b, "%s\n", r)
+			}
+			b.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type htmlDay struct {
+	Title    string
+	Messages []htmlMessage
+}
+
+type htmlMessage struct {
+	Time, From, Content, Quote, Reactions string
+	Edited, Deleted                       bool
+}
+
+func writeHTML(w io.Writer, t Transcript) error {
+	var days []htmlDay
+	for _, day := range t.days() {
+		d := htmlDay{Title: day[0].Sent.Local().Format("Monday, Jan 2 2006")}
+		for _, msg := range day {
+			m := htmlMessage{
+				Time:      msg.Sent.Local().Format("15:04"),
+				From:      msg.From,
+				Content:   msg.Content,
+				Reactions: reactions(msg),
+				Edited:    msg.EditedAt != nil,
+				Deleted:   msg.Deleted,
+			}
+			if msg.ReplyTo != "" {
+				m.Quote = t.quoted(msg.ReplyTo)
+			}
+			d.Messages = append(d.Messages, m)
+		}
+		days = append(days, d)
+	}
+	return htmlTemplate.Execute(w, map[string]any{
+		"Chat":    t.Chat,
+		"Summary": t.summary(),
+		"Days":    days,
+	})
+}
+
+// htmlTemplate is one page with its styles inline, so the file stands on
+// its own.
+var htmlTemplate = template.Must(template.New("transcript").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Chat}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 46rem; margin: 2rem auto; padding: 0 1rem; color: #1f2328; background: #fff; }
+h1 { margin-bottom: .25rem; }
+.summary { color: #656d76; font-size: .9rem; }
+h2 { font-size: .85rem; text-transform: uppercase; letter-spacing: .05em; color: #656d76; text-align: center; margin: 2rem 0 1rem; }
+.msg { margin: .5rem 0; }
+.time { color: #656d76; font-size: .8rem; margin-right: .4rem; }
+.from { font-weight: 600; margin-right: .4rem; }
+.content { white-space: pre-wrap; }
+.quote { border-left: 3px solid #d0d7de; padding-left: .5rem; color: #656d76; font-size: .85rem; }
+.muted { color: #656d76; font-style: italic; }
+.reactions { font-size: .85rem; color: #656d76; margin-left: 3rem; }
+</style>
+</head>
+<body>
+<h1>{{.Chat}}</h1>
+<p class="summary">{{.Summary}}</p>
+{{range .Days}}<h2>{{.Title}}</h2>
+{{range .Messages}}<div class="msg">
+{{if .Quote}}<div class="quote">↪ {{.Quote}}</div>
+{{end}}<span class="time">{{.Time}}</span><span class="from">@{{.From}}</span>{{if .Deleted}}<span class="muted">message deleted</span>{{else}}<span class="content">{{.Content}}</span>{{if .Edited}} <span class="muted">(edited)</span>{{end}}{{end}}
+{{if .Reactions}}<div class="reactions">{{.Reactions}}</div>
+{{end}}</div>
+{{end}}{{end}}</body>
+</html>
+`))
@@ -0,0 +1,124 @@
+package export
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Manifest vouches for an exported file: it lists the hash of every
+// message and of the file itself, signed with the exporter's identity key.
+// Anyone holding the file and the exporter's public key can check that
+// neither changed since. Message hashes cover only what the author sent,
+// not times or reactions, which each side records for itself, so they can
+// be compared with the other party's export of the conversation.
+type Manifest struct {
+	Version    int           `json:"version"`
+	Chat       string        `json:"chat"`
+	Owner      string        `json:"owner"`
+	ExportedAt time.Time     `json:"exported_at"`
+	File       string        `json:"file"` // Base name of the export
+	Format     Format        `json:"format"`
+	FileSHA256 string        `json:"file_sha256"`
+	Messages   []MessageHash `json:"messages"`
+	PublicKey  string        `json:"public_key"` // Hex Ed25519 key of Owner
+	Signature  string        `json:"signature"`  // Hex, over the manifest without it
+}
+
+// MessageHash is the SHA-256 of the JSON of a message's authored fields
+type MessageHash struct {
+	ID     string `json:"id"`
+	SHA256 string `json:"sha256"`
+}
+
+// NewManifest describes t, written as file in format with contents data,
+// and signs it with identity.
+func NewManifest(t Transcript, file string, format Format, data []byte, identity ed25519.PrivateKey) (*Manifest, error) {
+	m := &Manifest{
+		Version:    2, // 1 hashed whole messages, times included
+		Chat:       t.Chat,
+		Owner:      t.Owner,
+		ExportedAt: t.ExportedAt,
+		File:       file,
+		Format:     format,
+		FileSHA256: sha256Hex(data),
+		Messages:   make([]MessageHash, 0, len(t.Messages)),
+		PublicKey:  hex.EncodeToString(identity.Public().(ed25519.PublicKey)),
+	}
+	for _, msg := range t.Messages {
+		h, err := HashMessage(msg)
+		if err != nil {
+			return nil, err
+		}
+		m.Messages = append(m.Messages, MessageHash{ID: msg.ID, SHA256: h})
+	}
+	content, err := m.signedContent()
+	if err != nil {
+		return nil, err
+	}
+	m.Signature = hex.EncodeToString(ed25519.Sign(identity, content))
+	return m, nil
+}
+
+// authored is the part of a message that reads the same in every copy of
+// a conversation. The sender stores its own clock as the send time while
+// recipients store the relay's, and reactions arrive in any order.
+type authored struct {
+	ID      string `json:"id"`
+	From    string `json:"from"`
+	Content string `json:"content,omitempty"`
+	ReplyTo string `json:"reply_to,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// HashMessage returns the hex SHA-256 of the JSON of msg's authored fields
+func HashMessage(msg Message) (string, error) {
+	data, err := json.Marshal(authored{ID: msg.ID, From: msg.From, Content: msg.Content, ReplyTo: msg.ReplyTo, Deleted: msg.Deleted})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal message: %v", err)
+	}
+	return sha256Hex(data), nil
+}
+
+// Verify checks the signature and that data is the file it describes.
+// It says nothing about whose key signed; compare PublicKey with the one
+// the relay holds for Owner.
+func (m *Manifest) Verify(data []byte) error {
+	pub, err := hex.DecodeString(m.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("manifest has an invalid public key")
+	}
+	sig, err := hex.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("manifest has an invalid signature")
+	}
+	content, err := m.signedContent()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, content, sig) {
+		return fmt.Errorf("manifest signature does not match: the manifest was changed")
+	}
+	if sha256Hex(data) != m.FileSHA256 {
+		return fmt.Errorf("%s does not match the manifest: the file was changed", m.File)
+	}
+	return nil
+}
+
+func (m *Manifest) signedContent() ([]byte, error) {
+	unsigned := *m
+	unsigned.Signature = ""
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+	return data, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
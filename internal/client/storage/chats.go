@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,32 +12,59 @@ import (
 	"sync"
 	"syncra/internal/config"
 	"syncra/internal/models"
+	"time"
 )
 
 var fileMutex sync.Mutex
 
-// AppendMessage saves a chat message to the local storage
+// ErrDuplicate is returned by AppendMessage for a message ID that is already
+// stored; the relay delivers at least once, so redelivery is expected.
+var ErrDuplicate = errors.New("duplicate message")
+
+// ErrNotFound is returned by UpdateMessage when no stored message has the
+// given ID, for example when an edit overtakes the message itself.
+var ErrNotFound = errors.New("message not found")
+
+// seenIDs caches the message IDs in each chat file, keyed by path. Guarded
+// by fileMutex and filled on the first append to a file.
+var seenIDs = make(map[string]map[string]bool)
+
+// AppendMessage saves a chat message to the local storage. Messages with an
+// ID are written at most once per chat; repeats return ErrDuplicate.
 func AppendMessage(targetUsername string, msg models.LocalChatMessage) error {
+	return appendMessage(targetUsername+".json", msg)
+}
+
+// appendMessage writes msg to the history file at name, relative to the
+// workspace's chats directory.
+func appendMessage(name string, msg models.LocalChatMessage) error {
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %v", err)
 	}
 
-	chatsDir := filepath.Join(cfg.WorkspacePath, "syncra", "chats")
-	if err := os.MkdirAll(chatsDir, 0755); err != nil {
+	filePath := filepath.Join(cfg.WorkspacePath, "syncra", "chats", name)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 		return fmt.Errorf("failed to create chats directory: %v", err)
 	}
 
-	filePath := filepath.Join(chatsDir, targetUsername+".json")
-
 	fileMutex.Lock()
 	defer fileMutex.Unlock()
 
+	seen := loadSeenIDs(filePath)
+	if msg.ID != "" && seen[msg.ID] {
+		return ErrDuplicate
+	}
+
 	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to open chat file: %v", err)
 	}
 	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read chat file: %v", err)
+	}
 
 	data, err := json.Marshal(msg)
 	if err != nil {
@@ -45,17 +75,147 @@ func AppendMessage(targetUsername string, msg models.LocalChatMessage) error {
 		return fmt.Errorf("failed to write message: %v", err)
 	}
 
+	if msg.ID != "" {
+		seen[msg.ID] = true
+	}
+	noteExpiry(filePath, msg)
+	indexAppended(filePath, info.Size(), info.Size()+int64(len(data))+1, msg)
 	return nil
 }
 
+// loadSeenIDs returns the ID set for a chat file, reading it on first use.
+// A missing file resets the set, since the history may have been wiped.
+// Callers must hold fileMutex.
+func loadSeenIDs(filePath string) map[string]bool {
+	if _, err := os.Stat(filePath); err != nil {
+		seenIDs[filePath] = make(map[string]bool)
+		return seenIDs[filePath]
+	}
+	if seen, ok := seenIDs[filePath]; ok {
+		return seen
+	}
+
+	data, _ := os.ReadFile(filePath)
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		var msg struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal([]byte(line), &msg) == nil && msg.ID != "" {
+			seen[msg.ID] = true
+		}
+	}
+	seenIDs[filePath] = seen
+	return seen
+}
+
+// UpdateMessage rewrites the stored message with the given ID in the chat
+// with targetUsername. fn may refuse the change by returning an error.
+func UpdateMessage(targetUsername, id string, fn func(*models.LocalChatMessage) error) error {
+	return updateMessage(targetUsername+".json", id, fn)
+}
+
+// updateMessage rewrites the history file at name with fn applied to every
+// copy of message id. The file is replaced via rename so a crash never
+// leaves it torn.
+func updateMessage(name, id string, fn func(*models.LocalChatMessage) error) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	filePath := filepath.Join(cfg.WorkspacePath, "syncra", "chats", name)
+
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read chat file: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	var stored, updated []models.LocalChatMessage
+	for i, line := range lines {
+		var msg models.LocalChatMessage
+		if json.Unmarshal([]byte(line), &msg) != nil {
+			continue
+		}
+		if msg.ID == "" {
+			msg.ID = legacyID(msg)
+		}
+		if msg.ID != id {
+			continue
+		}
+		stored = append(stored, msg)
+		if err := fn(&msg); err != nil {
+			return err
+		}
+		line, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message: %v", err)
+		}
+		lines[i] = string(line)
+		updated = append(updated, msg)
+	}
+	if len(updated) == 0 {
+		return ErrNotFound
+	}
+
+	return replaceChatFile(filePath, lines, stored, updated)
+}
+
+// replaceChatFile writes lines over the history file at filePath via a
+// rename. before and after are the messages that changed or went, as
+// indexRewritten takes them. The caller holds fileMutex.
+func replaceChatFile(filePath string, lines []string, before, after []models.LocalChatMessage) error {
+	var data []byte
+	if len(lines) > 0 {
+		data = []byte(strings.Join(lines, "\n") + "\n")
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read chat file: %v", err)
+	}
+	// Offsets move, so the index must be rebuilt
+	if err := os.Remove(filePath + ".idx"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to drop chat index: %v", err)
+	}
+	tmp := filePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write chat file: %v", err)
+	}
+	if err := os.Rename(tmp, filePath); err != nil {
+		return fmt.Errorf("failed to replace chat file: %v", err)
+	}
+	indexRewritten(filePath, info.Size(), int64(len(data)), before, after)
+	return nil
+}
+
+// legacyID gives a message stored without a packet ID a stable local one,
+// so it can still be selected and replied to. Updating the message saves
+// the ID with it.
+func legacyID(msg models.LocalChatMessage) string {
+	sum := sha256.Sum256([]byte(msg.From + "\x00" + msg.Timestamp.UTC().Format(time.RFC3339Nano) + "\x00" + msg.Content))
+	return hex.EncodeToString(sum[:16])
+}
+
 // LoadMessages retrieves all messages for a specific conversation
 func LoadMessages(targetUsername string) ([]models.LocalChatMessage, error) {
+	return loadMessages(targetUsername + ".json")
+}
+
+// loadMessages reads the history file at name, relative to the workspace's
+// chats directory.
+func loadMessages(name string) ([]models.LocalChatMessage, error) {
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	filePath := filepath.Join(cfg.WorkspacePath, "syncra", "chats", targetUsername+".json")
+	filePath := filepath.Join(cfg.WorkspacePath, "syncra", "chats", name)
 
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		return []models.LocalChatMessage{}, nil
@@ -66,15 +226,25 @@ func LoadMessages(targetUsername string) ([]models.LocalChatMessage, error) {
 		return nil, err
 	}
 
-	// Parsing JSONL
+	// Parsing JSONL; keep the first line per ID. A packet redelivered after
+	// a lost ack may have been stored twice before AppendMessage checked
+	// IDs; lines without one only match when sender, time and text do.
 	lines := strings.Split(string(data), "\n")
 	var messages []models.LocalChatMessage
+	seen := make(map[string]bool)
 	for _, line := range lines {
 		if line == "" {
 			continue
 		}
 		var msg models.LocalChatMessage
 		if err := json.Unmarshal([]byte(line), &msg); err == nil {
+			if msg.ID == "" {
+				msg.ID = legacyID(msg)
+			}
+			if seen[msg.ID] {
+				continue
+			}
+			seen[msg.ID] = true
 			messages = append(messages, msg)
 		}
 	}
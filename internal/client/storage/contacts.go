@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"syncra/internal/models"
+	"time"
+)
+
+// Local settings for the people we chat with live in
+// syncra/data/contacts.json, keyed by username. None of it leaves the
+// device; a nickname is only what we call them.
+
+// Contact is our local view of one conversation partner
+type Contact struct {
+	Username string    `json:"username"`
+	Nickname string    `json:"nickname,omitempty"`
+	Pinned   bool      `json:"pinned,omitempty"`
+	Muted    bool      `json:"muted,omitempty"`
+	Archived bool      `json:"archived,omitempty"`
+	LastRead time.Time `json:"last_read,omitempty"` // Messages after this are unread
+}
+
+// DisplayName is the nickname if one is set, else the username
+func (c Contact) DisplayName() string {
+	if c.Nickname != "" {
+		return c.Nickname
+	}
+	return c.Username
+}
+
+// ChatSummary is one row of the chat list
+type ChatSummary struct {
+	Contact
+	Last   *models.LocalChatMessage // Nil for an empty history
+	Unread int
+}
+
+// LastActivity is when the last message was sent or received
+func (s ChatSummary) LastActivity() time.Time {
+	if s.Last == nil {
+		return time.Time{}
+	}
+	return s.Last.Timestamp
+}
+
+var contactsMutex sync.Mutex
+
+// LoadContacts returns every contact with local settings, by username
+func LoadContacts() (map[string]Contact, error) {
+	contactsMutex.Lock()
+	defer contactsMutex.Unlock()
+	return readContacts()
+}
+
+// UpdateContact applies fn to username's settings, creating them if needed
+func UpdateContact(username string, fn func(*Contact)) error {
+	contactsMutex.Lock()
+	defer contactsMutex.Unlock()
+	contacts, err := readContacts()
+	if err != nil {
+		return err
+	}
+	c, ok := contacts[username]
+	if !ok {
+		c = Contact{Username: username}
+	}
+	fn(&c)
+	contacts[username] = c
+	path, err := dataPath("contacts.json")
+	if err != nil {
+		return err
+	}
+	return writeJSON(path, contacts)
+}
+
+// MarkRead records that we have seen username's messages up to now
+func MarkRead(username string) error {
+	return UpdateContact(username, func(c *Contact) { c.LastRead = time.Now() })
+}
+
+// ListChatSummaries returns the chat list: pinned chats first, then by last
+// activity, newest first. Archived chats are included only when archived
+// is set, and then exclusively.
+func ListChatSummaries(archived bool) ([]ChatSummary, error) {
+	chats, err := ListChats()
+	if err != nil {
+		return nil, err
+	}
+	contacts, err := LoadContacts()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]ChatSummary, 0, len(chats))
+	for _, username := range chats {
+		c, ok := contacts[username]
+		if !ok {
+			c = Contact{Username: username}
+		}
+		if c.Archived != archived {
+			continue
+		}
+		s, err := summarize(c)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if a.Pinned != b.Pinned {
+			return a.Pinned
+		}
+		if !a.LastActivity().Equal(b.LastActivity()) {
+			return a.LastActivity().After(b.LastActivity())
+		}
+		return strings.ToLower(a.DisplayName()) < strings.ToLower(b.DisplayName())
+	})
+	return summaries, nil
+}
+
+// summarize fills in the last message and unread count of the chat with c
+func summarize(c Contact) (ChatSummary, error) {
+	s := ChatSummary{Contact: c}
+	var err error
+	s.Last, s.Unread, err = tail(c.Username+".json", c.LastRead)
+	return s, err
+}
+
+func readContacts() (map[string]Contact, error) {
+	path, err := dataPath("contacts.json")
+	if err != nil {
+		return nil, err
+	}
+	contacts := make(map[string]Contact)
+	if err := readJSON(path, &contacts); err != nil {
+		return nil, err
+	}
+	return contacts, nil
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syncra/internal/config"
+	"syncra/internal/models"
+	"time"
+)
+
+// Disappearing message timers live in syncra/data/expiry.json, keyed by
+// username for 1:1 chats and by GroupKey for groups. The timer is stamped
+// on each message as it is sent, so changing it leaves older messages
+// alone; PurgeExpired then drops messages whose time is up.
+
+// ExpiryTimer is how long new messages in a conversation last
+type ExpiryTimer struct {
+	TTL   time.Duration `json:"ttl"` // Zero when off
+	SetBy string        `json:"set_by"`
+	SetAt time.Time     `json:"set_at"`
+}
+
+var expiryMutex sync.Mutex
+
+// dueAt holds the earliest message expiry of each chat file that has one,
+// keyed by path. It is filled by the first PurgeExpired and kept current
+// by appends and purges, so later runs read only the files with something
+// due. Nil until then; guarded by fileMutex.
+var dueAt map[string]time.Time
+
+// GroupKey is the expiry.json key of a group chat
+func GroupKey(groupID string) string {
+	return "group:" + groupID
+}
+
+// LoadExpiry returns every conversation's timer
+func LoadExpiry() (map[string]ExpiryTimer, error) {
+	expiryMutex.Lock()
+	defer expiryMutex.Unlock()
+	return readExpiry()
+}
+
+// SetExpiry records chat's timer unless a newer change is already known,
+// as when two changes cross. It reports whether t took effect.
+func SetExpiry(chat string, t ExpiryTimer) (bool, error) {
+	expiryMutex.Lock()
+	defer expiryMutex.Unlock()
+	timers, err := readExpiry()
+	if err != nil {
+		return false, err
+	}
+	if cur, ok := timers[chat]; ok && cur.SetAt.After(t.SetAt) {
+		return false, nil
+	}
+	if t.TTL == 0 {
+		delete(timers, chat)
+	} else {
+		timers[chat] = t
+	}
+	path, err := dataPath("expiry.json")
+	if err != nil {
+		return false, err
+	}
+	return true, writeJSON(path, timers)
+}
+
+// PurgeExpired deletes every stored message whose expiry is before now,
+// in chat histories and pending message requests. It returns how many
+// went. Only the first run reads every history; later ones read those
+// dueAt says have something expiring.
+func PurgeExpired(now time.Time) (int, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load config: %v", err)
+	}
+	chatsDir := filepath.Join(cfg.WorkspacePath, "syncra", "chats")
+
+	fileMutex.Lock()
+	purged := 0
+	if dueAt == nil {
+		dueAt = make(map[string]time.Time)
+		err = filepath.WalkDir(chatsDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || filepath.Ext(path) != ".json" {
+				return err
+			}
+			n, err := purgeFile(path, now)
+			purged += n
+			return err
+		})
+		if err != nil && !os.IsNotExist(err) {
+			dueAt = nil // Scan again next time
+		}
+	} else {
+		for path, at := range dueAt {
+			if at.After(now) {
+				continue
+			}
+			n, ferr := purgeFile(path, now)
+			purged += n
+			if ferr != nil {
+				dueAt[path] = at // Try again next time
+				if err == nil {
+					err = ferr
+				}
+			}
+		}
+	}
+	fileMutex.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		return purged, err
+	}
+
+	n, err := purgeRequests(now)
+	return purged + n, err
+}
+
+// purgeRequests drops expired messages from pending message requests,
+// writing requests.json only when some went.
+func purgeRequests(now time.Time) (int, error) {
+	requestsMutex.Lock()
+	defer requestsMutex.Unlock()
+	path, err := dataPath("requests.json")
+	if err != nil {
+		return 0, err
+	}
+	requests, err := readRequests(path)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for from, r := range requests {
+		kept := r.Messages[:0]
+		for _, msg := range r.Messages {
+			if Expired(msg, now) {
+				purged++
+			} else {
+				kept = append(kept, msg)
+			}
+		}
+		r.Messages = kept
+		if len(kept) == 0 {
+			delete(requests, from)
+		}
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+	return purged, writeJSON(path, requests)
+}
+
+// Expired reports whether msg's time is up at now
+func Expired(msg models.LocalChatMessage, now time.Time) bool {
+	return msg.ExpiresAt != nil && msg.ExpiresAt.Before(now)
+}
+
+// purgeFile drops expired lines from one history file and records when
+// the next of the rest expires in dueAt. The file is only rewritten when
+// something expired. The caller holds fileMutex.
+func purgeFile(path string, now time.Time) (int, error) {
+	delete(dueAt, path)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil // Chat deleted since
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read chat file: %v", err)
+	}
+	var kept []string
+	var expired []models.LocalChatMessage
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		var msg models.LocalChatMessage
+		if json.Unmarshal([]byte(line), &msg) == nil {
+			if Expired(msg, now) {
+				if msg.ID == "" {
+					msg.ID = legacyID(msg)
+				}
+				expired = append(expired, msg)
+				continue
+			}
+			noteExpiry(path, msg)
+		}
+		kept = append(kept, line)
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	return len(expired), replaceChatFile(path, kept, expired, nil)
+}
+
+// noteExpiry brings forward the next purge of the chat file at path if
+// msg expires before it. The caller holds fileMutex.
+func noteExpiry(path string, msg models.LocalChatMessage) {
+	if dueAt == nil || msg.ExpiresAt == nil {
+		return
+	}
+	if at, ok := dueAt[path]; !ok || msg.ExpiresAt.Before(at) {
+		dueAt[path] = *msg.ExpiresAt
+	}
+}
+
+func readExpiry() (map[string]ExpiryTimer, error) {
+	path, err := dataPath("expiry.json")
+	if err != nil {
+		return nil, err
+	}
+	timers := make(map[string]ExpiryTimer)
+	if err := readJSON(path, &timers); err != nil {
+		return nil, err
+	}
+	return timers, nil
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syncra/internal/config"
+	"syncra/internal/models"
+)
+
+// Group history lives under syncra/chats/groups/<id>.json, next to the
+// 1:1 files; the groups we belong to are listed in syncra/data/groups.json.
+
+var groupsMutex sync.Mutex
+
+// AppendGroupMessage saves a message posted to a group. Like AppendMessage
+// it returns ErrDuplicate for an ID that is already stored.
+func AppendGroupMessage(groupID string, msg models.LocalChatMessage) error {
+	return appendMessage(filepath.Join("groups", groupID+".json"), msg)
+}
+
+// UpdateGroupMessage rewrites a stored group message, like UpdateMessage
+func UpdateGroupMessage(groupID, id string, fn func(*models.LocalChatMessage) error) error {
+	return updateMessage(filepath.Join("groups", groupID+".json"), id, fn)
+}
+
+// LoadGroupMessages retrieves all messages for a group
+func LoadGroupMessages(groupID string) ([]models.LocalChatMessage, error) {
+	return loadMessages(filepath.Join("groups", groupID+".json"))
+}
+
+// ListGroups returns the groups we belong to, ordered by name
+func ListGroups() ([]models.Group, error) {
+	groupsMutex.Lock()
+	defer groupsMutex.Unlock()
+	path, err := groupsPath()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readGroups(path)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+// SaveGroup records a group, replacing any older copy of it
+func SaveGroup(g models.Group) error {
+	return editGroups(func(groups []models.Group) []models.Group {
+		for i := range groups {
+			if groups[i].ID == g.ID {
+				groups[i] = g
+				return groups
+			}
+		}
+		return append(groups, g)
+	})
+}
+
+// RemoveGroup forgets a group we left or were removed from. Its history
+// file is kept.
+func RemoveGroup(id string) error {
+	return editGroups(func(groups []models.Group) []models.Group {
+		kept := groups[:0]
+		for _, g := range groups {
+			if g.ID != id {
+				kept = append(kept, g)
+			}
+		}
+		return kept
+	})
+}
+
+func groupsPath() (string, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load config: %v", err)
+	}
+	if cfg == nil {
+		return "", fmt.Errorf("workspace not initialized")
+	}
+	return filepath.Join(cfg.WorkspacePath, "syncra", "data", "groups.json"), nil
+}
+
+func editGroups(fn func([]models.Group) []models.Group) error {
+	groupsMutex.Lock()
+	defer groupsMutex.Unlock()
+	path, err := groupsPath()
+	if err != nil {
+		return err
+	}
+	groups, err := readGroups(path)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(fn(groups), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal groups: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write groups: %v", err)
+	}
+	return nil
+}
+
+func readGroups(path string) ([]models.Group, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []models.Group{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read groups: %v", err)
+	}
+	var groups []models.Group
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("failed to parse groups: %v", err)
+	}
+	return groups, nil
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syncra/internal/config"
+	"syncra/internal/models"
+)
+
+// Transports an outbox entry can be waiting for
+const (
+	OutboxRelay = "relay"
+	OutboxLAN   = "lan"
+)
+
+// OutboxEntry is a message composed locally whose delivery has not been
+// confirmed yet. It stays queued across restarts until sent or discarded.
+type OutboxEntry struct {
+	Packet    models.Packet `json:"packet"`
+	Mode      string        `json:"mode"`
+	Attempts  int           `json:"attempts"`
+	Failed    bool          `json:"failed,omitempty"` // Gave up; waits for a manual retry
+	LastError string        `json:"last_error,omitempty"`
+}
+
+var outboxMutex sync.Mutex
+
+func outboxPath() (string, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load config: %v", err)
+	}
+	if cfg == nil {
+		return "", fmt.Errorf("workspace not initialized")
+	}
+	return filepath.Join(cfg.WorkspacePath, "syncra", "data", "outbox.json"), nil
+}
+
+// LoadOutbox returns queued messages, oldest first.
+func LoadOutbox() ([]OutboxEntry, error) {
+	outboxMutex.Lock()
+	defer outboxMutex.Unlock()
+	path, err := outboxPath()
+	if err != nil {
+		return nil, err
+	}
+	return readOutbox(path)
+}
+
+// QueueOutbox adds e, replacing any entry with the same packet ID.
+func QueueOutbox(e OutboxEntry) error {
+	return editOutbox(func(entries []OutboxEntry) []OutboxEntry {
+		for i := range entries {
+			if entries[i].Packet.ID == e.Packet.ID {
+				entries[i] = e
+				return entries
+			}
+		}
+		return append(entries, e)
+	})
+}
+
+// UpdateOutbox applies fn to the entry with the given packet ID, if any.
+func UpdateOutbox(id string, fn func(*OutboxEntry)) error {
+	return editOutbox(func(entries []OutboxEntry) []OutboxEntry {
+		for i := range entries {
+			if entries[i].Packet.ID == id {
+				fn(&entries[i])
+			}
+		}
+		return entries
+	})
+}
+
+// RemoveOutbox drops the entry with the given packet ID once delivered.
+func RemoveOutbox(id string) error {
+	return editOutbox(func(entries []OutboxEntry) []OutboxEntry {
+		kept := entries[:0]
+		for _, e := range entries {
+			if e.Packet.ID != id {
+				kept = append(kept, e)
+			}
+		}
+		return kept
+	})
+}
+
+func editOutbox(fn func([]OutboxEntry) []OutboxEntry) error {
+	outboxMutex.Lock()
+	defer outboxMutex.Unlock()
+	path, err := outboxPath()
+	if err != nil {
+		return err
+	}
+	entries, err := readOutbox(path)
+	if err != nil {
+		return err
+	}
+	return writeOutbox(path, fn(entries))
+}
+
+func readOutbox(path string) ([]OutboxEntry, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []OutboxEntry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox: %v", err)
+	}
+	var entries []OutboxEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse outbox: %v", err)
+	}
+	return entries, nil
+}
+
+// writeOutbox replaces the file via rename so a crash never leaves it torn.
+func writeOutbox(path string, entries []OutboxEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write outbox: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace outbox: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syncra/internal/config"
+	"syncra/internal/models"
+	"time"
+)
+
+// Long histories are loaded a page at a time, newest first, by reading the
+// chat file backwards from the end or from where the previous page
+// started. Next to each chat file, <file>.idx maps message IDs to their
+// byte offsets so one message can be found without a full scan; it is
+// extended as the file grows and rebuilt after a rewrite.
+
+// readChunk is how much is read per step when scanning backwards. Tests
+// shrink it to cross chunk boundaries with small files.
+var readChunk int64 = 32 * 1024
+
+// summaryLines is how many lines tail takes per step
+const summaryLines = 50
+
+// Page is a run of consecutive messages of one chat, oldest first
+type Page struct {
+	Messages []models.LocalChatMessage
+	Before   int64 // Byte offset of the first message; pass to load the page before it
+}
+
+// More reports whether older messages exist before the page
+func (p Page) More() bool {
+	return p.Before > 0
+}
+
+// chatIndex is the sidecar of one chat file
+type chatIndex struct {
+	Size    int64            `json:"size"`    // Bytes of the chat file covered
+	Count   int              `json:"count"`   // Distinct messages
+	Offsets map[string]int64 `json:"offsets"` // Message ID to line start
+}
+
+// LoadRecentMessages returns the last n messages of the chat with
+// targetUsername
+func LoadRecentMessages(targetUsername string, n int) (Page, error) {
+	return loadPage(targetUsername+".json", -1, n)
+}
+
+// LoadMessagesBefore returns up to n messages preceding a page's Before
+func LoadMessagesBefore(targetUsername string, before int64, n int) (Page, error) {
+	return loadPage(targetUsername+".json", before, n)
+}
+
+// LoadRecentGroupMessages is LoadRecentMessages for a group chat
+func LoadRecentGroupMessages(groupID string, n int) (Page, error) {
+	return loadPage(filepath.Join("groups", groupID+".json"), -1, n)
+}
+
+// LoadGroupMessagesBefore is LoadMessagesBefore for a group chat
+func LoadGroupMessagesBefore(groupID string, before int64, n int) (Page, error) {
+	return loadPage(filepath.Join("groups", groupID+".json"), before, n)
+}
+
+// FindMessage looks up one message of the chat with targetUsername through
+// the index. It returns ErrNotFound if there is none with that ID.
+func FindMessage(targetUsername, id string) (models.LocalChatMessage, error) {
+	return findMessage(targetUsername+".json", id)
+}
+
+// FindGroupMessage is FindMessage for a group chat
+func FindGroupMessage(groupID, id string) (models.LocalChatMessage, error) {
+	return findMessage(filepath.Join("groups", groupID+".json"), id)
+}
+
+// CountMessages returns how many messages the chat with targetUsername
+// holds
+func CountMessages(targetUsername string) (int, error) {
+	return countMessages(targetUsername + ".json")
+}
+
+// CountGroupMessages is CountMessages for a group chat
+func CountGroupMessages(groupID string) (int, error) {
+	return countMessages(filepath.Join("groups", groupID+".json"))
+}
+
+func chatFilePath(name string) (string, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load config: %v", err)
+	}
+	return filepath.Join(cfg.WorkspacePath, "syncra", "chats", name), nil
+}
+
+// loadPage reads up to n messages ending at byte offset before, or at the
+// end of the file when before is negative.
+func loadPage(name string, before int64, n int) (Page, error) {
+	filePath, err := chatFilePath(name)
+	if err != nil {
+		return Page{}, err
+	}
+
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return Page{}, nil
+	}
+	if err != nil {
+		return Page{}, fmt.Errorf("failed to open chat file: %v", err)
+	}
+	defer f.Close()
+
+	if before < 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return Page{}, fmt.Errorf("failed to read chat file: %v", err)
+		}
+		before = info.Size()
+	}
+	lines, start, err := readLinesBefore(f, before, n)
+	if err != nil {
+		return Page{}, fmt.Errorf("failed to read chat file: %v", err)
+	}
+
+	page := Page{Before: start}
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		var msg models.LocalChatMessage
+		if json.Unmarshal(line, &msg) != nil {
+			continue
+		}
+		if msg.ID == "" {
+			msg.ID = legacyID(msg)
+		}
+		if seen[msg.ID] {
+			continue
+		}
+		seen[msg.ID] = true
+		page.Messages = append(page.Messages, msg)
+	}
+	return page, nil
+}
+
+// tail returns the last message of a chat file and how many messages from
+// the other side are newer than since. It reads backwards only as far as
+// the first message at or before since, so a long history costs no more
+// than its unread end.
+func tail(name string, since time.Time) (*models.LocalChatMessage, int, error) {
+	filePath, err := chatFilePath(name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open chat file: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read chat file: %v", err)
+	}
+
+	var last *models.LocalChatMessage
+	unread := 0
+	seen := make(map[string]bool) // Repeats may straddle two steps
+	for end := info.Size(); end > 0; {
+		lines, start, err := readLinesBefore(f, end, summaryLines)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read chat file: %v", err)
+		}
+		if start == end {
+			break
+		}
+		end = start
+		read := false
+		for i := len(lines) - 1; i >= 0; i-- {
+			var msg models.LocalChatMessage
+			if json.Unmarshal(lines[i], &msg) != nil {
+				continue
+			}
+			if msg.ID == "" {
+				msg.ID = legacyID(msg)
+			}
+			if seen[msg.ID] {
+				continue
+			}
+			seen[msg.ID] = true
+			if last == nil {
+				last = &msg
+			}
+			if !msg.Timestamp.After(since) {
+				read = true
+				break
+			}
+			if !msg.IsMe {
+				unread++
+			}
+		}
+		if read {
+			break
+		}
+	}
+	return last, unread, nil
+}
+
+// readLinesBefore returns the last n complete lines ending at byte end,
+// which must be a line boundary or the end of the file, and the offset of
+// the first of them. A torn last line is returned as it is. It
+// reads backwards a chunk at a time, so the cost follows n rather than
+// the size of the file.
+func readLinesBefore(f *os.File, end int64, n int) ([][]byte, int64, error) {
+	if end == 0 {
+		return nil, 0, nil
+	}
+	var buf []byte // Holds the file from pos to end
+	pos := end
+	for pos > 0 && bytes.Count(buf, []byte("\n")) <= n {
+		size := min(readChunk, pos)
+		pos -= size
+		chunk := make([]byte, size, int(size)+len(buf))
+		if _, err := f.ReadAt(chunk, pos); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		buf = append(chunk, buf...)
+	}
+
+	lines := bytes.Split(bytes.TrimSuffix(buf, []byte("\n")), []byte("\n"))
+	if pos > 0 {
+		// Cut off mid-line; that line belongs to the page before
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	start := end
+	for _, line := range lines {
+		start -= int64(len(line)) + 1
+	}
+	if len(lines) > 0 && !bytes.HasSuffix(buf, []byte("\n")) {
+		start++ // No newline after the last line
+	}
+	return lines, start, nil
+}
+
+func findMessage(name, id string) (models.LocalChatMessage, error) {
+	filePath, err := chatFilePath(name)
+	if err != nil {
+		return models.LocalChatMessage{}, err
+	}
+
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	idx, err := loadIndex(filePath)
+	if err != nil {
+		return models.LocalChatMessage{}, err
+	}
+	offset, ok := idx.Offsets[id]
+	if !ok {
+		return models.LocalChatMessage{}, ErrNotFound
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return models.LocalChatMessage{}, fmt.Errorf("failed to open chat file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return models.LocalChatMessage{}, fmt.Errorf("failed to read chat file: %v", err)
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return models.LocalChatMessage{}, fmt.Errorf("failed to read chat file: %v", err)
+	}
+	var msg models.LocalChatMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return models.LocalChatMessage{}, fmt.Errorf("failed to parse message: %v", err)
+	}
+	if msg.ID == "" {
+		msg.ID = legacyID(msg)
+	}
+	return msg, nil
+}
+
+func countMessages(name string) (int, error) {
+	filePath, err := chatFilePath(name)
+	if err != nil {
+		return 0, err
+	}
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+	idx, err := loadIndex(filePath)
+	if err != nil {
+		return 0, err
+	}
+	return idx.Count, nil
+}
+
+// loadIndex returns the up to date index of a chat file, indexing any
+// lines appended since it was saved. The caller holds fileMutex.
+func loadIndex(filePath string) (*chatIndex, error) {
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return &chatIndex{Offsets: make(map[string]int64)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat file: %v", err)
+	}
+
+	idx := &chatIndex{}
+	if data, err := os.ReadFile(filePath + ".idx"); err != nil || json.Unmarshal(data, idx) != nil || idx.Size > info.Size() {
+		// Missing, damaged or older than a rewrite: start over
+		idx = &chatIndex{}
+	}
+	if idx.Offsets == nil {
+		idx.Offsets = make(map[string]int64)
+	}
+	if idx.Size == info.Size() {
+		return idx, nil
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chat file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(idx.Size, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read chat file: %v", err)
+	}
+	r := bufio.NewReader(f)
+	offset := idx.Size
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var msg models.LocalChatMessage
+			if json.Unmarshal(line, &msg) == nil {
+				if msg.ID == "" {
+					msg.ID = legacyID(msg)
+				}
+				if _, dup := idx.Offsets[msg.ID]; !dup {
+					idx.Offsets[msg.ID] = offset
+					idx.Count++
+				}
+			}
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chat file: %v", err)
+		}
+	}
+	// A torn last line is left for the next time
+	idx.Size = offset
+
+	if data, err := json.Marshal(idx); err == nil {
+		// The index only saves time; if it can't be saved, the next
+		// lookup scans again
+		os.WriteFile(filePath+".idx", data, 0644)
+	}
+	return idx, nil
+}
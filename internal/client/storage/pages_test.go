@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syncra/internal/models"
+	"testing"
+)
+
+// shrinkChunk makes readLinesBefore step n bytes at a time for one test.
+func shrinkChunk(t *testing.T, n int64) {
+	t.Helper()
+	saved := readChunk
+	readChunk = n
+	t.Cleanup(func() { readChunk = saved })
+}
+
+// line is one line of a test file and where it starts
+type line struct {
+	text  string
+	start int64
+}
+
+// splitLines is the slow reading of content readLinesBefore must agree with
+func splitLines(content string) []line {
+	var lines []line
+	var offset int64
+	for content != "" {
+		text, rest, _ := strings.Cut(content, "\n")
+		lines = append(lines, line{text, offset})
+		offset += int64(len(content) - len(rest))
+		content = rest
+	}
+	return lines
+}
+
+func TestReadLinesBefore(t *testing.T) {
+	cases := map[string]string{
+		// With 8 byte chunks every newline ends a chunk
+		"chunk boundary": "1234567\n1234567\n1234567\n1234567\n",
+		// and here every chunk starts on a newline
+		"start on newline": "\n1234567\n1234567\n1234567",
+		"torn last line":   "aa\nbbbbbbbbbbb\nc\ndddd\neeeeeeeeeeeee",
+		"long lines":       strings.Repeat(strings.Repeat("x", 19)+"\n", 5),
+		"empty lines":      "a\n\n\nb\n\n",
+		"one line":         "only",
+		"empty":            "",
+	}
+	for name, content := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "chat.json")
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			lines := splitLines(content)
+			ends := []int64{int64(len(content))}
+			for _, l := range lines {
+				ends = append(ends, l.start)
+			}
+			for _, chunk := range []int64{1, 2, 7, 8, 9, 32 * 1024} {
+				shrinkChunk(t, chunk)
+				for _, end := range ends {
+					var before []line
+					for _, l := range lines {
+						if l.start < end {
+							before = append(before, l)
+						}
+					}
+					for n := 1; n <= len(lines)+1; n++ {
+						want := before[max(0, len(before)-n):]
+						wantStart := end
+						if len(want) > 0 {
+							wantStart = want[0].start
+						}
+						got, start, err := readLinesBefore(f, end, n)
+						if err != nil {
+							t.Fatal(err)
+						}
+						var texts []string
+						for _, g := range got {
+							texts = append(texts, string(g))
+						}
+						var wantTexts []string
+						for _, w := range want {
+							wantTexts = append(wantTexts, w.text)
+						}
+						if fmt.Sprint(texts) != fmt.Sprint(wantTexts) || start != wantStart {
+							t.Errorf("chunk %d, end %d, n %d: got %q at %d, want %q at %d",
+								chunk, end, n, texts, start, wantTexts, wantStart)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// messageLine is the stored form of a message with id and content
+func messageLine(t *testing.T, id, content string) string {
+	t.Helper()
+	data, err := json.Marshal(models.LocalChatMessage{ID: id, From: "bob", Content: content})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data) + "\n"
+}
+
+// checkIndex compares the offsets in idx with the lines of the chat file.
+func checkIndex(t *testing.T, path string, idx *chatIndex, ids ...string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Count != len(ids) || len(idx.Offsets) != len(ids) {
+		t.Errorf("indexed %d messages (%d offsets), want %d", idx.Count, len(idx.Offsets), len(ids))
+	}
+	for _, id := range ids {
+		offset, ok := idx.Offsets[id]
+		if !ok {
+			t.Errorf("%s not indexed", id)
+			continue
+		}
+		var msg models.LocalChatMessage
+		rest := string(data[offset:])
+		text, _, _ := strings.Cut(rest, "\n")
+		if err := json.Unmarshal([]byte(text), &msg); err != nil || msg.ID != id {
+			t.Errorf("%s: offset %d holds %q", id, offset, text)
+		}
+	}
+}
+
+func TestLoadIndex(t *testing.T) {
+	ws := setupWorkspace(t)
+	path := filepath.Join(ws, "syncra", "chats", "carol.json")
+	a, b, c := messageLine(t, "a", "first"), messageLine(t, "b", "second"), messageLine(t, "c", "third")
+
+	t.Run("torn last line", func(t *testing.T) {
+		writeFile(t, path, a+b+c[:10])
+		idx, err := loadIndex(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkIndex(t, path, idx, "a", "b")
+		if idx.Size != int64(len(a+b)) {
+			t.Errorf("covers %d bytes, want %d", idx.Size, len(a+b))
+		}
+
+		// Finished later, the line is picked up from where the index stopped
+		writeFile(t, path, a+b+c+a)
+		if idx, err = loadIndex(path); err != nil {
+			t.Fatal(err)
+		}
+		checkIndex(t, path, idx, "a", "b", "c")
+		if idx.Size != int64(len(a+b+c+a)) {
+			t.Errorf("covers %d bytes, want %d", idx.Size, len(a+b+c+a))
+		}
+	})
+
+	t.Run("stale after a rewrite", func(t *testing.T) {
+		writeFile(t, path, a+b+c)
+		if _, err := loadIndex(path); err != nil {
+			t.Fatal(err)
+		}
+		fileMutex.Lock()
+		err := replaceChatFile(path, []string{strings.TrimSuffix(c, "\n"), strings.TrimSuffix(a, "\n")}, nil, nil)
+		fileMutex.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx, err := loadIndex(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkIndex(t, path, idx, "c", "a")
+	})
+
+	t.Run("left over from a longer file", func(t *testing.T) {
+		writeFile(t, path, a+b+c)
+		if _, err := loadIndex(path); err != nil {
+			t.Fatal(err)
+		}
+		// As when the file was replaced by something shorter without
+		// its index going
+		writeFile(t, path, b)
+		idx, err := loadIndex(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkIndex(t, path, idx, "b")
+	})
+
+	t.Run("damaged", func(t *testing.T) {
+		writeFile(t, path, a+b)
+		writeFile(t, path+".idx", "{not json")
+		idx, err := loadIndex(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkIndex(t, path, idx, "a", "b")
+	})
+}
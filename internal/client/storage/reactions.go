@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"path/filepath"
+	"slices"
+	"sort"
+	"syncra/internal/models"
+)
+
+// Reactions are kept on the stored message itself, as the usernames
+// behind each emoji. Everyone in a chat may react, once per emoji.
+
+// Reaction is one emoji under a message and who chose it
+type Reaction struct {
+	Emoji string
+	Users []string
+}
+
+// SetReaction adds or removes user's emoji on message id in the chat with
+// targetUsername. Deleted messages take no reactions.
+func SetReaction(targetUsername, id, user, emoji string, on bool) error {
+	return updateMessage(targetUsername+".json", id, react(user, emoji, on))
+}
+
+// SetGroupReaction is SetReaction for a group chat
+func SetGroupReaction(groupID, id, user, emoji string, on bool) error {
+	return updateMessage(filepath.Join("groups", groupID+".json"), id, react(user, emoji, on))
+}
+
+// Reactions aggregates msg's reactions, most popular first
+func Reactions(msg models.LocalChatMessage) []Reaction {
+	list := make([]Reaction, 0, len(msg.Reactions))
+	for emoji, users := range msg.Reactions {
+		list = append(list, Reaction{Emoji: emoji, Users: users})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if len(list[i].Users) != len(list[j].Users) {
+			return len(list[i].Users) > len(list[j].Users)
+		}
+		return list[i].Emoji < list[j].Emoji
+	})
+	return list
+}
+
+// HasReacted reports whether user put emoji under msg
+func HasReacted(msg models.LocalChatMessage, user, emoji string) bool {
+	return slices.Contains(msg.Reactions[emoji], user)
+}
+
+func react(user, emoji string, on bool) func(*models.LocalChatMessage) error {
+	return func(msg *models.LocalChatMessage) error {
+		if msg.Deleted {
+			return nil
+		}
+		users := slices.DeleteFunc(slices.Clone(msg.Reactions[emoji]), func(u string) bool { return u == user })
+		if on {
+			users = append(users, user)
+		}
+		if msg.Reactions == nil {
+			msg.Reactions = make(map[string][]string)
+		}
+		if len(users) == 0 {
+			delete(msg.Reactions, emoji)
+		} else {
+			msg.Reactions[emoji] = users
+		}
+		return nil
+	}
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syncra/internal/config"
+	"syncra/internal/models"
+	"time"
+)
+
+// Messages from people we have no conversation with wait in
+// syncra/data/requests.json until accepted; senders we blocked are listed
+// in syncra/data/blocked.json. The relay enforces its own copy of the block
+// list; the local one covers LAN mode and messages already in flight.
+
+// ErrBlocked is returned by ReceiveMessage for a sender we blocked.
+var ErrBlocked = errors.New("sender is blocked")
+
+// Request holds the messages of a sender we have not accepted yet
+type Request struct {
+	From     string                    `json:"from"`
+	Messages []models.LocalChatMessage `json:"messages"`
+}
+
+var requestsMutex sync.Mutex
+
+// ReceiveMessage stores an incoming 1:1 message: in the chat history if we
+// already talk to the sender, otherwise as a message request. It reports
+// whether the message went to the requests inbox.
+func ReceiveMessage(from string, msg models.LocalChatMessage) (bool, error) {
+	blocked, err := LoadBlocked()
+	if err != nil {
+		return false, err
+	}
+	for _, b := range blocked {
+		if b == from {
+			return false, ErrBlocked
+		}
+	}
+
+	known, err := hasChat(from)
+	if err != nil {
+		return false, err
+	}
+	if known {
+		return false, AppendMessage(from, msg)
+	}
+
+	var dup bool
+	err = editRequests(func(requests map[string]*Request) {
+		r, ok := requests[from]
+		if !ok {
+			r = &Request{From: from}
+			requests[from] = r
+		}
+		for _, m := range r.Messages {
+			if msg.ID != "" && m.ID == msg.ID {
+				dup = true
+				return
+			}
+		}
+		r.Messages = append(r.Messages, msg)
+	})
+	if err == nil && dup {
+		err = ErrDuplicate
+	}
+	return true, err
+}
+
+// ListRequests returns pending message requests, most recent first
+func ListRequests() ([]Request, error) {
+	requestsMutex.Lock()
+	defer requestsMutex.Unlock()
+	path, err := dataPath("requests.json")
+	if err != nil {
+		return nil, err
+	}
+	requests, err := readRequests(path)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Request, 0, len(requests))
+	for _, r := range requests {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool { return lastReceived(list[i]).After(lastReceived(list[j])) })
+	return list, nil
+}
+
+// AcceptRequest moves a sender's pending messages into a regular chat
+func AcceptRequest(from string) error {
+	var accepted *Request
+	err := editRequests(func(requests map[string]*Request) {
+		accepted = requests[from]
+		delete(requests, from)
+	})
+	if err != nil || accepted == nil {
+		return err
+	}
+	for _, msg := range accepted.Messages {
+		if err := AppendMessage(from, msg); err != nil && !errors.Is(err, ErrDuplicate) {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropRequest discards a sender's pending messages
+func DropRequest(from string) error {
+	return editRequests(func(requests map[string]*Request) {
+		delete(requests, from)
+	})
+}
+
+// LoadBlocked returns the usernames we blocked, sorted
+func LoadBlocked() ([]string, error) {
+	requestsMutex.Lock()
+	defer requestsMutex.Unlock()
+	path, err := dataPath("blocked.json")
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block list: %v", err)
+	}
+	var blocked []string
+	if err := json.Unmarshal(data, &blocked); err != nil {
+		return nil, fmt.Errorf("failed to parse block list: %v", err)
+	}
+	return blocked, nil
+}
+
+// SaveBlocked replaces the local block list
+func SaveBlocked(blocked []string) error {
+	requestsMutex.Lock()
+	defer requestsMutex.Unlock()
+	path, err := dataPath("blocked.json")
+	if err != nil {
+		return err
+	}
+	sorted := append([]string{}, blocked...)
+	sort.Strings(sorted)
+	return writeJSON(path, sorted)
+}
+
+func lastReceived(r Request) time.Time {
+	if n := len(r.Messages); n > 0 {
+		return r.Messages[n-1].Timestamp
+	}
+	return time.Time{}
+}
+
+func hasChat(username string) (bool, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return false, fmt.Errorf("failed to load config: %v", err)
+	}
+	_, err = os.Stat(filepath.Join(cfg.WorkspacePath, "syncra", "chats", username+".json"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func editRequests(fn func(map[string]*Request)) error {
+	requestsMutex.Lock()
+	defer requestsMutex.Unlock()
+	path, err := dataPath("requests.json")
+	if err != nil {
+		return err
+	}
+	requests, err := readRequests(path)
+	if err != nil {
+		return err
+	}
+	fn(requests)
+	return writeJSON(path, requests)
+}
+
+func readRequests(path string) (map[string]*Request, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return make(map[string]*Request), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message requests: %v", err)
+	}
+	requests := make(map[string]*Request)
+	if err := json.Unmarshal(data, &requests); err != nil {
+		return nil, fmt.Errorf("failed to parse message requests: %v", err)
+	}
+	return requests, nil
+}
@@ -0,0 +1,564 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syncra/internal/config"
+	"syncra/internal/models"
+	"time"
+	"unicode"
+)
+
+// Full-text search runs on an inverted index in syncra/data/search. Each
+// word maps to postings naming the messages that contain it, spread over
+// 256 shard files by hash so a lookup reads only the shards of the words
+// searched for. Senders are indexed too, as the word "from:<username>".
+//
+// Messages are indexed as they are appended, a batch at a time. index.json
+// records how far into each chat file the index reaches; Search first
+// writes the batch so far and catches up on anything written before the
+// index existed, while it could not be saved or before a restart lost a
+// batch. A message has at most one posting per word, however often it is
+// indexed. Rewriting a chat file, as edits, deletions and expiry do, takes
+// the words a message no longer holds out of the index, so none of a
+// deleted message's text stays behind in it. Hits are still checked
+// against the stored message in case the index is behind.
+
+// Posting is one message containing a word
+type Posting struct {
+	Chat string `json:"c"` // Username, or GroupKey for a group
+	ID   string `json:"i"`
+	From string `json:"f"`
+	At   int64  `json:"t"` // Unix seconds
+}
+
+// SearchQuery narrows a search; zero fields match anything
+type SearchQuery struct {
+	Terms  []string  // Words a message must all contain, as from SearchTerms
+	From   string    // Sender username
+	Chat   string    // Username, or GroupKey for a group
+	After  time.Time // Sent at or after
+	Before time.Time // Sent before
+}
+
+// SearchHit is a matching message and the chat it is in
+type SearchHit struct {
+	Chat    string
+	Message models.LocalChatMessage
+}
+
+// maxTermBytes caps indexed words; longer runs are unlikely to be searched
+// for whole.
+const maxTermBytes = 64
+
+// indexBatch is how many appended messages wait in memory before they are
+// written to the index together.
+const indexBatch = 64
+
+// searchMutex guards the index files and pendingIndex. Taken after
+// fileMutex when both are held.
+var searchMutex sync.Mutex
+
+// pendingIndex holds appended messages not yet in the index, by chat file
+// path, with the span of the file they fill.
+var (
+	pendingIndex = make(map[string]*pendingAppends)
+	pendingCount int
+)
+
+type pendingAppends struct {
+	from, to int64
+	msgs     []models.LocalChatMessage
+}
+
+// SearchTerms splits text into lowercase words the way the index does
+func SearchTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	seen := make(map[string]bool, len(words))
+	var terms []string
+	for _, w := range words {
+		if len(w) > maxTermBytes || seen[w] {
+			continue
+		}
+		seen[w] = true
+		terms = append(terms, w)
+	}
+	return terms
+}
+
+// Search returns up to limit messages matching q, newest first. It needs a
+// word or a sender to look up.
+func Search(q SearchQuery, limit int) ([]SearchHit, error) {
+	lookup := q.Terms
+	if len(lookup) == 0 {
+		if q.From == "" {
+			return nil, fmt.Errorf("search needs a word or from:")
+		}
+		lookup = []string{fromTerm(q.From)}
+	}
+	if err := syncSearchIndex(); err != nil {
+		return nil, err
+	}
+
+	candidates, err := lookupPostings(lookup)
+	if err != nil {
+		return nil, err
+	}
+	var matched []Posting
+	for _, p := range candidates {
+		if q.Chat != "" && p.Chat != q.Chat || q.From != "" && !strings.EqualFold(p.From, q.From) {
+			continue
+		}
+		at := time.Unix(p.At, 0)
+		if !q.After.IsZero() && at.Before(q.After) || !q.Before.IsZero() && !at.Before(q.Before) {
+			continue
+		}
+		matched = append(matched, p)
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].At > matched[j].At })
+
+	var hits []SearchHit
+	now := time.Now()
+	for _, p := range matched {
+		if len(hits) == limit {
+			break
+		}
+		find := FindMessage
+		chat := p.Chat
+		if id, ok := strings.CutPrefix(chat, GroupKey("")); ok {
+			find, chat = FindGroupMessage, id
+		}
+		msg, err := find(chat, p.ID)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return hits, err
+		}
+		if msg.Deleted || Expired(msg, now) || !containsTerms(msg.Content, q.Terms) {
+			continue
+		}
+		hits = append(hits, SearchHit{Chat: p.Chat, Message: msg})
+	}
+	return hits, nil
+}
+
+// lookupPostings returns the messages holding every term, one posting each.
+func lookupPostings(terms []string) ([]Posting, error) {
+	searchMutex.Lock()
+	defer searchMutex.Unlock()
+
+	var result map[string]Posting
+	for _, term := range terms {
+		shard, err := readShard(shardOf(term))
+		if err != nil {
+			return nil, err
+		}
+		found := make(map[string]Posting)
+		for _, p := range shard[term] {
+			key := postingKey(p.Chat, p.ID)
+			if _, ok := result[key]; ok || result == nil {
+				found[key] = p
+			}
+		}
+		result = found
+		if len(result) == 0 {
+			return nil, nil
+		}
+	}
+	postings := make([]Posting, 0, len(result))
+	for _, p := range result {
+		postings = append(postings, p)
+	}
+	return postings, nil
+}
+
+func containsTerms(content string, terms []string) bool {
+	have := make(map[string]bool)
+	for _, t := range SearchTerms(content) {
+		have[t] = true
+	}
+	for _, t := range terms {
+		if !have[t] {
+			return false
+		}
+	}
+	return true
+}
+
+func fromTerm(username string) string {
+	return "from:" + strings.ToLower(username)
+}
+
+// chatKey maps the path of a history file to the chat it holds.
+func chatKey(filePath string) string {
+	name := strings.TrimSuffix(filepath.Base(filePath), ".json")
+	if filepath.Base(filepath.Dir(filePath)) == "groups" {
+		return GroupKey(name)
+	}
+	return name
+}
+
+// indexAppended queues a message just appended at offset to the chat file
+// at filePath, whose size is now size, and writes the queue once it holds
+// indexBatch messages. The caller holds fileMutex.
+func indexAppended(filePath string, offset, size int64, msg models.LocalChatMessage) {
+	searchMutex.Lock()
+	defer searchMutex.Unlock()
+	p := pendingIndex[filePath]
+	if p == nil {
+		p = &pendingAppends{from: offset, to: offset}
+		pendingIndex[filePath] = p
+	}
+	if p.to != offset {
+		// The file changed behind our back; leave it to the catch-up
+		pendingCount -= len(p.msgs)
+		delete(pendingIndex, filePath)
+		return
+	}
+	p.msgs = append(p.msgs, msg)
+	p.to = size
+	if pendingCount++; pendingCount >= indexBatch {
+		// The index only saves time; whatever fails here is picked up
+		// by the catch-up before the next search
+		flushIndex()
+	}
+}
+
+// flushIndex writes the queued appends to the index, reading and writing
+// index.json and each shard once. Chats the index does not reach the
+// start of are left to the catch-up. The caller holds searchMutex.
+func flushIndex() error {
+	if len(pendingIndex) == 0 {
+		return nil
+	}
+	pending := pendingIndex
+	pendingIndex, pendingCount = make(map[string]*pendingAppends), 0
+
+	reached, err := readIndexState()
+	if err != nil {
+		return err
+	}
+	edits := make(shardEdits)
+	for path, p := range pending {
+		key := chatKey(path)
+		if reached[key] != p.from {
+			continue
+		}
+		edits.addMessages(key, p.msgs)
+		reached[key] = p.to
+	}
+	if err := edits.apply(); err != nil {
+		return err
+	}
+	return writeIndexState(reached)
+}
+
+// indexRewritten follows the chat file at filePath being rewritten from
+// oldSize to size bytes. before holds the stored copies of the messages
+// that changed or went, after the new copies of those that changed. The
+// other messages were indexed already, so only the position moves; a file
+// not fully indexed before is indexed again. The caller holds fileMutex.
+func indexRewritten(filePath string, oldSize, size int64, before, after []models.LocalChatMessage) {
+	searchMutex.Lock()
+	defer searchMutex.Unlock()
+	flushIndex() // So the position is current
+	key := chatKey(filePath)
+	reached, err := readIndexState()
+	if err != nil {
+		return
+	}
+	// The words go even when the position is lost, as the catch-up only
+	// ever adds
+	edits := make(shardEdits)
+	edits.replaceMessages(key, before, after)
+	if edits.apply() == nil && reached[key] == oldSize {
+		reached[key] = size
+	} else {
+		delete(reached, key)
+	}
+	writeIndexState(reached)
+}
+
+// syncSearchIndex indexes whatever the chat files hold beyond what the
+// index reaches.
+func syncSearchIndex() error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	chatsDir := filepath.Join(cfg.WorkspacePath, "syncra", "chats")
+
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+	searchMutex.Lock()
+	defer searchMutex.Unlock()
+
+	flushIndex() // Anything it cannot write is caught up below
+	reached, err := readIndexState()
+	if err != nil {
+		return err
+	}
+	changed := false
+	err = filepath.WalkDir(chatsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+		key := chatKey(path)
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("failed to read chat file: %v", err)
+		}
+		from := reached[key]
+		if from == info.Size() {
+			return nil
+		}
+		if from > info.Size() {
+			from = 0 // Rewritten without us knowing
+		}
+		msgs, end, err := readMessagesFrom(path, from)
+		if err != nil {
+			return err
+		}
+		edits := make(shardEdits)
+		edits.addMessages(key, msgs)
+		if err := edits.apply(); err != nil {
+			return err
+		}
+		reached[key] = end
+		changed = true
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	return writeIndexState(reached)
+}
+
+// readMessagesFrom parses the complete lines of a chat file from offset
+// on, returning them and where they end.
+func readMessagesFrom(path string, offset int64) ([]models.LocalChatMessage, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open chat file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, 0, fmt.Errorf("failed to read chat file: %v", err)
+	}
+	r := bufio.NewReader(f)
+	var msgs []models.LocalChatMessage
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var msg models.LocalChatMessage
+			if json.Unmarshal(line, &msg) == nil {
+				if msg.ID == "" {
+					msg.ID = legacyID(msg)
+				}
+				msgs = append(msgs, msg)
+			}
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			return msgs, offset, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read chat file: %v", err)
+		}
+	}
+}
+
+// shardEdits collects changes to the index by shard, then term, so each
+// shard is read and written once.
+type shardEdits map[string]map[string]*termEdit
+
+type termEdit struct {
+	drop map[string]bool // Posting keys to take out
+	add  []Posting
+}
+
+func (e shardEdits) term(term string) *termEdit {
+	s := shardOf(term)
+	if e[s] == nil {
+		e[s] = make(map[string]*termEdit)
+	}
+	if e[s][term] == nil {
+		e[s][term] = &termEdit{drop: make(map[string]bool)}
+	}
+	return e[s][term]
+}
+
+func (e shardEdits) add(term string, p Posting) {
+	t := e.term(term)
+	t.add = append(t.add, p)
+}
+
+// addMessages indexes msgs under chat.
+func (e shardEdits) addMessages(chat string, msgs []models.LocalChatMessage) {
+	for _, msg := range msgs {
+		if msg.Deleted {
+			continue
+		}
+		if msg.ID == "" {
+			msg.ID = legacyID(msg)
+		}
+		for _, term := range messageTerms(msg) {
+			e.add(term, postingOf(chat, msg))
+		}
+	}
+}
+
+func (e shardEdits) drop(term, chat, id string) {
+	e.term(term).drop[postingKey(chat, id)] = true
+}
+
+// replaceMessages moves the postings of messages in chat from their
+// before copies to their after ones. Words both copies hold are left
+// alone, so a reaction or a delivery mark changes nothing.
+func (e shardEdits) replaceMessages(chat string, before, after []models.LocalChatMessage) {
+	had := make(map[string]map[string]bool) // Message ID, then term
+	for _, msg := range before {
+		if had[msg.ID] == nil {
+			had[msg.ID] = make(map[string]bool)
+		}
+		if !msg.Deleted {
+			for _, term := range messageTerms(msg) {
+				had[msg.ID][term] = true
+			}
+		}
+	}
+	has := make(map[string]map[string]bool)
+	for _, msg := range after {
+		if has[msg.ID] == nil {
+			has[msg.ID] = make(map[string]bool)
+		}
+		if msg.Deleted {
+			continue
+		}
+		for _, term := range messageTerms(msg) {
+			if !had[msg.ID][term] && !has[msg.ID][term] {
+				e.add(term, postingOf(chat, msg))
+			}
+			has[msg.ID][term] = true
+		}
+	}
+	for id, terms := range had {
+		for term := range terms {
+			if !has[id][term] {
+				e.drop(term, chat, id)
+			}
+		}
+	}
+}
+
+// apply writes the collected changes. An added posting replaces any the
+// term already has for the same message; terms left without postings go.
+func (e shardEdits) apply() error {
+	for s, terms := range e {
+		shard, err := readShard(s)
+		if err != nil {
+			return err
+		}
+		for term, t := range terms {
+			added := make(map[string]Posting, len(t.add))
+			for _, p := range t.add {
+				added[postingKey(p.Chat, p.ID)] = p
+			}
+			postings := shard[term][:0]
+			for _, p := range shard[term] {
+				key := postingKey(p.Chat, p.ID)
+				if _, replaced := added[key]; !replaced && !t.drop[key] {
+					postings = append(postings, p)
+				}
+			}
+			for _, p := range t.add {
+				key := postingKey(p.Chat, p.ID)
+				if q, ok := added[key]; ok {
+					postings = append(postings, q)
+					delete(added, key)
+				}
+			}
+			if len(postings) == 0 {
+				delete(shard, term)
+			} else {
+				shard[term] = postings
+			}
+		}
+		path, err := dataPath(filepath.Join("search", s+".json"))
+		if err != nil {
+			return err
+		}
+		if err := writeJSON(path, shard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// messageTerms lists the words msg is indexed under.
+func messageTerms(msg models.LocalChatMessage) []string {
+	return append(SearchTerms(msg.Content), fromTerm(msg.From))
+}
+
+func postingOf(chat string, msg models.LocalChatMessage) Posting {
+	return Posting{Chat: chat, ID: msg.ID, From: msg.From, At: msg.Timestamp.Unix()}
+}
+
+func postingKey(chat, id string) string {
+	return chat + "\x00" + id
+}
+
+func shardOf(term string) string {
+	h := fnv.New32a()
+	h.Write([]byte(term))
+	return fmt.Sprintf("%02x", h.Sum32()&0xff)
+}
+
+func readShard(s string) (map[string][]Posting, error) {
+	path, err := dataPath(filepath.Join("search", s+".json"))
+	if err != nil {
+		return nil, err
+	}
+	shard := make(map[string][]Posting)
+	if err := readJSON(path, &shard); err != nil {
+		return nil, err
+	}
+	return shard, nil
+}
+
+// readIndexState returns how many bytes of each chat file are indexed,
+// by chat
+func readIndexState() (map[string]int64, error) {
+	path, err := dataPath(filepath.Join("search", "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	reached := make(map[string]int64)
+	if err := readJSON(path, &reached); err != nil {
+		return nil, err
+	}
+	return reached, nil
+}
+
+func writeIndexState(reached map[string]int64) error {
+	path, err := dataPath(filepath.Join("search", "index.json"))
+	if err != nil {
+		return err
+	}
+	return writeJSON(path, reached)
+}
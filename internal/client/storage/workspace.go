@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syncra/internal/config"
+	"time"
+)
+
+// Moving a workspace copies its syncra folder next to the new location,
+// checks every file against the original, renames the copy into place and
+// only then points config.json at it and removes the old folder. Until
+// config.json is saved nothing outside the staging folder has changed, so
+// any failure leaves the old workspace in use as it was.
+
+// ErrWorkspaceExists is returned by MoveWorkspace when the destination
+// already has a syncra folder with files in it and the mode is MoveRefuse.
+var ErrWorkspaceExists = errors.New("destination already has a syncra folder")
+
+// rename is os.Rename, replaced by tests to make a step of a move fail.
+var rename = os.Rename
+
+// MoveMode says what MoveWorkspace does about an existing syncra folder at
+// the destination
+type MoveMode int
+
+const (
+	MoveRefuse  MoveMode = iota // Fail with ErrWorkspaceExists
+	MoveReplace                 // Set it aside as syncra-replaced-<time> and move in
+	MoveAdopt                   // Switch to it as is; it must hold the same identity key
+)
+
+// Relocation describes a finished move
+type Relocation struct {
+	Config   *config.Config // As saved, pointing at the new workspace
+	Files    int
+	Bytes    int64
+	SetAside string // Where a replaced destination folder went
+	Left     string // Old syncra folder when it was kept or could not be removed
+}
+
+// MoveWorkspace moves the workspace's syncra folder under dest and saves
+// the config pointing there. Storage is held still meanwhile; files the
+// caller writes itself, such as logs, should be closed first.
+func MoveWorkspace(dest string, mode MoveMode) (*Relocation, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %v", err)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("workspace not initialized")
+	}
+	from, err := filepath.Abs(cfg.WorkspacePath)
+	if err != nil {
+		return nil, err
+	}
+	to, err := filepath.Abs(dest)
+	if err != nil {
+		return nil, err
+	}
+	src, dst := filepath.Join(from, "syncra"), filepath.Join(to, "syncra")
+	if src == dst {
+		return &Relocation{Config: cfg}, nil
+	}
+	if within(dst, src) || within(src, dst) {
+		return nil, fmt.Errorf("cannot move the workspace into itself")
+	}
+
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+	searchMutex.Lock()
+	defer searchMutex.Unlock()
+	requestsMutex.Lock()
+	defer requestsMutex.Unlock()
+	contactsMutex.Lock()
+	defer contactsMutex.Unlock()
+	expiryMutex.Lock()
+	defer expiryMutex.Unlock()
+	outboxMutex.Lock()
+	defer outboxMutex.Unlock()
+	groupsMutex.Lock()
+	defer groupsMutex.Unlock()
+
+	// Queued index writes name files of the old workspace
+	flushIndex()
+
+	used, err := holdsFiles(dst)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect destination: %v", err)
+	}
+	moved := *cfg
+	moved.WorkspacePath = to
+	rel := &Relocation{Config: &moved}
+	if used {
+		switch mode {
+		case MoveRefuse:
+			return nil, ErrWorkspaceExists
+		case MoveAdopt:
+			if err := sameIdentity(src, dst); err != nil {
+				return nil, err
+			}
+			if err := config.SaveConfig(&moved); err != nil {
+				return nil, fmt.Errorf("failed to save config: %v", err)
+			}
+			clear(seenIDs)
+			dueAt = nil
+			rel.Left = src
+			return rel, nil
+		}
+	}
+
+	// Copy into a staging folder beside the destination, so the final
+	// rename stays on one filesystem
+	if err := os.MkdirAll(to, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", to, err)
+	}
+	staging, err := os.MkdirTemp(to, ".syncra-move-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging folder: %v", err)
+	}
+	defer os.RemoveAll(staging) // Empty by then on success
+	staged := filepath.Join(staging, "syncra")
+	if rel.Files, rel.Bytes, err = copyTree(src, staged); err != nil {
+		return nil, fmt.Errorf("failed to copy workspace: %v", err)
+	}
+	if err := verifyTree(src, staged, rel.Files); err != nil {
+		return nil, fmt.Errorf("copy does not match the workspace: %v", err)
+	}
+
+	if used {
+		rel.SetAside = filepath.Join(to, "syncra-replaced-"+time.Now().Format("20060102-150405"))
+		if err := rename(dst, rel.SetAside); err != nil {
+			return nil, fmt.Errorf("failed to set %s aside: %v", dst, err)
+		}
+	} else if err := os.RemoveAll(dst); err != nil {
+		// Only empty folders, as InitializeStructure leaves
+		return nil, fmt.Errorf("failed to clear %s: %v", dst, err)
+	}
+	rollback := func() {
+		os.RemoveAll(dst)
+		if rel.SetAside != "" {
+			rename(rel.SetAside, dst)
+		}
+	}
+	if err := rename(staged, dst); err != nil {
+		rollback()
+		return nil, fmt.Errorf("failed to move copy into place: %v", err)
+	}
+	if err := config.SaveConfig(&moved); err != nil {
+		rollback()
+		return nil, fmt.Errorf("failed to save config: %v", err)
+	}
+
+	// The new workspace is in use; the old one is only a leftover now
+	clear(seenIDs)
+	dueAt = nil
+	if err := os.RemoveAll(src); err != nil {
+		rel.Left = src
+	}
+	return rel, nil
+}
+
+// within reports whether path is dir or inside it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// holdsFiles reports whether anything but folders lies under dir.
+func holdsFiles(dir string) (bool, error) {
+	found := false
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == dir {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found, err
+}
+
+// sameIdentity checks that two syncra folders hold the same identity key.
+func sameIdentity(src, dst string) error {
+	mine, err := os.ReadFile(filepath.Join(src, "identities", "id_ed25519"))
+	if err != nil {
+		return fmt.Errorf("failed to read identity key: %v", err)
+	}
+	theirs, err := os.ReadFile(filepath.Join(dst, "identities", "id_ed25519"))
+	if err != nil || !bytes.Equal(mine, theirs) {
+		return fmt.Errorf("%s holds another identity; it cannot be used as is", dst)
+	}
+	return nil
+}
+
+// copyTree copies the folder src to dst, which must not exist, keeping
+// permissions and modification times. Only folders and regular files are
+// expected.
+func copyTree(src, dst string) (files int, size int64, err error) {
+	err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.Mkdir(target, info.Mode().Perm())
+		case !info.Mode().IsRegular():
+			return fmt.Errorf("%s is not a regular file", rel)
+		}
+		n, err := copyFile(p, target, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		files++
+		size += n
+		return os.Chtimes(target, info.ModTime(), info.ModTime())
+	})
+	return files, size, err
+}
+
+func copyFile(src, dst string, perm fs.FileMode) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// verifyTree checks that dst holds exactly the files of src, byte for
+// byte, reading both back from disk.
+func verifyTree(src, dst string, files int) error {
+	checked := 0
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		a, err := hashFile(p)
+		if err != nil {
+			return err
+		}
+		b, err := hashFile(filepath.Join(dst, rel))
+		if err != nil {
+			return err
+		}
+		if a != b {
+			return fmt.Errorf("%s differs", rel)
+		}
+		checked++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if checked != files {
+		return fmt.Errorf("copied %d files but found %d", files, checked)
+	}
+	return nil
+}
+
+func hashFile(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
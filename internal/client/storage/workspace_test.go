@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syncra/internal/config"
+	"testing"
+)
+
+// setupWorkspace points the config at a fresh workspace holding an
+// identity key and one chat, and returns its path.
+func setupWorkspace(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	ws := filepath.Join(home, "old")
+	if err := config.InitializeStructure(ws); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(ws, "syncra", "identities", "id_ed25519"), "my key")
+	writeFile(t, filepath.Join(ws, "syncra", "chats", "bob.json"), `{"id":"1","from":"bob","content":"hi"}`+"\n")
+	if err := config.SaveConfig(&config.Config{WorkspacePath: ws, Username: "me"}); err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// workspacePath returns the workspace config.json points at.
+func workspacePath(t *testing.T) string {
+	t.Helper()
+	cfg, err := config.LoadConfig()
+	if err != nil || cfg == nil {
+		t.Fatalf("config: %v", err)
+	}
+	return cfg.WorkspacePath
+}
+
+func TestMoveWorkspace(t *testing.T) {
+	old := setupWorkspace(t)
+	dest := filepath.Join(filepath.Dir(old), "new")
+	if err := config.InitializeStructure(dest); err != nil { // Empty folders only
+		t.Fatal(err)
+	}
+
+	rel, err := MoveWorkspace(dest, MoveRefuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel.Config.WorkspacePath != dest || workspacePath(t) != dest {
+		t.Errorf("config points at %s", workspacePath(t))
+	}
+	if rel.Files != 2 || rel.SetAside != "" || rel.Left != "" {
+		t.Errorf("relocation %+v", rel)
+	}
+	if got := readFile(t, filepath.Join(dest, "syncra", "identities", "id_ed25519")); got != "my key" {
+		t.Errorf("identity key %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(old, "syncra")); !os.IsNotExist(err) {
+		t.Error("old workspace left behind")
+	}
+	if staging, _ := filepath.Glob(filepath.Join(dest, ".syncra-move-*")); len(staging) > 0 {
+		t.Errorf("staging left behind: %v", staging)
+	}
+	if _, err := MoveWorkspace(dest, MoveRefuse); err != nil {
+		t.Errorf("move onto itself: %v", err)
+	}
+}
+
+func TestMoveWorkspaceIntoItself(t *testing.T) {
+	old := setupWorkspace(t)
+	for _, dest := range []string{
+		filepath.Join(old, "syncra"),
+		filepath.Join(old, "syncra", "chats"),
+	} {
+		if _, err := MoveWorkspace(dest, MoveReplace); err == nil {
+			t.Errorf("moved into %s", dest)
+		}
+	}
+	if workspacePath(t) != old {
+		t.Error("config changed")
+	}
+}
+
+func TestWithin(t *testing.T) {
+	sep := string(filepath.Separator)
+	dir := sep + filepath.Join("a", "syncra")
+	cases := map[string]bool{
+		dir:                                  true,
+		filepath.Join(dir, "chats"):          true,
+		filepath.Join(dir, "..", "syncra"):   true,
+		sep + "a":                            false,
+		sep + filepath.Join("a", "syncra2"):  false,
+		sep + filepath.Join("a", "..syncra"): false,
+		sep + filepath.Join("b", "syncra"):   false,
+	}
+	for path, want := range cases {
+		if got := within(path, dir); got != want {
+			t.Errorf("within(%q, %q) = %v, want %v", path, dir, got, want)
+		}
+	}
+}
+
+// occupy gives dest a syncra folder of its own holding key as identity.
+func occupy(t *testing.T, dest, key string) string {
+	t.Helper()
+	dst := filepath.Join(dest, "syncra")
+	writeFile(t, filepath.Join(dst, "identities", "id_ed25519"), key)
+	writeFile(t, filepath.Join(dst, "chats", "carol.json"), "theirs\n")
+	return dst
+}
+
+func TestMoveWorkspaceRefuse(t *testing.T) {
+	old := setupWorkspace(t)
+	dest := filepath.Join(filepath.Dir(old), "new")
+	dst := occupy(t, dest, "other key")
+
+	if _, err := MoveWorkspace(dest, MoveRefuse); !errors.Is(err, ErrWorkspaceExists) {
+		t.Fatalf("got %v, want ErrWorkspaceExists", err)
+	}
+	if workspacePath(t) != old {
+		t.Error("config changed")
+	}
+	if got := readFile(t, filepath.Join(dst, "chats", "carol.json")); got != "theirs\n" {
+		t.Errorf("destination changed: %q", got)
+	}
+	if readFile(t, filepath.Join(old, "syncra", "identities", "id_ed25519")) != "my key" {
+		t.Error("workspace changed")
+	}
+}
+
+func TestMoveWorkspaceReplace(t *testing.T) {
+	old := setupWorkspace(t)
+	dest := filepath.Join(filepath.Dir(old), "new")
+	occupy(t, dest, "other key")
+
+	rel, err := MoveWorkspace(dest, MoveReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workspacePath(t) != dest {
+		t.Errorf("config points at %s", workspacePath(t))
+	}
+	if !strings.HasPrefix(filepath.Base(rel.SetAside), "syncra-replaced-") || filepath.Dir(rel.SetAside) != dest {
+		t.Fatalf("set aside as %q", rel.SetAside)
+	}
+	if got := readFile(t, filepath.Join(rel.SetAside, "chats", "carol.json")); got != "theirs\n" {
+		t.Errorf("set aside folder holds %q", got)
+	}
+	if got := readFile(t, filepath.Join(dest, "syncra", "identities", "id_ed25519")); got != "my key" {
+		t.Errorf("identity key %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "syncra", "chats", "carol.json")); !os.IsNotExist(err) {
+		t.Error("replaced chat still in use")
+	}
+}
+
+func TestMoveWorkspaceReplaceRollback(t *testing.T) {
+	old := setupWorkspace(t)
+	dest := filepath.Join(filepath.Dir(old), "new")
+	dst := occupy(t, dest, "other key")
+
+	// Fail moving the copy into place, after the old folder was set aside
+	defer func() { rename = os.Rename }()
+	setAside := false
+	rename = func(from, to string) error {
+		if from == dst {
+			setAside = true
+		}
+		if strings.HasPrefix(filepath.Base(filepath.Dir(from)), ".syncra-move-") {
+			return errors.New("injected")
+		}
+		return os.Rename(from, to)
+	}
+
+	if _, err := MoveWorkspace(dest, MoveReplace); err == nil || !strings.Contains(err.Error(), "injected") {
+		t.Fatalf("got %v", err)
+	}
+	if !setAside {
+		t.Fatal("failed before setting the destination aside")
+	}
+	if workspacePath(t) != old {
+		t.Error("config changed")
+	}
+	if got := readFile(t, filepath.Join(dst, "chats", "carol.json")); got != "theirs\n" {
+		t.Errorf("destination not restored: %q", got)
+	}
+	entries, err := os.ReadDir(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "syncra" {
+		t.Errorf("destination holds %v", entries)
+	}
+	if readFile(t, filepath.Join(old, "syncra", "chats", "bob.json")) == "" {
+		t.Error("workspace changed")
+	}
+}
+
+func TestMoveWorkspaceAdopt(t *testing.T) {
+	old := setupWorkspace(t)
+
+	stranger := filepath.Join(filepath.Dir(old), "stranger")
+	occupy(t, stranger, "other key")
+	if _, err := MoveWorkspace(stranger, MoveAdopt); err == nil {
+		t.Fatal("adopted a workspace with another identity")
+	}
+	if workspacePath(t) != old {
+		t.Error("config changed")
+	}
+
+	dest := filepath.Join(filepath.Dir(old), "new")
+	dst := occupy(t, dest, "my key")
+	rel, err := MoveWorkspace(dest, MoveAdopt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workspacePath(t) != dest {
+		t.Errorf("config points at %s", workspacePath(t))
+	}
+	if rel.Left != filepath.Join(old, "syncra") || rel.Files != 0 {
+		t.Errorf("relocation %+v", rel)
+	}
+	// Nothing is copied either way
+	if got := readFile(t, filepath.Join(dst, "chats", "carol.json")); got != "theirs\n" {
+		t.Errorf("adopted folder changed: %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "chats", "bob.json")); !os.IsNotExist(err) {
+		t.Error("chat copied into the adopted folder")
+	}
+	if readFile(t, filepath.Join(old, "syncra", "chats", "bob.json")) == "" {
+		t.Error("old workspace removed")
+	}
+}
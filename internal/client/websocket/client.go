@@ -1,42 +1,128 @@
 package websocket
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/url"
+	"syncra/internal/codec"
 	"syncra/internal/models"
+	"syncra/internal/protocol"
 
 	"github.com/gorilla/websocket"
 )
 
+// clientHello is what this build offers during protocol negotiation.
+var clientHello = models.NewHello("syncra-cli", []string{models.FeatureReceipts}, []string{models.SuiteGroup})
+
+// dialer offers every codec we speak; the relay picks one.
+var dialer = &websocket.Dialer{
+	Proxy:            websocket.DefaultDialer.Proxy,
+	HandshakeTimeout: websocket.DefaultDialer.HandshakeTimeout,
+	Subprotocols:     codec.Subprotocols(),
+}
+
 type Connection struct {
 	Conn *websocket.Conn
 	Send chan models.Packet
+
+	// Negotiated protocol, filled in when the relay answers our hello
+	Protocol models.HelloPayload
+
+	// Wire encoding agreed in the WebSocket handshake
+	Codec codec.Codec
+
+	// Packets from the last frame not yet returned by ReadPacket, and any
+	// decode problem to report after them
+	pending    []models.Packet
+	pendingErr error
 }
 
 func Connect(serverAddr string) (*Connection, error) {
 	u := url.URL{Scheme: "ws", Host: serverAddr, Path: "/ws"}
 
-	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	c, _, err := dialer.Dial(u.String(), nil)
 	if err != nil {
 		return nil, fmt.Errorf("dial: %v", err)
 	}
 
 	conn := &Connection{
-		Conn: c,
-		Send: make(chan models.Packet, 256),
+		Conn:  c,
+		Send:  make(chan models.Packet, 256),
+		Codec: codec.ForSubprotocol(c.Subprotocol()),
 	}
 
+	// Negotiate before anything else; the relay refuses auth without it
+	conn.Send <- protocol.Hello(clientHello)
+
 	return conn, nil
 }
 
+// WritePump writes queued packets until Send is closed. A failed write
+// closes the connection so the reader notices and the caller reconnects;
+// anything unacked is resent from the Tracker afterwards.
 func (c *Connection) WritePump() {
+	frameType := websocket.TextMessage
+	if c.Codec.Binary() {
+		frameType = websocket.BinaryMessage
+	}
 	for packet := range c.Send {
-		data, _ := json.Marshal(packet)
-		c.Conn.WriteMessage(websocket.TextMessage, data)
+		if packet.Version == 0 {
+			packet.Version = models.ProtocolVersion
+		}
+		data, err := c.Codec.Marshal(packet)
+		if err != nil {
+			continue
+		}
+		if err := c.Conn.WriteMessage(frameType, data); err != nil {
+			c.Conn.Close()
+			return
+		}
+	}
+}
+
+// ReadPacket returns the next packet, reading a new frame only once every
+// packet of the previous one has been handed out. The relay coalesces
+// queued packets into a single frame under load, so one read may yield many.
+// Undecodable packets are reported as a DecodeError after the good ones
+// from the same frame; callers can skip it without dropping the connection.
+// ReadPacket must not be called concurrently.
+func (c *Connection) ReadPacket() (models.Packet, error) {
+	if len(c.pending) == 0 {
+		if err := c.pendingErr; err != nil {
+			c.pendingErr = nil
+			return models.Packet{}, err
+		}
+
+		_, data, err := c.Conn.ReadMessage()
+		if err != nil {
+			return models.Packet{}, err
+		}
+		packets, err := c.Codec.UnmarshalFrame(data)
+		if err != nil {
+			c.pendingErr = &DecodeError{Err: err, Size: len(data)}
+		}
+		if len(packets) == 0 {
+			return c.ReadPacket()
+		}
+		c.pending = packets
 	}
+
+	p := c.pending[0]
+	c.pending = c.pending[1:]
+	return p, nil
 }
 
+// DecodeError reports a frame that arrived intact but could not be parsed.
+type DecodeError struct {
+	Err  error
+	Size int
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("undecodable frame (%d bytes): %v", e.Size, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
 func (c *Connection) Close() {
 	c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 	c.Conn.Close()
@@ -0,0 +1,152 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syncra/internal/codec"
+	"syncra/internal/models"
+	"syncra/internal/protocol"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// serveFrames runs a relay stand-in that speaks only c and writes each of
+// frames as one WebSocket message, then closes.
+func serveFrames(t *testing.T, c codec.Codec, frames [][]byte) *Connection {
+	t.Helper()
+	upgrader := websocket.Upgrader{Subprotocols: []string{c.Name()}}
+	frameType := websocket.TextMessage
+	if c.Binary() {
+		frameType = websocket.BinaryMessage
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, f := range frames {
+			if err := conn.WriteMessage(frameType, f); err != nil {
+				return
+			}
+		}
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, err := Connect(strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Conn.Close() })
+	if conn.Codec.Name() != c.Name() {
+		t.Fatalf("negotiated %s, want %s", conn.Codec.Name(), c.Name())
+	}
+	return conn
+}
+
+// encode marshals packets and joins them into one frame.
+func encode(t *testing.T, c codec.Codec, packets ...models.Packet) []byte {
+	t.Helper()
+	var parts [][]byte
+	for _, p := range packets {
+		data, err := c.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, data)
+	}
+	return bytes.Join(parts, c.Delimiter())
+}
+
+func TestReadPacketFrames(t *testing.T) {
+	notice := func(s string) models.Packet { return protocol.System(s) }
+	for _, c := range []codec.Codec{codec.JSON, codec.MsgPack} {
+		t.Run(c.Name(), func(t *testing.T) {
+			truncated := encode(t, c, notice("cut"))
+			truncated = truncated[:len(truncated)/2]
+
+			frames := [][]byte{
+				// Several packets coalesced under load
+				encode(t, c, notice("one"), notice("two"), notice("three")),
+				encode(t, c, notice("four")),
+				// A good packet followed by one cut short
+				append(append(encode(t, c, notice("five")), c.Delimiter()...), truncated...),
+				// Nothing usable at all
+				truncated,
+				encode(t, c, notice("six")),
+			}
+			if c.Delimiter() != nil {
+				// Stray delimiters, as a trailing newline leaves
+				frames = append(frames, append(encode(t, c, notice("seven"), notice("eight")), "\n\n"...))
+			}
+			conn := serveFrames(t, c, frames)
+
+			want := []string{"one", "two", "three", "four", "five", "<decode error>", "<decode error>", "six"}
+			if c.Delimiter() != nil {
+				want = append(want, "seven", "eight")
+			}
+			for i, w := range want {
+				p, err := conn.ReadPacket()
+				var de *DecodeError
+				if errors.As(err, &de) {
+					if w != "<decode error>" {
+						t.Fatalf("read %d: unexpected %v", i, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("read %d: %v", i, err)
+				}
+				text, err := protocol.DecodeText(p)
+				if err != nil {
+					t.Fatalf("read %d: %v", i, err)
+				}
+				if text != w {
+					t.Fatalf("read %d: got %q, want %q", i, text, w)
+				}
+			}
+
+			// The close ends the stream with a plain connection error
+			var de *DecodeError
+			if _, err := conn.ReadPacket(); err == nil || errors.As(err, &de) {
+				t.Fatalf("after the last frame: %v", err)
+			}
+		})
+	}
+}
+
+func TestReadPacketKeepsFields(t *testing.T) {
+	chat, err := protocol.Chat("alice", "bob", models.ChatPayload{Message: "c2VjcmV0", Expires: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack := protocol.Ack(chat.ID, models.AckDelivered, "")
+	for _, c := range []codec.Codec{codec.JSON, codec.MsgPack} {
+		t.Run(c.Name(), func(t *testing.T) {
+			conn := serveFrames(t, c, [][]byte{encode(t, c, chat, ack)})
+			got, err := conn.ReadPacket()
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload, err := protocol.DecodeChat(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != chat.ID || got.From != "alice" || got.To != "bob" || payload.Message != "c2VjcmV0" || payload.Expires != 30 {
+				t.Fatalf("chat changed in transit: %+v %+v", got, payload)
+			}
+			got, err = conn.ReadPacket()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a, err := protocol.DecodeAck(got); err != nil || a.ID != chat.ID {
+				t.Fatalf("ack: %+v, %v", a, err)
+			}
+		})
+	}
+}
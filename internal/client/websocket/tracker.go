@@ -0,0 +1,183 @@
+package websocket
+
+import (
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"syncra/internal/models"
+	"time"
+)
+
+const (
+	// First retry delay for an unacked packet; doubles on every attempt.
+	retryBase = 2 * time.Second
+
+	// Longest gap between retries.
+	retryMax = time.Minute
+
+	// Attempts after which a packet is given up on, about an hour of
+	// retries at retryMax.
+	retryLimit = 60
+
+	// Age after which a packet is given up on however few attempts it had,
+	// as reconnects restart the count.
+	retryAge = 24 * time.Hour
+)
+
+// Tracker remembers sent packets until the relay acks them, so they can be
+// resent after a timeout, an offline recipient or a reconnect. It outlives
+// any single Connection and is safe for concurrent use.
+type Tracker struct {
+	mu       sync.Mutex
+	inflight map[string]*inflight
+}
+
+type inflight struct {
+	packet   models.Packet
+	attempts int
+	next     time.Time
+	sent     time.Time // When tracking began
+}
+
+// spent reports whether f has run out of retries
+func (f *inflight) spent(now time.Time) bool {
+	return f.attempts >= retryLimit || now.Sub(f.sent) >= retryAge
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{inflight: make(map[string]*inflight)}
+}
+
+// Track starts waiting for an ack of p, which must carry an ID. The first
+// attempt is assumed to have just been sent.
+func (t *Tracker) Track(p models.Packet) {
+	if p.ID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.inflight[p.ID] = &inflight{packet: p, attempts: 1, next: now.Add(backoff(1)), sent: now}
+}
+
+// Ack applies a receipt from the relay and returns the packet it refers to.
+// Delivered and rejected packets are forgotten; offline ones stay queued
+// and back off until they run out of retries, when Expired hands them
+// back. ok is false for acks of packets we are not tracking, such
+// as duplicates of an earlier ack.
+func (t *Tracker) Ack(a models.AckPayload) (p models.Packet, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.inflight[a.ID]
+	if !ok {
+		return models.Packet{}, false
+	}
+	if a.Status == models.AckOffline {
+		f.next = time.Now().Add(backoff(f.attempts))
+	} else {
+		delete(t.inflight, a.ID)
+	}
+	return f.packet, true
+}
+
+// Due returns the packets whose retry time has passed, oldest first, and
+// schedules their next attempt. Packets out of retries are left for
+// Expired.
+func (t *Tracker) Due(now time.Time) []models.Packet {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var due []models.Packet
+	for _, f := range t.inflight {
+		if now.Before(f.next) || f.spent(now) {
+			continue
+		}
+		f.attempts++
+		f.next = now.Add(backoff(f.attempts))
+		due = append(due, f.packet)
+	}
+	sortByTime(due)
+	return due
+}
+
+// Expired returns the packets that ran out of retries, oldest first, and
+// stops tracking them.
+func (t *Tracker) Expired(now time.Time) []models.Packet {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var expired []models.Packet
+	for id, f := range t.inflight {
+		if f.spent(now) {
+			expired = append(expired, f.packet)
+			delete(t.inflight, id)
+		}
+	}
+	sortByTime(expired)
+	return expired
+}
+
+// Resend returns every unacked packet, oldest first, for replay on a fresh
+// connection. Their backoff restarts, but not their age.
+func (t *Tracker) Resend() []models.Packet {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	all := make([]models.Packet, 0, len(t.inflight))
+	now := time.Now()
+	for _, f := range t.inflight {
+		if now.Sub(f.sent) >= retryAge {
+			continue
+		}
+		f.attempts = 1
+		f.next = now.Add(backoff(1))
+		all = append(all, f.packet)
+	}
+	sortByTime(all)
+	return all
+}
+
+// Drain returns every unacked packet, oldest first, and stops tracking
+// them. It is for relays that never send acks.
+func (t *Tracker) Drain() []models.Packet {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	all := make([]models.Packet, 0, len(t.inflight))
+	for id, f := range t.inflight {
+		all = append(all, f.packet)
+		delete(t.inflight, id)
+	}
+	sortByTime(all)
+	return all
+}
+
+// Forget stops waiting for an ack of the packet with the given ID, as
+// when it is no longer worth delivering.
+func (t *Tracker) Forget(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.inflight, id)
+}
+
+// Len is the number of packets still waiting for an ack.
+func (t *Tracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.inflight)
+}
+
+// backoff is the delay after the given attempt: exponential, capped, with
+// up to 20% jitter so clients coming back together don't retry in lockstep.
+func backoff(attempt int) time.Duration {
+	d := retryBase
+	for i := 1; i < attempt && d < retryMax; i++ {
+		d *= 2
+	}
+	if d > retryMax {
+		d = retryMax
+	}
+	return d + time.Duration(rand.Int64N(int64(d/5)+1))
+}
+
+func sortByTime(packets []models.Packet) {
+	sort.Slice(packets, func(i, j int) bool {
+		return packets[i].Timestamp.Before(packets[j].Timestamp)
+	})
+}
@@ -0,0 +1,51 @@
+package websocket
+
+import (
+	"syncra/internal/models"
+	"testing"
+	"time"
+)
+
+func TestTrackerGivesUp(t *testing.T) {
+	tr := NewTracker()
+	p := models.Packet{ID: "a", Timestamp: time.Now()}
+	tr.Track(p)
+
+	// An offline recipient keeps the packet queued, retry after retry
+	now := time.Now()
+	for i := 1; i < retryLimit; i++ {
+		if _, ok := tr.Ack(models.AckPayload{ID: "a", Status: models.AckOffline}); !ok {
+			t.Fatalf("attempt %d: ack of a tracked packet ignored", i)
+		}
+		now = now.Add(retryMax * 2)
+		if got := tr.Expired(now); len(got) != 0 {
+			t.Fatalf("attempt %d: expired early", i)
+		}
+		if due := tr.Due(now); len(due) != 1 {
+			t.Fatalf("attempt %d: %d due", i, len(due))
+		}
+	}
+	now = now.Add(retryMax * 2)
+	if due := tr.Due(now); len(due) != 0 {
+		t.Fatalf("retried past the limit: %d due", len(due))
+	}
+	if got := tr.Expired(now); len(got) != 1 || got[0].ID != "a" {
+		t.Fatalf("expired %v", got)
+	}
+	if tr.Len() != 0 {
+		t.Fatal("expired packet still tracked")
+	}
+
+	// Reconnects restart the attempts but not the age
+	tr.Track(models.Packet{ID: "b"})
+	later := time.Now().Add(retryAge)
+	if got := tr.Resend(); len(got) != 1 {
+		t.Fatalf("resend %v", got)
+	}
+	if due := tr.Due(later); len(due) != 0 {
+		t.Fatalf("retried past the age limit: %d due", len(due))
+	}
+	if got := tr.Expired(later); len(got) != 1 || got[0].ID != "b" {
+		t.Fatalf("expired %v", got)
+	}
+}
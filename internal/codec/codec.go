@@ -0,0 +1,94 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"syncra/internal/models"
+)
+
+// WebSocket subprotocol names, one per codec.
+const (
+	SubprotocolJSON    = "syncra.json"
+	SubprotocolMsgPack = "syncra.msgpack"
+)
+
+// Codec turns packets into WebSocket frame bodies and back.
+//
+// A single frame may carry several packets: writers coalesce queued packets
+// by joining their encodings with Delimiter, and readers must use
+// UnmarshalFrame to recover all of them. JSON frames are newline-delimited
+// (encoding/json never emits a raw newline); MessagePack values are
+// self-delimiting and are simply concatenated.
+type Codec interface {
+	// Name is the WebSocket subprotocol that selects this codec.
+	Name() string
+
+	// Binary reports whether frames are sent as binary rather than text.
+	Binary() bool
+
+	// Delimiter separates packets coalesced into one frame.
+	Delimiter() []byte
+
+	Marshal(p models.Packet) ([]byte, error)
+	Unmarshal(data []byte, p *models.Packet) error
+
+	// UnmarshalFrame decodes every packet in a frame. Packets that decode
+	// are returned even when others in the same frame do not; err then
+	// describes what was skipped.
+	UnmarshalFrame(data []byte) ([]models.Packet, error)
+}
+
+var (
+	JSON    Codec = jsonCodec{}
+	MsgPack Codec = msgpackCodec{}
+)
+
+// Subprotocols lists the codecs we speak, most preferred first, for use in
+// Upgrader.Subprotocols and Dialer.Subprotocols.
+func Subprotocols() []string {
+	return []string{SubprotocolMsgPack, SubprotocolJSON}
+}
+
+// ForSubprotocol returns the codec selected during the WebSocket handshake.
+// Peers that negotiated nothing get JSON.
+func ForSubprotocol(name string) Codec {
+	if name == SubprotocolMsgPack {
+		return MsgPack
+	}
+	return JSON
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string      { return SubprotocolJSON }
+func (jsonCodec) Binary() bool      { return false }
+func (jsonCodec) Delimiter() []byte { return []byte{'\n'} }
+
+func (jsonCodec) Marshal(p models.Packet) ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (jsonCodec) Unmarshal(data []byte, p *models.Packet) error {
+	return json.Unmarshal(data, p)
+}
+
+func (c jsonCodec) UnmarshalFrame(data []byte) ([]models.Packet, error) {
+	var packets []models.Packet
+	var bad int
+	for _, line := range bytes.Split(data, c.Delimiter()) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var p models.Packet
+		if err := json.Unmarshal(line, &p); err != nil {
+			bad++
+			continue
+		}
+		packets = append(packets, p)
+	}
+	if bad > 0 {
+		return packets, fmt.Errorf("skipped %d malformed packet(s) in frame", bad)
+	}
+	return packets, nil
+}
@@ -0,0 +1,218 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"syncra/internal/models"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type msgpackCodec struct{}
+
+// wirePacket mirrors models.Packet with short keys. The payload is carried
+// as native MessagePack rather than embedded JSON text.
+type wirePacket struct {
+	Version   int                `msgpack:"v,omitempty"`
+	Type      models.MessageType `msgpack:"t"`
+	ID        string             `msgpack:"i,omitempty"`
+	From      string             `msgpack:"f,omitempty"`
+	To        string             `msgpack:"r,omitempty"`
+	Payload   msgpack.RawMessage `msgpack:"p,omitempty"`
+	Timestamp time.Time          `msgpack:"ts"`
+	Signature string             `msgpack:"s,omitempty"`
+}
+
+func (msgpackCodec) Name() string      { return SubprotocolMsgPack }
+func (msgpackCodec) Binary() bool      { return true }
+func (msgpackCodec) Delimiter() []byte { return nil }
+
+func (msgpackCodec) Marshal(p models.Packet) ([]byte, error) {
+	w := wirePacket{
+		Version:   p.Version,
+		Type:      p.Type,
+		ID:        p.ID,
+		From:      p.From,
+		To:        p.To,
+		Timestamp: p.Timestamp,
+		Signature: p.Signature,
+	}
+	if len(p.Payload) > 0 {
+		var err error
+		if w.Payload, err = encodePayload(p.Type, p.Payload); err != nil {
+			return nil, err
+		}
+	}
+	return msgpack.Marshal(&w)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, p *models.Packet) error {
+	var w wirePacket
+	if err := msgpack.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	return w.toPacket(p)
+}
+
+// UnmarshalFrame reads concatenated values until the frame is exhausted. A
+// corrupt value leaves the decoder unable to find the next boundary, so the
+// rest of the frame is dropped.
+func (msgpackCodec) UnmarshalFrame(data []byte) ([]models.Packet, error) {
+	var packets []models.Packet
+	var bad int
+	r := bytes.NewReader(data)
+	dec := msgpack.NewDecoder(r)
+	// A value cut short reads as io.EOF too, so the end is where no bytes
+	// are left, not where Decode says
+	for r.Len() > 0 {
+		var w wirePacket
+		if err := dec.Decode(&w); err != nil {
+			return packets, fmt.Errorf("frame truncated after %d packet(s): %v", len(packets)+bad, err)
+		}
+		var p models.Packet
+		if err := w.toPacket(&p); err != nil {
+			bad++
+			continue
+		}
+		packets = append(packets, p)
+	}
+	if bad > 0 {
+		return packets, fmt.Errorf("skipped %d malformed packet(s) in frame", bad)
+	}
+	return packets, nil
+}
+
+func (w *wirePacket) toPacket(p *models.Packet) error {
+	*p = models.Packet{
+		Version:   w.Version,
+		Type:      w.Type,
+		ID:        w.ID,
+		From:      w.From,
+		To:        w.To,
+		Timestamp: w.Timestamp,
+		Signature: w.Signature,
+	}
+	if len(w.Payload) > 0 {
+		payload, err := decodePayload(w.Type, w.Payload)
+		if err != nil {
+			return err
+		}
+		p.Payload = payload
+	}
+	return nil
+}
+
+// encodePayload turns a JSON payload into MessagePack through the struct
+// its type carries, so integers keep their exact type and ciphertext goes
+// out as bytes. Types this build does not know, and payloads that do not
+// fit their type exactly (null, or fields from a newer version), are
+// converted generically so nothing is lost on the way.
+func encodePayload(t models.MessageType, raw []byte) ([]byte, error) {
+	if newPayload, ok := payloads[t]; ok && !bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		v := newPayload()
+		if strictJSON(raw, v) == nil {
+			return marshalTyped(v)
+		}
+	}
+	v, err := decodeJSON(raw)
+	if err != nil {
+		return nil, fmt.Errorf("payload is not valid JSON: %v", err)
+	}
+	return msgpack.Marshal(v)
+}
+
+// decodePayload is the inverse of encodePayload.
+func decodePayload(t models.MessageType, data []byte) ([]byte, error) {
+	if newPayload, ok := payloads[t]; ok {
+		v := newPayload()
+		dec := msgpack.NewDecoder(bytes.NewReader(data))
+		dec.SetCustomStructTag("json")
+		// The decoder is lenient, dropping unknown fields and wrapping
+		// negative numbers into unsigned ones; only a value that encodes
+		// back to the same bytes went out typed
+		if dec.Decode(v) == nil {
+			if again, err := marshalTyped(v); err == nil && bytes.Equal(again, data) {
+				return json.Marshal(v)
+			}
+		}
+	}
+	var v any
+	if err := msgpack.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonSafe(v))
+}
+
+// marshalTyped encodes a payload struct. Map keys are sorted so the same
+// value always gives the same bytes.
+func marshalTyped(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetSortMapKeys(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeJSON parses a payload that is converted generically, keeping
+// integers exact so they survive the trip through MessagePack without
+// turning into floats.
+func decodeJSON(raw []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return numbers(v), nil
+}
+
+func numbers(v any) any {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(t.String(), 10, 64); err == nil {
+			return u
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]any:
+		for k, e := range t {
+			t[k] = numbers(e)
+		}
+	case []any:
+		for i, e := range t {
+			t[i] = numbers(e)
+		}
+	}
+	return v
+}
+
+// jsonSafe converts decoded MessagePack values that encoding/json cannot
+// handle (maps with non-string keys) into JSON-compatible ones.
+func jsonSafe(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, e := range t {
+			t[k] = jsonSafe(e)
+		}
+	case map[any]any:
+		m := make(map[string]any, len(t))
+		for k, e := range t {
+			m[fmt.Sprint(k)] = jsonSafe(e)
+		}
+		return m
+	case []any:
+		for i, e := range t {
+			t[i] = jsonSafe(e)
+		}
+	}
+	return v
+}
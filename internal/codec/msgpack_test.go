@@ -0,0 +1,113 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"syncra/internal/models"
+	"syncra/internal/protocol"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// sameJSON reports whether a and b hold the same value, numbers compared
+// exactly.
+func sameJSON(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	parse := func(data []byte) any {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		return v
+	}
+	return reflect.DeepEqual(parse(a), parse(b))
+}
+
+const (
+	testGroup = "0b5f0c2e-8a4f-4c1e-9d3b-2f6a7c8d9e10"
+	testID    = "00112233445566778899aabbccddeeff"
+)
+
+func TestMsgPackPayloads(t *testing.T) {
+	must := func(p models.Packet, err error) models.Packet {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	raw := func(typ models.MessageType, payload string) models.Packet {
+		return models.Packet{Type: typ, Timestamp: time.Now(), Payload: json.RawMessage(payload)}
+	}
+	cipher := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0xfe, 0x01, 0x80}, 20))
+	group := models.Group{ID: testGroup, Name: "Team", Owner: "alice", Members: []string{"alice"}, Epoch: 1<<64 - 1, CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)}
+
+	cases := map[string]models.Packet{
+		"ciphertext":       must(protocol.Chat("alice", "bob", models.ChatPayload{Message: cipher, Suite: models.SuiteGroup, Expires: 30})),
+		"plain text":       must(protocol.Chat("alice", "bob", models.ChatPayload{Message: "hello"})),
+		"unpadded base64":  must(protocol.Chat("alice", "bob", models.ChatPayload{Message: "aGk"})),
+		"edit":             must(protocol.Edit("alice", testGroup, true, testID, models.ChatPayload{Message: cipher, ReplyTo: testID})),
+		"group chat":       must(protocol.GroupChat("alice", testGroup, models.ChatPayload{Message: cipher})),
+		"reaction":         must(protocol.Reaction("alice", "bob", testID, "👍", true)),
+		"group reaction":   must(protocol.GroupReaction("alice", testGroup, testID, models.ChatPayload{Message: cipher, Suite: models.SuiteGroup}, false)),
+		"epoch above 2^63": must(protocol.GroupSync(testGroup, 1<<63+1, true)),
+		"group info":       protocol.GroupInfo(group),
+		"commit": must(protocol.GroupCommit("alice", models.HandshakePayload{
+			Group: testGroup, Epoch: 1<<63 + 5, Data: json.RawMessage(`{"path":"AAEC"}`),
+			Welcomes: map[string]json.RawMessage{"bob": json.RawMessage(`{"secret":"AwQ="}`)},
+		})),
+		"system":         protocol.System("relay restarting"),
+		"newer field":    raw(models.TypeAck, `{"id":"x","status":"delivered","retry_in":18446744073709551615}`),
+		"newer chat":     raw(models.TypeChat, `{"message":"`+cipher+`","sealed_sender":true}`),
+		"unknown type":   raw("presence", `{"online":["bob"],"since":9007199254740993}`),
+		"wrong shape":    raw(models.TypeGroupSync, `{"group":"g","epoch":-1}`),
+		"string payload": raw(models.TypeAck, `"odd"`),
+	}
+	for name, p := range cases {
+		t.Run(name, func(t *testing.T) {
+			data, err := MsgPack.Marshal(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got models.Packet
+			if err := MsgPack.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !sameJSON(t, got.Payload, p.Payload) {
+				t.Fatalf("payload changed:\n got %s\nwant %s", got.Payload, p.Payload)
+			}
+		})
+	}
+}
+
+func TestMsgPackCiphertextIsBinary(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x00, 0xff}, 32)
+	p, err := protocol.Chat("alice", "bob", models.ChatPayload{Message: base64.StdEncoding.EncodeToString(secret)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MsgPack.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w wirePacket
+	if err := msgpack.Unmarshal(data, &w); err != nil {
+		t.Fatal(err)
+	}
+	var payload map[string]any
+	if err := msgpack.Unmarshal(w.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := payload["cipher"].([]byte); !ok || !bytes.Equal(got, secret) {
+		t.Fatalf("ciphertext sent as %T %v", payload["cipher"], payload["cipher"])
+	}
+	if _, ok := payload["message"]; ok {
+		t.Fatal("ciphertext also sent as text")
+	}
+}
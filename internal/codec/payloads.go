@@ -0,0 +1,136 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"syncra/internal/models"
+)
+
+// payloads gives the struct each packet type carries on the MessagePack
+// wire. Fields without a msgpack tag are keyed by their JSON name.
+var payloads = map[models.MessageType]func() any{
+	models.TypeHello:        func() any { return new(models.HelloPayload) },
+	models.TypeChallenge:    func() any { return new(string) },
+	models.TypeAuth:         func() any { return new(models.AuthPayload) },
+	models.TypeChat:         func() any { return new(wireChat) },
+	models.TypeEdit:         func() any { return new(wireEdit) },
+	models.TypeDelete:       func() any { return new(models.DeletePayload) },
+	models.TypeReaction:     func() any { return new(wireReaction) },
+	models.TypeExpiry:       func() any { return new(models.ExpiryPayload) },
+	models.TypeSystem:       func() any { return new(string) },
+	models.TypeError:        func() any { return new(string) },
+	models.TypeAck:          func() any { return new(models.AckPayload) },
+	models.TypeGroupCreate:  func() any { return new(models.GroupCreatePayload) },
+	models.TypeGroupInvite:  func() any { return new(models.GroupMemberPayload) },
+	models.TypeGroupRemove:  func() any { return new(models.GroupMemberPayload) },
+	models.TypeGroupChat:    func() any { return new(wireChat) },
+	models.TypeGroupInfo:    func() any { return new(models.Group) },
+	models.TypeGroupCommit:  func() any { return new(models.HandshakePayload) },
+	models.TypeGroupWelcome: func() any { return new(models.HandshakePayload) },
+	models.TypeGroupSync:    func() any { return new(models.GroupSyncPayload) },
+	models.TypeBlock:        func() any { return new(models.BlockPayload) },
+	models.TypeBlockList:    func() any { return new(models.BlockListPayload) },
+}
+
+// wireChat is models.ChatPayload with a base64 message sent as the bytes
+// it encodes. Any other text, plain or not canonically encoded, is sent
+// as is so it comes back unchanged.
+type wireChat struct {
+	Message   string `msgpack:"message,omitempty"`
+	Cipher    []byte `msgpack:"cipher,omitempty"`
+	Ephemeral string `msgpack:"ephemeral,omitempty"`
+	Suite     string `msgpack:"suite,omitempty"`
+	ReplyTo   string `msgpack:"reply_to,omitempty"`
+	Expires   int64  `msgpack:"expires,omitempty"`
+}
+
+func chatToWire(c models.ChatPayload) wireChat {
+	w := wireChat{Message: c.Message, Ephemeral: c.Ephemeral, Suite: c.Suite, ReplyTo: c.ReplyTo, Expires: c.Expires}
+	if b, err := base64.StdEncoding.DecodeString(c.Message); err == nil && len(b) > 0 &&
+		base64.StdEncoding.EncodeToString(b) == c.Message {
+		w.Message, w.Cipher = "", b
+	}
+	return w
+}
+
+func (w wireChat) chat() models.ChatPayload {
+	c := models.ChatPayload{Message: w.Message, Ephemeral: w.Ephemeral, Suite: w.Suite, ReplyTo: w.ReplyTo, Expires: w.Expires}
+	if len(w.Cipher) > 0 {
+		c.Message = base64.StdEncoding.EncodeToString(w.Cipher)
+	}
+	return c
+}
+
+func (w *wireChat) UnmarshalJSON(data []byte) error {
+	var c models.ChatPayload
+	if err := strictJSON(data, &c); err != nil {
+		return err
+	}
+	*w = chatToWire(c)
+	return nil
+}
+
+func (w wireChat) MarshalJSON() ([]byte, error) {
+	return json.Marshal(w.chat())
+}
+
+// wireEdit is models.EditPayload carrying its text as a wireChat
+type wireEdit struct {
+	Target string   `msgpack:"target"`
+	Group  bool     `msgpack:"group,omitempty"`
+	Chat   wireChat `msgpack:",inline"`
+}
+
+func (w *wireEdit) UnmarshalJSON(data []byte) error {
+	var e models.EditPayload
+	if err := strictJSON(data, &e); err != nil {
+		return err
+	}
+	*w = wireEdit{Target: e.Target, Group: e.Group, Chat: chatToWire(e.ChatPayload)}
+	return nil
+}
+
+func (w wireEdit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(models.EditPayload{Target: w.Target, Group: w.Group, ChatPayload: w.Chat.chat()})
+}
+
+// wireReaction is models.ReactionPayload carrying a sealed emoji as a
+// wireChat
+type wireReaction struct {
+	Target  string    `msgpack:"target"`
+	Group   bool      `msgpack:"group,omitempty"`
+	Emoji   string    `msgpack:"emoji,omitempty"`
+	Sealed  *wireChat `msgpack:"sealed,omitempty"`
+	Removed bool      `msgpack:"removed,omitempty"`
+}
+
+func (w *wireReaction) UnmarshalJSON(data []byte) error {
+	var r models.ReactionPayload
+	if err := strictJSON(data, &r); err != nil {
+		return err
+	}
+	*w = wireReaction{Target: r.Target, Group: r.Group, Emoji: r.Emoji, Removed: r.Removed}
+	if r.Sealed != nil {
+		sealed := chatToWire(*r.Sealed)
+		w.Sealed = &sealed
+	}
+	return nil
+}
+
+func (w wireReaction) MarshalJSON() ([]byte, error) {
+	r := models.ReactionPayload{Target: w.Target, Group: w.Group, Emoji: w.Emoji, Removed: w.Removed}
+	if w.Sealed != nil {
+		sealed := w.Sealed.chat()
+		r.Sealed = &sealed
+	}
+	return json.Marshal(r)
+}
+
+// strictJSON decodes data into v, refusing fields v does not have, which
+// the generic conversion would otherwise keep.
+func strictJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
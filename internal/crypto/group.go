@@ -0,0 +1,656 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// This is an MLS-style (RFC 9420) group key agreement, cut down to what a
+// relay-ordered chat needs:
+//
+//   - TreeKEM over a ratchet tree: a Commit re-keys the committer's direct
+//     path and encrypts each new path secret to the copath, so a change
+//     costs O(log n) encryptions instead of one per member.
+//   - Proposals (add, remove) only travel inside a Commit. A Commit with no
+//     proposals is an update: it just rotates the committer's keys.
+//   - New members get a Welcome sealed to an X25519 key derived from their
+//     Ed25519 identity, so no key package directory is needed. Welcomes
+//     are therefore only as forward-secret as the identity key.
+//   - Adds blank the new leaf's direct path instead of tracking unmerged
+//     leaves.
+//   - Application keys are derived per sender and generation rather than
+//     ratcheted, so redelivered messages still decrypt. Forward secrecy
+//     comes from epoch changes.
+//   - Application messages are signed with the sender's identity key. The
+//     group key only shows a message came from some member; the signature
+//     stops one member posing as another.
+
+// Proposal kinds
+const (
+	ProposalAdd    = "add"
+	ProposalRemove = "remove"
+)
+
+// ErrRemoved is returned by Apply for a commit that removes us.
+var ErrRemoved = errors.New("removed from group")
+
+// ErrRestored is returned by Encrypt while the state is marked restored.
+var ErrRestored = errors.New("group keys restored from a backup; waiting for our own commit")
+
+// GroupState is one member's view of an encrypted group at an epoch.
+type GroupState struct {
+	groupID          string
+	epoch            uint64
+	self             uint32 // Our leaf index
+	tree             ratchetTree
+	initSecret       []byte // Feeds the next epoch's key schedule
+	encryptionSecret []byte
+	generation       uint32 // Next application message generation
+	previous         *pastEpoch
+	restored         bool // Until our next commit; see MarkRestored
+}
+
+// pastEpoch keeps the last epoch's keys for messages sent just before a
+// commit landed.
+type pastEpoch struct {
+	Epoch            uint64            `json:"epoch"`
+	EncryptionSecret []byte            `json:"encryption_secret"`
+	Members          map[uint32]string `json:"members"`
+	Keys             map[uint32][]byte `json:"keys,omitempty"` // Signing keys by leaf
+}
+
+// Proposal is a membership change carried in a Commit.
+type Proposal struct {
+	Kind   string      `json:"kind"`
+	Member *Credential `json:"member,omitempty"` // ProposalAdd
+	Leaf   uint32      `json:"leaf,omitempty"`   // ProposalRemove
+}
+
+// PathSecret is a path secret sealed to one node of the copath resolution.
+type PathSecret struct {
+	Node       uint32 `json:"node"`
+	Enc        []byte `json:"enc"`
+	Ciphertext []byte `json:"ct"`
+}
+
+// PathNode is the committer's new public key for one direct-path node.
+type PathNode struct {
+	Public  []byte       `json:"public"`
+	Secrets []PathSecret `json:"secrets"`
+}
+
+// Commit moves the group from Epoch to Epoch+1.
+type Commit struct {
+	GroupID      string     `json:"group_id"`
+	Epoch        uint64     `json:"epoch"`
+	Committer    uint32     `json:"committer"`
+	Proposals    []Proposal `json:"proposals,omitempty"`
+	LeafKey      []byte     `json:"leaf_key"`
+	Path         []PathNode `json:"path"`
+	Confirmation []byte     `json:"confirmation"` // MAC proving the new epoch's keys
+	Signature    []byte     `json:"signature"`    // By the committer's identity
+}
+
+// Welcome lets a member added by a commit join at the new epoch.
+type Welcome struct {
+	GroupID    string `json:"group_id"`
+	Epoch      uint64 `json:"epoch"`
+	Committer  uint32 `json:"committer"`
+	Leaf       uint32 `json:"leaf"`
+	Tree       []Node `json:"tree"`
+	Enc        []byte `json:"enc"`
+	Ciphertext []byte `json:"ct"`
+	Signature  []byte `json:"signature"`
+}
+
+// groupSecrets is what a Welcome seals to the new member.
+type groupSecrets struct {
+	EpochSecret []byte `json:"epoch_secret"`
+	PathSecret  []byte `json:"path_secret,omitempty"`
+	PathNode    uint32 `json:"path_node"`
+}
+
+// GroupMessage is an encrypted application message.
+type GroupMessage struct {
+	Epoch      uint64 `json:"epoch"`
+	Sender     uint32 `json:"sender"`
+	Generation uint32 `json:"gen"`
+	Ciphertext []byte `json:"ct"`
+	Signature  []byte `json:"sig"` // By the sender's identity
+}
+
+// NewGroup starts a group at epoch 0 with us as its only member.
+func NewGroup(groupID, username string, identity ed25519.PrivateKey) (*GroupState, error) {
+	leaf, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	cred := Credential{Username: username, SigningKey: identity.Public().(ed25519.PublicKey)}
+	g := &GroupState{
+		groupID: groupID,
+		tree:    ratchetTree{{Public: leaf.PublicKey().Bytes(), Private: leaf.Bytes(), Credential: &cred}},
+	}
+	g.setEpochSecret(randomSecret())
+	return g, nil
+}
+
+// JoinGroup builds our state from a Welcome addressed to us.
+func JoinGroup(w *Welcome, identity ed25519.PrivateKey) (*GroupState, error) {
+	tree := ratchetTree(w.Tree).clone()
+	if len(tree) == 0 || tree.leaves()&(tree.leaves()-1) != 0 || len(tree) != int(2*tree.leaves()-1) {
+		return nil, fmt.Errorf("welcome: malformed tree")
+	}
+	signer, ok := tree.signingKey(w.Committer)
+	if !ok || !ed25519.Verify(signer, w.signedContent(), w.Signature) {
+		return nil, fmt.Errorf("welcome: bad signature")
+	}
+
+	cred, ok := tree.credential(w.Leaf)
+	if !ok || !bytes.Equal(cred.SigningKey, identity.Public().(ed25519.PublicKey)) {
+		return nil, fmt.Errorf("welcome: not addressed to this identity")
+	}
+	leafKey, err := X25519FromEd25519(identity)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(tree[2*w.Leaf].Public, leafKey.PublicKey().Bytes()) {
+		return nil, fmt.Errorf("welcome: leaf key does not match identity")
+	}
+	tree[2*w.Leaf].Private = leafKey.Bytes()
+
+	plain, err := open(leafKey.Bytes(), w.Enc, welcomeInfo(w.GroupID, w.Epoch), w.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("welcome: %v", err)
+	}
+	var secrets groupSecrets
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, fmt.Errorf("welcome: malformed secrets")
+	}
+
+	// Recover the private keys from where our path meets the committer's
+	if secrets.PathSecret != nil {
+		if int(secrets.PathNode) >= len(tree) || !contains(secrets.PathNode, 2*w.Leaf) {
+			return nil, fmt.Errorf("welcome: path secret is not on our path")
+		}
+		path := append([]uint32{secrets.PathNode}, tree.directPath(secrets.PathNode)...)
+		if _, err := tree.installPath(path, secrets.PathSecret, nil); err != nil {
+			return nil, fmt.Errorf("welcome: %v", err)
+		}
+	}
+
+	g := &GroupState{groupID: w.GroupID, epoch: w.Epoch, self: w.Leaf, tree: tree}
+	g.setEpochSecret(secrets.EpochSecret)
+	return g, nil
+}
+
+// GroupID is the relay's identifier for the group.
+func (g *GroupState) GroupID() string { return g.groupID }
+
+// MarkRestored flags a state brought back from a backup. The generations
+// it holds may already have been used since, and so may those of any
+// epoch it catches up to, so it refuses to encrypt until a commit of our
+// own has taken the group to an epoch no copy of it has seen.
+func (g *GroupState) MarkRestored() { g.restored = true }
+
+// Restored reports whether the state still waits for our own commit.
+func (g *GroupState) Restored() bool { return g.restored }
+
+// Epoch is the current epoch; it advances with every commit.
+func (g *GroupState) Epoch() uint64 { return g.epoch }
+
+// Members lists the usernames in the tree, in leaf order.
+func (g *GroupState) Members() []string {
+	var members []string
+	for i := uint32(0); i < g.tree.leaves(); i++ {
+		if c, ok := g.tree.credential(i); ok {
+			members = append(members, c.Username)
+		}
+	}
+	return members
+}
+
+// Commit builds a commit adding and removing members, and rotating our
+// own path. It returns the commit, a Welcome per added username, and the
+// state to adopt once the relay has accepted the commit.
+func (g *GroupState) Commit(identity ed25519.PrivateKey, adds []Credential, removes []string) (*Commit, map[string]*Welcome, *GroupState, error) {
+	next := g.clone()
+	next.restored = false
+	c := &Commit{GroupID: g.groupID, Epoch: g.epoch, Committer: g.self}
+
+	for _, username := range removes {
+		leaf, ok := next.tree.findMember(username)
+		if !ok {
+			continue
+		}
+		if leaf == g.self {
+			return nil, nil, nil, fmt.Errorf("cannot remove ourselves in a commit")
+		}
+		c.Proposals = append(c.Proposals, Proposal{Kind: ProposalRemove, Leaf: leaf})
+	}
+	for i := range adds {
+		if _, ok := next.tree.findMember(adds[i].Username); ok {
+			continue
+		}
+		c.Proposals = append(c.Proposals, Proposal{Kind: ProposalAdd, Member: &adds[i]})
+	}
+	joiners, err := next.tree.applyProposals(c.Proposals)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// New leaf key, then a fresh secret for each node up to the root
+	leaf, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	self := 2 * g.self
+	next.tree[self].Public = leaf.PublicKey().Bytes()
+	next.tree[self].Private = leaf.Bytes()
+	c.LeafKey = next.tree[self].Public
+
+	exclude := make(map[uint32]bool)
+	for _, j := range joiners {
+		exclude[2*j] = true
+	}
+	dp, cp := next.tree.directPath(self), next.tree.copath(self)
+	pathSecrets := make(map[uint32][]byte)
+	pathSecret := randomSecret()
+	info := pathInfo(g.groupID, g.epoch)
+	for i, x := range dp {
+		pathSecrets[x] = pathSecret
+		priv, pub, err := deriveKeyPair(deriveSecret(pathSecret, "node"))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		next.tree[x] = Node{Public: pub, Private: priv}
+		node := PathNode{Public: pub}
+		for _, r := range next.tree.resolution(cp[i], exclude) {
+			enc, ct, err := seal(next.tree[r].Public, info, pathSecret)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			node.Secrets = append(node.Secrets, PathSecret{Node: r, Enc: enc, Ciphertext: ct})
+		}
+		c.Path = append(c.Path, node)
+		pathSecret = deriveSecret(pathSecret, "path")
+	}
+
+	epochSecret := next.advance(pathSecret, g)
+	confirmKey := next.setEpochSecret(epochSecret)
+	c.Confirmation = confirm(confirmKey, c)
+	c.Signature = ed25519.Sign(identity, c.signedContent())
+
+	welcomes := make(map[string]*Welcome)
+	for _, j := range joiners {
+		secrets := groupSecrets{EpochSecret: epochSecret}
+		for _, x := range dp {
+			if contains(x, 2*j) {
+				secrets.PathSecret, secrets.PathNode = pathSecrets[x], x
+				break
+			}
+		}
+		plain, _ := json.Marshal(secrets)
+		w := &Welcome{GroupID: g.groupID, Epoch: next.epoch, Committer: g.self, Leaf: j, Tree: next.tree.public()}
+		if w.Enc, w.Ciphertext, err = seal(next.tree[2*j].Public, welcomeInfo(g.groupID, next.epoch), plain); err != nil {
+			return nil, nil, nil, err
+		}
+		w.Signature = ed25519.Sign(identity, w.signedContent())
+		welcomes[next.tree[2*j].Credential.Username] = w
+	}
+	return c, welcomes, next, nil
+}
+
+// Apply processes another member's commit and returns the state for the
+// next epoch. It returns ErrRemoved if the commit removes us.
+func (g *GroupState) Apply(c *Commit) (*GroupState, error) {
+	if c.GroupID != g.groupID {
+		return nil, fmt.Errorf("commit for another group")
+	}
+	if c.Epoch != g.epoch {
+		return nil, fmt.Errorf("commit for epoch %d, we are at %d", c.Epoch, g.epoch)
+	}
+	if c.Committer == g.self {
+		return nil, fmt.Errorf("commit claims to be ours")
+	}
+	signer, ok := g.tree.signingKey(c.Committer)
+	if !ok || !ed25519.Verify(signer, c.signedContent(), c.Signature) {
+		return nil, fmt.Errorf("commit: bad signature")
+	}
+
+	next := g.clone()
+	if _, err := next.tree.applyProposals(c.Proposals); err != nil {
+		return nil, err
+	}
+	// An add may reuse our leaf once we are removed
+	for _, p := range c.Proposals {
+		if p.Kind == ProposalRemove && p.Leaf == g.self {
+			return nil, ErrRemoved
+		}
+	}
+
+	committer := 2 * c.Committer
+	dp := next.tree.directPath(committer)
+	if len(c.Path) != len(dp) || len(c.LeafKey) == 0 {
+		return nil, fmt.Errorf("commit: path does not match tree")
+	}
+	next.tree[committer].Public = c.LeafKey
+	next.tree[committer].Private = nil
+	publics := make([][]byte, len(dp))
+	for i, x := range dp {
+		publics[i] = c.Path[i].Public
+		next.tree[x] = Node{Public: c.Path[i].Public}
+	}
+
+	// The lowest node on the committer's path above us carries a secret
+	// sealed to a node we hold
+	var commitSecret []byte
+	var err error
+	info := pathInfo(g.groupID, g.epoch)
+	for i, x := range dp {
+		if !contains(x, 2*g.self) {
+			continue
+		}
+		var pathSecret []byte
+		for _, s := range c.Path[i].Secrets {
+			if int(s.Node) < len(next.tree) && next.tree[s.Node].Private != nil {
+				if pathSecret, err = open(next.tree[s.Node].Private, s.Enc, info, s.Ciphertext); err != nil {
+					return nil, fmt.Errorf("commit: %v", err)
+				}
+				break
+			}
+		}
+		if pathSecret == nil {
+			return nil, fmt.Errorf("commit: no path secret for us")
+		}
+		if commitSecret, err = next.tree.installPath(dp[i:], pathSecret, publics[i:]); err != nil {
+			return nil, fmt.Errorf("commit: %v", err)
+		}
+		break
+	}
+	if commitSecret == nil {
+		return nil, fmt.Errorf("commit: not on our path")
+	}
+	confirmKey := next.setEpochSecret(next.advance(commitSecret, g))
+	if !hmac.Equal(confirm(confirmKey, c), c.Confirmation) {
+		return nil, fmt.Errorf("commit: confirmation mismatch")
+	}
+	return next, nil
+}
+
+// Encrypt seals an application message for the current epoch and signs
+// it with identity, which must be the one in our leaf.
+func (g *GroupState) Encrypt(plaintext []byte, identity ed25519.PrivateKey) (*GroupMessage, error) {
+	if key, ok := g.tree.signingKey(g.self); !ok || !key.Equal(identity.Public()) {
+		return nil, fmt.Errorf("identity does not match our leaf")
+	}
+	if g.restored {
+		return nil, ErrRestored
+	}
+	m := &GroupMessage{Epoch: g.epoch, Sender: g.self, Generation: g.generation}
+	g.generation++
+	aead, nonce, err := messageKey(g.encryptionSecret, m)
+	if err != nil {
+		return nil, err
+	}
+	m.Ciphertext = aead.Seal(nil, nonce, plaintext, messageAAD(g.groupID, m))
+	m.Signature = ed25519.Sign(identity, m.signedContent(g.groupID))
+	return m, nil
+}
+
+// Decrypt checks the signature on an application message from the
+// current or previous epoch, opens it and returns it with the sender's
+// username.
+func (g *GroupState) Decrypt(m *GroupMessage) ([]byte, string, error) {
+	var secret []byte
+	var sender string
+	var key ed25519.PublicKey
+	switch {
+	case m.Epoch == g.epoch:
+		c, ok := g.tree.credential(m.Sender)
+		if !ok {
+			return nil, "", fmt.Errorf("unknown sender %d", m.Sender)
+		}
+		secret, sender, key = g.encryptionSecret, c.Username, c.SigningKey
+	case g.previous != nil && m.Epoch == g.previous.Epoch:
+		secret, sender, key = g.previous.EncryptionSecret, g.previous.Members[m.Sender], g.previous.Keys[m.Sender]
+		if sender == "" {
+			return nil, "", fmt.Errorf("unknown sender %d", m.Sender)
+		}
+	default:
+		return nil, "", fmt.Errorf("message from epoch %d, we are at %d", m.Epoch, g.epoch)
+	}
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, m.signedContent(g.groupID), m.Signature) {
+		return nil, "", fmt.Errorf("bad signature from %s", sender)
+	}
+
+	aead, nonce, err := messageKey(secret, m)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext, err := aead.Open(nil, nonce, m.Ciphertext, messageAAD(g.groupID, m))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decrypt: %v", err)
+	}
+	return plaintext, sender, nil
+}
+
+// applyProposals applies removes before adds, as every member must, and
+// returns the leaves the adds landed in.
+func (t *ratchetTree) applyProposals(proposals []Proposal) ([]uint32, error) {
+	for _, p := range proposals {
+		if p.Kind != ProposalRemove {
+			continue
+		}
+		if _, ok := t.credential(p.Leaf); !ok {
+			return nil, fmt.Errorf("remove of empty leaf %d", p.Leaf)
+		}
+		t.removeLeaf(p.Leaf)
+	}
+	var joiners []uint32
+	for _, p := range proposals {
+		switch p.Kind {
+		case ProposalRemove:
+		case ProposalAdd:
+			if p.Member == nil {
+				return nil, fmt.Errorf("add without a member")
+			}
+			pub, err := X25519PublicFromEd25519(p.Member.SigningKey)
+			if err != nil {
+				return nil, fmt.Errorf("add %s: %v", p.Member.Username, err)
+			}
+			joiners = append(joiners, t.addLeaf(*p.Member, pub.Bytes()))
+		default:
+			return nil, fmt.Errorf("unknown proposal %q", p.Kind)
+		}
+	}
+	return joiners, nil
+}
+
+// installPath derives node keys along path from the secret of its first
+// node, checking them against expected public keys when given. It returns
+// the commit secret left after the last node.
+func (t ratchetTree) installPath(path []uint32, pathSecret []byte, expected [][]byte) ([]byte, error) {
+	for i, x := range path {
+		priv, pub, err := deriveKeyPair(deriveSecret(pathSecret, "node"))
+		if err != nil {
+			return nil, err
+		}
+		want := t[x].Public
+		if expected != nil {
+			want = expected[i]
+		}
+		if !bytes.Equal(pub, want) {
+			return nil, fmt.Errorf("derived key for node %d does not match", x)
+		}
+		t[x].Private = priv
+		pathSecret = deriveSecret(pathSecret, "path")
+	}
+	return pathSecret, nil
+}
+
+// advance moves g to the epoch after prev and returns its epoch secret,
+// mixing the commit secret with prev's init secret and the new tree.
+func (g *GroupState) advance(commitSecret []byte, prev *GroupState) []byte {
+	members := make(map[uint32]string)
+	keys := make(map[uint32][]byte)
+	for i := uint32(0); i < prev.tree.leaves(); i++ {
+		if c, ok := prev.tree.credential(i); ok {
+			members[i] = c.Username
+			keys[i] = c.SigningKey
+		}
+	}
+	g.previous = &pastEpoch{Epoch: prev.epoch, EncryptionSecret: prev.encryptionSecret, Members: members, Keys: keys}
+	g.epoch = prev.epoch + 1
+	g.generation = 0
+
+	prk, err := hkdf.Extract(sha256.New, commitSecret, prev.initSecret)
+	if err != nil {
+		panic(err)
+	}
+	context := fmt.Sprintf("epoch %s %d %s", g.groupID, g.epoch, hex.EncodeToString(g.tree.hash()))
+	return deriveSecret(prk, context)
+}
+
+// setEpochSecret derives the epoch's working secrets and returns its
+// confirmation key.
+func (g *GroupState) setEpochSecret(epochSecret []byte) []byte {
+	g.initSecret = deriveSecret(epochSecret, "init")
+	g.encryptionSecret = deriveSecret(epochSecret, "encryption")
+	return deriveSecret(epochSecret, "confirm")
+}
+
+func (g *GroupState) clone() *GroupState {
+	next := *g
+	next.tree = g.tree.clone()
+	return &next
+}
+
+func (c *Commit) signedContent() []byte {
+	tbs := *c
+	tbs.Signature = nil
+	data, _ := json.Marshal(tbs)
+	return data
+}
+
+func (w *Welcome) signedContent() []byte {
+	tbs := *w
+	tbs.Signature = nil
+	data, _ := json.Marshal(tbs)
+	return data
+}
+
+// confirm MACs the commit content under the new epoch's confirmation key.
+func confirm(key []byte, c *Commit) []byte {
+	tbs := *c
+	tbs.Confirmation, tbs.Signature = nil, nil
+	data, _ := json.Marshal(tbs)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// messageKey derives the key and nonce for one sender and generation.
+func messageKey(secret []byte, m *GroupMessage) (cipher.AEAD, []byte, error) {
+	material, err := hkdf.Expand(sha256.New, secret, fmt.Sprintf("syncra message %d %d", m.Sender, m.Generation), 32+12)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := newGCM(material[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+	return gcm, material[32:], nil
+}
+
+func messageAAD(groupID string, m *GroupMessage) []byte {
+	return []byte(fmt.Sprintf("%s %d %d %d", groupID, m.Epoch, m.Sender, m.Generation))
+}
+
+// signedContent is what the sender signs: the message's place in the
+// group and its ciphertext.
+func (m *GroupMessage) signedContent(groupID string) []byte {
+	return append([]byte("message "+string(messageAAD(groupID, m))+" "), m.Ciphertext...)
+}
+
+func pathInfo(groupID string, epoch uint64) string {
+	return fmt.Sprintf("path %s %d", groupID, epoch)
+}
+
+func welcomeInfo(groupID string, epoch uint64) string {
+	return fmt.Sprintf("welcome %s %d", groupID, epoch)
+}
+
+// groupStateFile is the on-disk form of a GroupState. It holds private
+// keys and must stay as protected as the identity key.
+type groupStateFile struct {
+	GroupID          string     `json:"group_id"`
+	Epoch            uint64     `json:"epoch"`
+	Self             uint32     `json:"self"`
+	Tree             []Node     `json:"tree"`
+	InitSecret       []byte     `json:"init_secret"`
+	EncryptionSecret []byte     `json:"encryption_secret"`
+	Generation       uint32     `json:"generation"`
+	Previous         *pastEpoch `json:"previous,omitempty"`
+	Restored         bool       `json:"restored,omitempty"`
+}
+
+// SaveGroupState writes a group's key state with secure permissions (0600).
+func SaveGroupState(path string, g *GroupState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory for group keys: %v", err)
+	}
+	data, err := json.Marshal(groupStateFile{
+		GroupID:          g.groupID,
+		Epoch:            g.epoch,
+		Self:             g.self,
+		Tree:             g.tree,
+		InitSecret:       g.initSecret,
+		EncryptionSecret: g.encryptionSecret,
+		Generation:       g.generation,
+		Previous:         g.previous,
+		Restored:         g.restored,
+	})
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadGroupState reads a group's key state saved by SaveGroupState.
+func LoadGroupState(path string) (*GroupState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f groupStateFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid group key file: %v", err)
+	}
+	return &GroupState{
+		groupID:          f.GroupID,
+		epoch:            f.Epoch,
+		self:             f.Self,
+		tree:             f.Tree,
+		initSecret:       f.InitSecret,
+		encryptionSecret: f.EncryptionSecret,
+		generation:       f.Generation,
+		previous:         f.Previous,
+		restored:         f.Restored,
+	}, nil
+}
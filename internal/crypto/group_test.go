@@ -0,0 +1,411 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+type member struct {
+	name     string
+	identity ed25519.PrivateKey
+}
+
+func newMember(t *testing.T, name string) member {
+	t.Helper()
+	_, priv, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return member{name: name, identity: priv}
+}
+
+func (m member) credential() Credential {
+	return Credential{Username: m.name, SigningKey: m.identity.Public().(ed25519.PublicKey)}
+}
+
+// newTestGroup has alice create a group and add the others, each joining
+// from their welcome. States are returned in the order of members.
+func newTestGroup(t *testing.T, members ...member) []*GroupState {
+	t.Helper()
+	owner, err := NewGroup("group-1", members[0].name, members[0].identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var adds []Credential
+	for _, m := range members[1:] {
+		adds = append(adds, m.credential())
+	}
+	_, welcomes, next, err := owner.Commit(members[0].identity, adds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := []*GroupState{next}
+	for _, m := range members[1:] {
+		w, ok := welcomes[m.name]
+		if !ok {
+			t.Fatalf("no welcome for %s", m.name)
+		}
+		st, err := JoinGroup(w, m.identity)
+		if err != nil {
+			t.Fatalf("%s cannot join: %v", m.name, err)
+		}
+		states = append(states, st)
+	}
+	return states
+}
+
+func mustEncrypt(t *testing.T, g *GroupState, m member, text string) *GroupMessage {
+	t.Helper()
+	msg, err := g.Encrypt([]byte(text), m.identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func checkDecrypt(t *testing.T, g *GroupState, msg *GroupMessage, wantText, wantSender string) {
+	t.Helper()
+	plain, sender, err := g.Decrypt(msg)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if string(plain) != wantText || sender != wantSender {
+		t.Fatalf("got %q from %s, want %q from %s", plain, sender, wantText, wantSender)
+	}
+}
+
+func TestGroupLifecycle(t *testing.T) {
+	alice, bob, carol := newMember(t, "alice"), newMember(t, "bob"), newMember(t, "carol")
+	states := newTestGroup(t, alice, bob, carol)
+	a, b, c := states[0], states[1], states[2]
+
+	for _, st := range states {
+		if st.Epoch() != 1 {
+			t.Fatalf("epoch %d after the first commit, want 1", st.Epoch())
+		}
+		if got := st.Members(); len(got) != 3 {
+			t.Fatalf("members %v", got)
+		}
+	}
+	msg := mustEncrypt(t, a, alice, "hello")
+	checkDecrypt(t, b, msg, "hello", "alice")
+	checkDecrypt(t, c, msg, "hello", "alice")
+
+	// Bob rotates his keys; everyone else applies his commit
+	commit, _, bNext, err := b.Commit(bob.identity, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, err = a.Apply(commit); err != nil {
+		t.Fatalf("alice applying bob's commit: %v", err)
+	}
+	if c, err = c.Apply(commit); err != nil {
+		t.Fatalf("carol applying bob's commit: %v", err)
+	}
+	b = bNext
+	msg = mustEncrypt(t, b, bob, "rotated")
+	checkDecrypt(t, a, msg, "rotated", "bob")
+	checkDecrypt(t, c, msg, "rotated", "bob")
+
+	// Alice removes carol
+	commit, _, aNext, err := a.Commit(alice.identity, nil, []string{"carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err = b.Apply(commit); err != nil {
+		t.Fatalf("bob applying the removal: %v", err)
+	}
+	a = aNext
+	if next, err := c.Apply(commit); !errors.Is(err, ErrRemoved) || next != nil {
+		t.Fatalf("carol applying her removal: %v", err)
+	}
+	if got := a.Members(); len(got) != 2 {
+		t.Fatalf("members after removal %v", got)
+	}
+
+	secret := mustEncrypt(t, a, alice, "without carol")
+	checkDecrypt(t, b, secret, "without carol", "alice")
+	if _, _, err := c.Decrypt(secret); err == nil {
+		t.Fatal("removed member decrypted a message from the next epoch")
+	}
+	// Nor with the keys she held, whatever epoch the message claims
+	forged := *secret
+	forged.Epoch = c.Epoch()
+	if _, _, err := c.Decrypt(&forged); err == nil {
+		t.Fatal("removed member decrypted a message under her old keys")
+	}
+}
+
+func TestGroupAddAfterRemoveReusesLeaf(t *testing.T) {
+	alice, bob, carol, dave := newMember(t, "alice"), newMember(t, "bob"), newMember(t, "carol"), newMember(t, "dave")
+	states := newTestGroup(t, alice, bob, carol)
+	a, b := states[0], states[1]
+
+	commit, welcomes, aNext, err := a.Commit(alice.identity, []Credential{dave.credential()}, []string{"carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err = b.Apply(commit); err != nil {
+		t.Fatal(err)
+	}
+	a = aNext
+	d, err := JoinGroup(welcomes["dave"], dave.identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := states[2].Apply(commit); !errors.Is(err, ErrRemoved) {
+		t.Fatalf("carol: %v", err)
+	}
+
+	msg := mustEncrypt(t, d, dave, "hi all")
+	checkDecrypt(t, a, msg, "hi all", "dave")
+	checkDecrypt(t, b, msg, "hi all", "dave")
+}
+
+func TestTamperedCommit(t *testing.T) {
+	alice, bob, carol := newMember(t, "alice"), newMember(t, "bob"), newMember(t, "carol")
+	states := newTestGroup(t, alice, bob, carol)
+	a, b := states[0], states[1]
+
+	commit, _, _, err := a.Commit(alice.identity, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := func() *Commit {
+		data := *commit
+		data.Path = append([]PathNode(nil), commit.Path...)
+		data.Proposals = append([]Proposal(nil), commit.Proposals...)
+		return &data
+	}
+	tampers := map[string]func(c *Commit){
+		"leaf key":     func(c *Commit) { c.LeafKey = flip(c.LeafKey) },
+		"path key":     func(c *Commit) { c.Path[0].Public = flip(c.Path[0].Public) },
+		"confirmation": func(c *Commit) { c.Confirmation = flip(c.Confirmation) },
+		"signature":    func(c *Commit) { c.Signature = flip(c.Signature) },
+		"committer":    func(c *Commit) { c.Committer = 2 },
+		"proposal": func(c *Commit) {
+			c.Proposals = append(c.Proposals, Proposal{Kind: ProposalRemove, Leaf: 2})
+		},
+		"epoch": func(c *Commit) { c.Epoch++ },
+	}
+	for name, tamper := range tampers {
+		c := clone()
+		tamper(c)
+		if _, err := b.Apply(c); err == nil {
+			t.Errorf("%s: tampered commit applied", name)
+		}
+	}
+
+	// Re-signed by another member, who cannot produce the committer's
+	// signature
+	forged := clone()
+	forged.Signature = ed25519.Sign(bob.identity, forged.signedContent())
+	if _, err := states[2].Apply(forged); err == nil {
+		t.Error("commit signed by the wrong member applied")
+	}
+
+	if _, err := b.Apply(commit); err != nil {
+		t.Fatalf("untouched commit: %v", err)
+	}
+}
+
+func TestTamperedWelcome(t *testing.T) {
+	alice, bob, carol := newMember(t, "alice"), newMember(t, "bob"), newMember(t, "carol")
+	owner, err := NewGroup("group-1", "alice", alice.identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, welcomes, _, err := owner.Commit(alice.identity, []Credential{bob.credential(), carol.credential()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := welcomes["bob"]
+	clone := func() *Welcome {
+		data := *w
+		data.Tree = append([]Node(nil), w.Tree...)
+		return &data
+	}
+	tampers := map[string]func(w *Welcome){
+		"ciphertext": func(w *Welcome) { w.Ciphertext = flip(w.Ciphertext) },
+		"enc":        func(w *Welcome) { w.Enc = flip(w.Enc) },
+		"epoch":      func(w *Welcome) { w.Epoch++ },
+		"leaf":       func(w *Welcome) { w.Leaf = 2 },
+		"signature":  func(w *Welcome) { w.Signature = flip(w.Signature) },
+		"tree":       func(w *Welcome) { w.Tree[1].Public = flip(w.Tree[1].Public) },
+		"short tree": func(w *Welcome) { w.Tree = w.Tree[:2] },
+	}
+	for name, tamper := range tampers {
+		c := clone()
+		tamper(c)
+		if _, err := JoinGroup(c, bob.identity); err == nil {
+			t.Errorf("%s: tampered welcome accepted", name)
+		}
+	}
+	if _, err := JoinGroup(w, carol.identity); err == nil {
+		t.Error("carol joined with bob's welcome")
+	}
+	if _, err := JoinGroup(w, bob.identity); err != nil {
+		t.Fatalf("untouched welcome: %v", err)
+	}
+}
+
+func TestGenerationOrdering(t *testing.T) {
+	alice, bob := newMember(t, "alice"), newMember(t, "bob")
+	states := newTestGroup(t, alice, bob)
+	a, b := states[0], states[1]
+
+	var msgs []*GroupMessage
+	for i, text := range []string{"one", "two", "three"} {
+		msg := mustEncrypt(t, a, alice, text)
+		if msg.Generation != uint32(i) {
+			t.Fatalf("generation %d for message %d", msg.Generation, i)
+		}
+		msgs = append(msgs, msg)
+	}
+	if bytes.Equal(msgs[0].Ciphertext, msgs[1].Ciphertext) {
+		t.Fatal("generations share a key")
+	}
+	// Out of order, and redelivered
+	checkDecrypt(t, b, msgs[2], "three", "alice")
+	checkDecrypt(t, b, msgs[0], "one", "alice")
+	checkDecrypt(t, b, msgs[1], "two", "alice")
+	checkDecrypt(t, b, msgs[0], "one", "alice")
+
+	// A saved state carries on after the last generation used
+	path := filepath.Join(t.TempDir(), "group.json")
+	if err := SaveGroupState(path, a); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadGroupState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := mustEncrypt(t, loaded, alice, "four"); msg.Generation != 3 {
+		t.Fatalf("generation %d after reload, want 3", msg.Generation)
+	}
+
+	// A new epoch starts over, and the last one still decrypts
+	commit, _, bNext, err := b.Commit(bob.identity, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	late := mustEncrypt(t, a, alice, "late")
+	if a, err = a.Apply(commit); err != nil {
+		t.Fatal(err)
+	}
+	b = bNext
+	checkDecrypt(t, b, late, "late", "alice")
+	if msg := mustEncrypt(t, a, alice, "fresh"); msg.Generation != 0 || msg.Epoch != 2 {
+		t.Fatalf("epoch %d generation %d after commit", msg.Epoch, msg.Generation)
+	}
+
+	// Two epochs back is gone
+	commit, _, _, err = a.Commit(alice.identity, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err = b.Apply(commit); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Decrypt(late); err == nil {
+		t.Fatal("message from two epochs back decrypted")
+	}
+}
+
+func TestGroupMessageImpersonation(t *testing.T) {
+	alice, bob, carol := newMember(t, "alice"), newMember(t, "bob"), newMember(t, "carol")
+	states := newTestGroup(t, alice, bob, carol)
+	b, c := states[1], states[2]
+
+	// Bob holds the group secret, so he can build a message that decrypts
+	// as if alice sent it; only the signature gives him away
+	forged := &GroupMessage{Epoch: b.epoch, Sender: states[0].self, Generation: 7}
+	aead, nonce, err := messageKey(b.encryptionSecret, forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.Ciphertext = aead.Seal(nil, nonce, []byte("from alice, honest"), messageAAD(b.groupID, forged))
+	forged.Signature = ed25519.Sign(bob.identity, forged.signedContent(b.groupID))
+	if _, _, err := c.Decrypt(forged); err == nil {
+		t.Fatal("message posing as another member decrypted")
+	}
+
+	// Relabelling a real message's sender fails the same way
+	msg := mustEncrypt(t, b, bob, "from bob")
+	msg.Sender = states[0].self
+	if _, _, err := c.Decrypt(msg); err == nil {
+		t.Fatal("relabelled message decrypted")
+	}
+
+	// Unsigned
+	msg = mustEncrypt(t, b, bob, "from bob")
+	msg.Signature = nil
+	if _, _, err := c.Decrypt(msg); err == nil {
+		t.Fatal("unsigned message decrypted")
+	}
+
+	if _, err := b.Encrypt([]byte("x"), alice.identity); err == nil {
+		t.Fatal("encrypted with an identity not in our leaf")
+	}
+}
+
+// flip returns a copy of b with its first bit changed.
+func flip(b []byte) []byte {
+	out := append([]byte(nil), b...)
+	out[0] ^= 1
+	return out
+}
+
+func TestRestoredStateCommitsBeforeSending(t *testing.T) {
+	alice, bob := newMember(t, "alice"), newMember(t, "bob")
+	states := newTestGroup(t, alice, bob)
+	a, b := states[0], states[1]
+	path := filepath.Join(t.TempDir(), "group.json")
+	if err := SaveGroupState(path, b); err != nil {
+		t.Fatal(err)
+	}
+	mustEncrypt(t, b, bob, "sent before the restore")
+
+	restored, err := LoadGroupState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.MarkRestored()
+	if err := SaveGroupState(path, restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored, err = LoadGroupState(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Encrypt([]byte("reused nonce"), bob.identity); !errors.Is(err, ErrRestored) {
+		t.Fatalf("restored state encrypted: %v", err)
+	}
+
+	// Catching up on someone else's commit keeps the mark
+	commit, _, aNext, err := a.Commit(alice.identity, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a = aNext
+	if restored, err = restored.Apply(commit); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Restored() {
+		t.Fatal("applying a commit cleared the mark")
+	}
+
+	commit, _, next, err := restored.Commit(bob.identity, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Restored() {
+		t.Fatal("own commit kept the mark")
+	}
+	if a, err = a.Apply(commit); err != nil {
+		t.Fatal(err)
+	}
+	checkDecrypt(t, a, mustEncrypt(t, next, bob, "fresh epoch"), "fresh epoch", "bob")
+}
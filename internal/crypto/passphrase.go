@@ -0,0 +1,80 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Data sealed with a passphrase starts with a header naming how the key
+// was derived: magic, PBKDF2-SHA256 iteration count, salt and nonce. The
+// rest is AES-256-GCM with the header as additional data, so it cannot be
+// altered to weaken the derivation.
+
+var sealedMagic = []byte("SYNCRAP1")
+
+const (
+	passphraseIterations = 600_000
+	maxIterations        = 10_000_000 // Refuse headers that would hang us
+	saltSize             = 16
+	sealedHeaderSize     = 8 + 4 + saltSize + 12
+)
+
+// ErrWrongPassphrase is returned by OpenWithPassphrase when the data does
+// not decrypt, which a damaged file also causes.
+var ErrWrongPassphrase = errors.New("wrong passphrase or damaged data")
+
+// SealWithPassphrase encrypts plaintext under a key derived from
+// passphrase.
+func SealWithPassphrase(plaintext, passphrase []byte) ([]byte, error) {
+	header := make([]byte, sealedHeaderSize)
+	copy(header, sealedMagic)
+	binary.BigEndian.PutUint32(header[8:], passphraseIterations)
+	if _, err := rand.Read(header[12:]); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+	aead, err := passphraseAEAD(passphrase, header[12:12+saltSize], passphraseIterations)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, header[12+saltSize:], plaintext, header), nil
+}
+
+// OpenWithPassphrase decrypts data sealed by SealWithPassphrase.
+func OpenWithPassphrase(sealed, passphrase []byte) ([]byte, error) {
+	if len(sealed) < sealedHeaderSize || !bytes.Equal(sealed[:8], sealedMagic) {
+		return nil, fmt.Errorf("not passphrase-sealed data")
+	}
+	header := sealed[:sealedHeaderSize]
+	iterations := binary.BigEndian.Uint32(header[8:])
+	if iterations == 0 || iterations > maxIterations {
+		return nil, fmt.Errorf("unsupported key derivation cost %d", iterations)
+	}
+	aead, err := passphraseAEAD(passphrase, header[12:12+saltSize], int(iterations))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, header[12+saltSize:], sealed[sealedHeaderSize:], header)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+func passphraseAEAD(passphrase, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, string(passphrase), salt, iterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestSealWithPassphrase(t *testing.T) {
+	plaintext := []byte("identity and chats")
+	pass := []byte("correct horse battery staple")
+	sealed, err := SealWithPassphrase(plaintext, pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Fatal("plaintext visible in sealed data")
+	}
+	got, err := OpenWithPassphrase(sealed, pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("opened %q", got)
+	}
+	again, err := SealWithPassphrase(plaintext, pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(again[:sealedHeaderSize], sealed[:sealedHeaderSize]) {
+		t.Fatal("salt and nonce reused")
+	}
+
+	if _, err := OpenWithPassphrase(sealed, []byte("Correct horse battery staple")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("wrong passphrase: %v", err)
+	}
+
+	tamper := func(fn func([]byte)) []byte {
+		b := append([]byte{}, sealed...)
+		fn(b)
+		return b
+	}
+	// Changes that still derive a key must fail authentication
+	wrong := map[string][]byte{
+		"weaker derivation": tamper(func(b []byte) { binary.BigEndian.PutUint32(b[8:], 1000) }),
+		"salt":              tamper(func(b []byte) { b[12] ^= 1 }),
+		"nonce":             tamper(func(b []byte) { b[12+saltSize] ^= 1 }),
+		"ciphertext":        tamper(func(b []byte) { b[sealedHeaderSize] ^= 1 }),
+		"tag":               tamper(func(b []byte) { b[len(b)-1] ^= 1 }),
+		"truncated":         sealed[:len(sealed)-1],
+	}
+	for name, b := range wrong {
+		if _, err := OpenWithPassphrase(b, pass); !errors.Is(err, ErrWrongPassphrase) {
+			t.Errorf("%s: got %v, want ErrWrongPassphrase", name, err)
+		}
+	}
+	// The rest is refused before any key derivation
+	refused := map[string][]byte{
+		"magic":         tamper(func(b []byte) { b[0] ^= 1 }),
+		"no iterations": tamper(func(b []byte) { binary.BigEndian.PutUint32(b[8:], 0) }),
+		"too costly":    tamper(func(b []byte) { binary.BigEndian.PutUint32(b[8:], maxIterations+1) }),
+		"header only":   sealed[:sealedHeaderSize-1],
+		"not sealed":    []byte("plain text"),
+	}
+	for name, b := range refused {
+		if _, err := OpenWithPassphrase(b, pass); err == nil || errors.Is(err, ErrWrongPassphrase) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"math/bits"
+)
+
+// The ratchet tree follows the array layout of RFC 9420 appendix C: leaves
+// sit at even indices, parents at odd ones, and the tree is kept full by
+// doubling its leaf count, so leaf i is node 2i and the root is node n-1.
+// Every non-blank node holds an X25519 key pair; a member knows the private
+// keys of its own leaf and of the nodes on its direct path.
+
+// Credential ties a leaf to a Syncra identity.
+type Credential struct {
+	Username   string `json:"username"`
+	SigningKey []byte `json:"signing_key"` // Ed25519 public key
+}
+
+// Node is one position in the ratchet tree. A blank node has no Public key.
+type Node struct {
+	Public     []byte      `json:"public,omitempty"`
+	Private    []byte      `json:"private,omitempty"`    // Only on our own path; never sent
+	Credential *Credential `json:"credential,omitempty"` // Leaves only
+}
+
+func (n Node) blank() bool {
+	return len(n.Public) == 0
+}
+
+type ratchetTree []Node
+
+// leaves is the leaf count, always a power of two.
+func (t ratchetTree) leaves() uint32 {
+	return uint32(len(t)+1) / 2
+}
+
+func (t ratchetTree) root() uint32 {
+	return t.leaves() - 1
+}
+
+// level of node x: 0 for leaves, counting up towards the root.
+func level(x uint32) int {
+	return bits.TrailingZeros32(^x)
+}
+
+func left(x uint32) uint32 {
+	return x ^ (1 << (level(x) - 1))
+}
+
+func right(x uint32) uint32 {
+	return x ^ (3 << (level(x) - 1))
+}
+
+func parent(x uint32) uint32 {
+	k := level(x)
+	b := (x >> (k + 1)) & 1
+	return (x | (1 << k)) ^ (b << (k + 1))
+}
+
+func sibling(x uint32) uint32 {
+	p := parent(x)
+	if x < p {
+		return right(p)
+	}
+	return left(p)
+}
+
+// directPath lists the ancestors of x, nearest first, ending at the root.
+func (t ratchetTree) directPath(x uint32) []uint32 {
+	var path []uint32
+	for r := t.root(); x != r; {
+		x = parent(x)
+		path = append(path, x)
+	}
+	return path
+}
+
+// copath lists the sibling of x and of each of its ancestors below the root.
+func (t ratchetTree) copath(x uint32) []uint32 {
+	var path []uint32
+	for r := t.root(); x != r; x = parent(x) {
+		path = append(path, sibling(x))
+	}
+	return path
+}
+
+// contains reports whether node x lies in the subtree rooted at top.
+func contains(top, x uint32) bool {
+	k := level(top)
+	return x >= top-(1<<k)+1 && x <= top+(1<<k)-1
+}
+
+// resolution is the smallest set of non-blank nodes covering the subtree
+// under x, skipping any leaf in exclude.
+func (t ratchetTree) resolution(x uint32, exclude map[uint32]bool) []uint32 {
+	if !t[x].blank() {
+		if exclude[x] {
+			return nil
+		}
+		return []uint32{x}
+	}
+	if level(x) == 0 {
+		return nil
+	}
+	return append(t.resolution(left(x), exclude), t.resolution(right(x), exclude)...)
+}
+
+// addLeaf places c in the first free leaf, doubling the tree when full,
+// and blanks the new leaf's direct path. It returns the leaf index.
+func (t *ratchetTree) addLeaf(c Credential, pub []byte) uint32 {
+	leaf := t.leaves()
+	for i := uint32(0); i < t.leaves(); i++ {
+		if (*t)[2*i].Credential == nil {
+			leaf = i
+			break
+		}
+	}
+	if leaf == t.leaves() {
+		grown := make(ratchetTree, 2*len(*t)+1)
+		copy(grown, *t)
+		*t = grown
+	}
+	(*t)[2*leaf] = Node{Public: pub, Credential: &c}
+	t.blankPath(2 * leaf)
+	return leaf
+}
+
+// removeLeaf blanks a leaf and its direct path.
+func (t ratchetTree) removeLeaf(leaf uint32) {
+	t[2*leaf] = Node{}
+	t.blankPath(2 * leaf)
+}
+
+func (t ratchetTree) blankPath(x uint32) {
+	for _, p := range t.directPath(x) {
+		t[p] = Node{}
+	}
+}
+
+// findMember returns the leaf index of username.
+func (t ratchetTree) findMember(username string) (uint32, bool) {
+	for i := uint32(0); i < t.leaves(); i++ {
+		if c := t[2*i].Credential; c != nil && c.Username == username {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// credential returns the credential at a leaf index, if occupied.
+func (t ratchetTree) credential(leaf uint32) (*Credential, bool) {
+	if leaf >= t.leaves() || t[2*leaf].Credential == nil {
+		return nil, false
+	}
+	return t[2*leaf].Credential, true
+}
+
+func (t ratchetTree) signingKey(leaf uint32) (ed25519.PublicKey, bool) {
+	c, ok := t.credential(leaf)
+	if !ok || len(c.SigningKey) != ed25519.PublicKeySize {
+		return nil, false
+	}
+	return ed25519.PublicKey(c.SigningKey), true
+}
+
+// public returns a copy of the tree without private keys.
+func (t ratchetTree) public() ratchetTree {
+	out := make(ratchetTree, len(t))
+	for i, n := range t {
+		out[i] = Node{Public: n.Public, Credential: n.Credential}
+	}
+	return out
+}
+
+// hash commits to the public tree, so members that disagree on it derive
+// different epoch secrets.
+func (t ratchetTree) hash() []byte {
+	data, _ := json.Marshal(t.public())
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func (t ratchetTree) clone() ratchetTree {
+	out := make(ratchetTree, len(t))
+	copy(out, t)
+	return out
+}
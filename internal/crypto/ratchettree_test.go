@@ -0,0 +1,86 @@
+package crypto
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTreeMath(t *testing.T) {
+	tree := make(ratchetTree, 15) // 8 leaves
+	if tree.leaves() != 8 || tree.root() != 7 {
+		t.Fatalf("leaves %d, root %d", tree.leaves(), tree.root())
+	}
+	cases := []struct {
+		node           uint32
+		direct, copath []uint32
+	}{
+		{0, []uint32{1, 3, 7}, []uint32{2, 5, 11}},
+		{4, []uint32{5, 3, 7}, []uint32{6, 1, 11}},
+		{14, []uint32{13, 11, 7}, []uint32{12, 9, 3}},
+		{9, []uint32{11, 7}, []uint32{13, 3}},
+		{7, nil, nil},
+	}
+	for _, c := range cases {
+		if got := tree.directPath(c.node); !slices.Equal(got, c.direct) {
+			t.Errorf("directPath(%d) = %v, want %v", c.node, got, c.direct)
+		}
+		if got := tree.copath(c.node); !slices.Equal(got, c.copath) {
+			t.Errorf("copath(%d) = %v, want %v", c.node, got, c.copath)
+		}
+	}
+	if !contains(3, 0) || !contains(3, 6) || contains(3, 8) || !contains(7, 14) {
+		t.Error("contains disagrees with the layout")
+	}
+}
+
+func TestTreeAddRemove(t *testing.T) {
+	tree := ratchetTree{{}}
+	for i, name := range []string{"alice", "bob", "carol"} {
+		leaf := tree.addLeaf(Credential{Username: name}, []byte{byte(i + 1)})
+		if leaf != uint32(i) {
+			t.Fatalf("%s got leaf %d, want %d", name, leaf, i)
+		}
+	}
+	if len(tree) != 7 {
+		t.Fatalf("tree of %d nodes for 3 leaves, want 7", len(tree))
+	}
+	if got := tree.resolution(tree.root(), nil); !slices.Equal(got, []uint32{0, 2, 4}) {
+		t.Fatalf("resolution of a blank root %v", got)
+	}
+	if got := tree.resolution(tree.root(), map[uint32]bool{2: true}); !slices.Equal(got, []uint32{0, 4}) {
+		t.Fatalf("resolution excluding bob %v", got)
+	}
+
+	tree[1] = Node{Public: []byte{9}}
+	if got := tree.resolution(tree.root(), nil); !slices.Equal(got, []uint32{1, 4}) {
+		t.Fatalf("resolution with a filled parent %v", got)
+	}
+	tree.removeLeaf(1)
+	if !tree[2].blank() || !tree[1].blank() || tree[2].Credential != nil {
+		t.Fatal("removed leaf or its path still set")
+	}
+	if _, ok := tree.findMember("bob"); ok {
+		t.Fatal("removed member still found")
+	}
+	if leaf := tree.addLeaf(Credential{Username: "dave"}, []byte{4}); leaf != 1 {
+		t.Fatalf("dave got leaf %d, want the free leaf 1", leaf)
+	}
+	if leaf, ok := tree.findMember("carol"); !ok || leaf != 2 {
+		t.Fatalf("carol at %d, %v", leaf, ok)
+	}
+}
+
+func TestTreePublic(t *testing.T) {
+	tree := ratchetTree{{Public: []byte{1}, Private: []byte{2}, Credential: &Credential{Username: "alice"}}}
+	pub := tree.public()
+	if pub[0].Private != nil || tree[0].Private == nil {
+		t.Fatal("public copy kept the private key or changed the tree")
+	}
+	if !slices.Equal(pub.hash(), tree.hash()) {
+		t.Fatal("private keys changed the tree hash")
+	}
+	pub[0].Public = []byte{3}
+	if slices.Equal(pub.hash(), tree.hash()) {
+		t.Fatal("tree hash ignores public keys")
+	}
+}
@@ -0,0 +1,123 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A recovery phrase writes the 32-byte Ed25519 seed down as 24 words the
+// way BIP-39 writes entropy: the seed and the first byte of its SHA-256
+// make 264 bits, read as 24 indexes of 11 bits into the BIP-39 English
+// list. The seed is used as is, so the phrase does not derive the same
+// key a wallet would.
+
+//go:embed bip39_english.txt
+var wordList string
+
+var (
+	recoveryWords = strings.Fields(wordList)
+	wordIndex     = func() map[string]int {
+		index := make(map[string]int, len(recoveryWords))
+		for i, w := range recoveryWords {
+			index[w] = i
+		}
+		return index
+	}()
+)
+
+// RecoveryPhraseWords is the number of words in a recovery phrase
+const RecoveryPhraseWords = 24
+
+// ErrBadChecksum is returned by KeyFromRecoveryPhrase when every word is
+// known but they do not add up, usually a word written down wrong or two
+// swapped.
+var ErrBadChecksum = errors.New("recovery phrase checksum does not match; check the words and their order")
+
+// RecoveryPhrase returns the words that rebuild priv.
+func RecoveryPhrase(priv ed25519.PrivateKey) []string {
+	seed := priv.Seed()
+	sum := sha256.Sum256(seed)
+	bits := append(append([]byte{}, seed...), sum[0])
+
+	words := make([]string, RecoveryPhraseWords)
+	for i := range words {
+		words[i] = recoveryWords[readBits(bits, i*11, 11)]
+	}
+	return words
+}
+
+// KeyFromRecoveryPhrase rebuilds the key a recovery phrase was made from.
+// Case, spacing and numbering are ignored, and like BIP-39 the first four
+// letters of a word are enough.
+func KeyFromRecoveryPhrase(phrase string) (ed25519.PrivateKey, error) {
+	var words []string
+	for _, f := range strings.Fields(strings.ToLower(phrase)) {
+		// Numbered lists as RecoveryPhrase is printed: "1. abandon"
+		if strings.Trim(f, "0123456789.):") == "" {
+			continue
+		}
+		words = append(words, f)
+	}
+	if len(words) != RecoveryPhraseWords {
+		return nil, fmt.Errorf("recovery phrase has %d words, want %d", len(words), RecoveryPhraseWords)
+	}
+
+	bits := make([]byte, ed25519.SeedSize+1)
+	for i, w := range words {
+		n, ok := lookupWord(w)
+		if !ok {
+			return nil, fmt.Errorf("word %d, %q, is not in the recovery word list", i+1, w)
+		}
+		writeBits(bits, i*11, 11, n)
+	}
+	seed := bits[:ed25519.SeedSize]
+	if sum := sha256.Sum256(seed); sum[0] != bits[ed25519.SeedSize] {
+		return nil, ErrBadChecksum
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// lookupWord finds w in the list, or the one word it is a prefix of when
+// it has at least four letters.
+func lookupWord(w string) (int, bool) {
+	if n, ok := wordIndex[w]; ok {
+		return n, true
+	}
+	if len(w) < 4 {
+		return 0, false
+	}
+	found := -1
+	for i, candidate := range recoveryWords {
+		if strings.HasPrefix(candidate, w) {
+			if found >= 0 {
+				return 0, false
+			}
+			found = i
+		}
+	}
+	return found, found >= 0
+}
+
+// readBits reads n bits of data, most significant first, from bit offset.
+func readBits(data []byte, offset, n int) int {
+	v := 0
+	for i := offset; i < offset+n; i++ {
+		v = v<<1 | int(data[i/8]>>(7-i%8)&1)
+	}
+	return v
+}
+
+// writeBits stores the low n bits of v at bit offset, most significant
+// first.
+func writeBits(data []byte, offset, n, v int) {
+	for i := 0; i < n; i++ {
+		if v>>(n-1-i)&1 == 1 {
+			bit := offset + i
+			data[bit/8] |= 1 << (7 - bit%8)
+		}
+	}
+}
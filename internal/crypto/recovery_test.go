@@ -0,0 +1,125 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// BIP-39 vectors for 256 bits of entropy, which a recovery phrase treats
+// as the seed.
+var recoveryVectors = []struct {
+	seed   byte // Repeated 32 times
+	phrase string
+}{
+	{0x00, strings.Repeat("abandon ", 23) + "art"},
+	{0x7f, strings.Repeat("legal winner thank year wave sausage worth useful ", 2) +
+		"legal winner thank year wave sausage worth title"},
+	{0x80, strings.Repeat("letter advice cage absurd amount doctor acoustic avoid ", 2) +
+		"letter advice cage absurd amount doctor acoustic bless"},
+	{0xff, strings.Repeat("zoo ", 23) + "vote"},
+}
+
+func TestRecoveryPhraseVectors(t *testing.T) {
+	if len(recoveryWords) != 2048 {
+		t.Fatalf("word list has %d words", len(recoveryWords))
+	}
+	for _, v := range recoveryVectors {
+		seed := bytes.Repeat([]byte{v.seed}, ed25519.SeedSize)
+		got := strings.Join(RecoveryPhrase(ed25519.NewKeyFromSeed(seed)), " ")
+		if got != v.phrase {
+			t.Errorf("seed %#x:\n got %s\nwant %s", v.seed, got, v.phrase)
+		}
+		key, err := KeyFromRecoveryPhrase(v.phrase)
+		if err != nil {
+			t.Fatalf("seed %#x: %v", v.seed, err)
+		}
+		if !bytes.Equal(key.Seed(), seed) {
+			t.Errorf("seed %#x: read back %x", v.seed, key.Seed())
+		}
+	}
+}
+
+func TestRecoveryPhraseRoundTrip(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		words := RecoveryPhrase(priv)
+		if len(words) != RecoveryPhraseWords {
+			t.Fatalf("%d words", len(words))
+		}
+
+		// As printed, numbered one per line, and as typed back in short
+		var numbered, short strings.Builder
+		for j, w := range words {
+			fmt.Fprintf(&numbered, "%2d. %s\n", j+1, w)
+			fmt.Fprintf(&short, " %s ", strings.ToUpper(w[:min(4, len(w))]))
+		}
+		for _, phrase := range []string{strings.Join(words, " "), numbered.String(), short.String()} {
+			key, err := KeyFromRecoveryPhrase(phrase)
+			if err != nil {
+				t.Fatalf("%q: %v", phrase, err)
+			}
+			if !key.Equal(priv) {
+				t.Fatalf("%q rebuilt a different key", phrase)
+			}
+		}
+	}
+}
+
+func TestRecoveryPhraseRejects(t *testing.T) {
+	words := strings.Fields(recoveryVectors[2].phrase)
+	edit := func(fn func([]string)) string {
+		w := append([]string{}, words...)
+		fn(w)
+		return strings.Join(w, " ")
+	}
+
+	checksum := map[string]string{
+		"swapped words": edit(func(w []string) { w[0], w[1] = w[1], w[0] }),
+		"wrong word":    edit(func(w []string) { w[5] = "abandon" }),
+		"wrong last":    edit(func(w []string) { w[23] = "zoo" }),
+	}
+	for name, phrase := range checksum {
+		if _, err := KeyFromRecoveryPhrase(phrase); !errors.Is(err, ErrBadChecksum) {
+			t.Errorf("%s: got %v, want ErrBadChecksum", name, err)
+		}
+	}
+
+	malformed := map[string]string{
+		"too few":        strings.Join(words[1:], " "),
+		"too many":       strings.Join(words, " ") + " zoo",
+		"unknown word":   edit(func(w []string) { w[3] = "syncra" }),
+		"short prefix":   edit(func(w []string) { w[3] = "abs" }),
+		"numbers only":   strings.Repeat("1. ", RecoveryPhraseWords),
+		"number in word": edit(func(w []string) { w[3] = "cage1" }),
+	}
+	for name, phrase := range malformed {
+		if _, err := KeyFromRecoveryPhrase(phrase); err == nil || errors.Is(err, ErrBadChecksum) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}
+
+func TestBits(t *testing.T) {
+	data := make([]byte, 4)
+	writeBits(data, 0, 11, 0x7ff)
+	writeBits(data, 11, 11, 0x001)
+	writeBits(data, 22, 10, 0x2aa)
+	if want := []byte{0xff, 0xe0, 0x06, 0xaa}; !bytes.Equal(data, want) {
+		t.Fatalf("packed %08b, want %08b", data, want)
+	}
+	for _, c := range []struct{ offset, n, want int }{
+		{0, 11, 0x7ff}, {11, 11, 0x001}, {22, 10, 0x2aa}, {4, 8, 0xfe}, {7, 3, 0x7},
+	} {
+		if got := readBits(data, c.offset, c.n); got != c.want {
+			t.Errorf("readBits(%d, %d) = %#x, want %#x", c.offset, c.n, got, c.want)
+		}
+	}
+}
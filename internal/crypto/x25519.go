@@ -0,0 +1,153 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"math/big"
+)
+
+// fieldPrime is 2^255 - 19, shared by Curve25519 and Ed25519.
+var fieldPrime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// X25519FromEd25519 derives the X25519 key matching an Ed25519 identity,
+// the same scalar libsodium's crypto_sign_ed25519_sk_to_curve25519 uses.
+func X25519FromEd25519(priv ed25519.PrivateKey) (*ecdh.PrivateKey, error) {
+	h := sha512.Sum512(priv.Seed())
+	// X25519 clamps the scalar itself
+	return ecdh.X25519().NewPrivateKey(h[:32])
+}
+
+// X25519PublicFromEd25519 maps an Ed25519 public key to its X25519 form,
+// u = (1 + y) / (1 - y) mod p.
+func X25519PublicFromEd25519(pub ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid ed25519 public key size")
+	}
+	le := make([]byte, len(pub))
+	copy(le, pub)
+	le[31] &= 0x7f // Drop the sign of x
+	y := new(big.Int).SetBytes(reverse(le))
+	if y.Cmp(fieldPrime) >= 0 {
+		return nil, fmt.Errorf("invalid ed25519 public key")
+	}
+
+	one := big.NewInt(1)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, fieldPrime)
+	if den.Sign() == 0 {
+		return nil, fmt.Errorf("invalid ed25519 public key")
+	}
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, new(big.Int).ModInverse(den, fieldPrime))
+	u.Mod(u, fieldPrime)
+
+	out := make([]byte, 32)
+	u.FillBytes(out)
+	return ecdh.X25519().NewPublicKey(reverse(out))
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+// seal encrypts plaintext to pub in the manner of HPKE base mode: an
+// ephemeral X25519 exchange, HKDF-SHA256 and AES-256-GCM. info binds the
+// ciphertext to its context. It returns the ephemeral public key.
+func seal(pub []byte, info string, plaintext []byte) (enc, ciphertext []byte, err error) {
+	recipient, err := ecdh.X25519().NewPublicKey(pub)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid recipient key: %v", err)
+	}
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	shared, err := eph.ECDH(recipient)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc = eph.PublicKey().Bytes()
+	aead, err := sealKey(shared, enc, pub, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Every key is used once, so a fixed nonce is safe
+	return enc, aead.Seal(nil, make([]byte, aead.NonceSize()), plaintext, nil), nil
+}
+
+// open reverses seal with the recipient's private key.
+func open(priv []byte, enc []byte, info string, ciphertext []byte) ([]byte, error) {
+	key, err := ecdh.X25519().NewPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %v", err)
+	}
+	eph, err := ecdh.X25519().NewPublicKey(enc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %v", err)
+	}
+	shared, err := key.ECDH(eph)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := sealKey(shared, enc, key.PublicKey().Bytes(), info)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, make([]byte, aead.NonceSize()), ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %v", err)
+	}
+	return plaintext, nil
+}
+
+func sealKey(shared, enc, pub []byte, info string) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, enc...), pub...)
+	key, err := hkdf.Key(sha256.New, shared, salt, "syncra seal "+info, 32)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveSecret expands secret into a new 32-byte secret for label.
+func deriveSecret(secret []byte, label string) []byte {
+	out, err := hkdf.Expand(sha256.New, secret, "syncra "+label, 32)
+	if err != nil {
+		// Only fails for oversized output lengths
+		panic(err)
+	}
+	return out
+}
+
+// deriveKeyPair turns a node secret into an X25519 key pair.
+func deriveKeyPair(secret []byte) (priv, pub []byte, err error) {
+	key, err := ecdh.X25519().NewPrivateKey(deriveSecret(secret, "node key"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return key.Bytes(), key.PublicKey().Bytes(), nil
+}
+
+func randomSecret() []byte {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return b
+}
@@ -0,0 +1,72 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+)
+
+func TestX25519FromEd25519(t *testing.T) {
+	for range 16 {
+		pub, priv, err := GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, err := X25519FromEd25519(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		xpub, err := X25519PublicFromEd25519(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(x.PublicKey().Bytes(), xpub.Bytes()) {
+			t.Fatalf("converted public key %x, derived from the private key %x", xpub.Bytes(), x.PublicKey().Bytes())
+		}
+	}
+	if _, err := X25519PublicFromEd25519(make([]byte, 31)); err == nil {
+		t.Fatal("short key accepted")
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, ct, err := seal(key.PublicKey().Bytes(), "test", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := open(key.Bytes(), enc, "test", ct)
+	if err != nil || string(plain) != "secret" {
+		t.Fatalf("open: %q, %v", plain, err)
+	}
+
+	if _, err := open(key.Bytes(), enc, "other", ct); err == nil {
+		t.Error("opened under another info")
+	}
+	other, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	if _, err := open(other.Bytes(), enc, "test", ct); err == nil {
+		t.Error("opened with another key")
+	}
+	if _, err := open(key.Bytes(), enc, "test", flip(ct)); err == nil {
+		t.Error("opened a tampered ciphertext")
+	}
+}
+
+func TestDeriveKeyPair(t *testing.T) {
+	secret := randomSecret()
+	priv, pub, err := deriveKeyPair(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv2, pub2, _ := deriveKeyPair(secret)
+	if !bytes.Equal(priv, priv2) || !bytes.Equal(pub, pub2) {
+		t.Fatal("key pair not deterministic")
+	}
+	if bytes.Equal(deriveSecret(secret, "a"), deriveSecret(secret, "b")) {
+		t.Fatal("labels derive the same secret")
+	}
+}
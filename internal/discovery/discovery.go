@@ -3,6 +3,7 @@ package discovery
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
@@ -161,12 +162,20 @@ func (n *Node) StartServer(onMessage func([]byte)) error {
 	return http.ListenAndServe(":"+n.Port, nil)
 }
 
+// Peers that don't answer within this are treated as unreachable.
+var sendClient = &http.Client{Timeout: 5 * time.Second}
+
+// SendMessage posts data to a peer. It only succeeds once the peer has
+// accepted the message, so callers can keep it queued otherwise.
 func (n *Node) SendMessage(ip, port string, data []byte) error {
 	url := "http://" + ip + ":" + port + "/p2p"
-	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	resp, err := sendClient.Post(url, "application/json", bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer answered %s", resp.Status)
+	}
 	return nil
 }
@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ParseLevel maps LOG_LEVEL style names to slog levels, defaulting to info.
+func ParseLevel(s string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// NewServerLogger builds the relay logger. Headless deployments get JSON for
+// log shippers; the dashboard gets human-readable text.
+func NewServerLogger(w io.Writer, asJSON bool) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: ParseLevel(os.Getenv("LOG_LEVEL"))}
+	if asJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// NewClientLogger opens syncra/logs/client.log inside the workspace and
+// returns a logger writing to it. The caller must close the returned file.
+func NewClientLogger(workspacePath string) (*slog.Logger, io.Closer, error) {
+	dir := filepath.Join(workspacePath, "syncra", "logs")
+	f, err := OpenRotatingFile(filepath.Join(dir, "client.log"), defaultMaxSize, defaultBackups)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := &slog.HandlerOptions{Level: ParseLevel(os.Getenv("SYNCRA_LOG_LEVEL"))}
+	return slog.New(slog.NewTextHandler(f, opts)), f, nil
+}
+
+// Discard is a logger that drops everything, used before a workspace exists.
+func Discard() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
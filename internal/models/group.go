@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// Group is a multi-party conversation. The relay keeps membership; message
+// contents are end-to-end encrypted under keys the members agree on
+// through group commits.
+type Group struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Owner     string            `json:"owner"`
+	Members   []string          `json:"members"`        // Usernames, owner included
+	Epoch     uint64            `json:"epoch"`          // Next key agreement epoch the relay expects
+	Keys      map[string]string `json:"keys,omitempty"` // Member Ed25519 public keys, hex
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// HasMember reports whether username belongs to the group.
+func (g Group) HasMember(username string) bool {
+	for _, m := range g.Members {
+		if m == username {
+			return true
+		}
+	}
+	return false
+}
@@ -8,16 +8,47 @@ import (
 type MessageType string
 
 const (
+	TypeHello     MessageType = "hello"
 	TypeChallenge MessageType = "challenge"
 	TypeAuth      MessageType = "auth"
 	TypeChat      MessageType = "chat"
+	TypeEdit      MessageType = "edit"   // Replaces an earlier message's text
+	TypeDelete    MessageType = "delete" // Retracts an earlier message
+	TypeReaction  MessageType = "reaction"
+	TypeExpiry    MessageType = "expiry" // Sets a conversation's message timer
 	TypeSystem    MessageType = "system"
 	TypeError     MessageType = "error"
+	TypeAck       MessageType = "ack"
+
+	// Groups: To carries the group ID on group_chat
+	TypeGroupCreate MessageType = "group_create"
+	TypeGroupInvite MessageType = "group_invite"
+	TypeGroupRemove MessageType = "group_remove"
+	TypeGroupChat   MessageType = "group_chat"
+	TypeGroupInfo   MessageType = "group_info"
+
+	// Group key agreement: commits are ordered by the relay per epoch
+	TypeGroupCommit  MessageType = "group_commit"
+	TypeGroupWelcome MessageType = "group_welcome"
+	TypeGroupSync    MessageType = "group_sync"
+
+	// Block list: clients send block, the relay answers with block_list
+	TypeBlock     MessageType = "block"
+	TypeBlockList MessageType = "block_list"
+)
+
+// Delivery outcomes reported in an AckPayload
+const (
+	AckDelivered = "delivered" // Handed to the recipient's connection
+	AckOffline   = "offline"   // Recipient not connected; retry later
+	AckRejected  = "rejected"  // Refused for good; do not retry
 )
 
 // Packet is the base structure for all WebSocket communication
 type Packet struct {
+	Version   int             `json:"v,omitempty"` // Protocol version of the sender; 0 for pre-negotiation clients
 	Type      MessageType     `json:"type"`
+	ID        string          `json:"id,omitempty"` // Sender-generated, echoed in acks
 	From      string          `json:"from,omitempty"`
 	To        string          `json:"to,omitempty"`
 	Payload   json.RawMessage `json:"payload"`
@@ -38,14 +69,103 @@ type AuthPayload struct {
 
 // ChatPayload for E2EE messages
 type ChatPayload struct {
-	Message   string `json:"message"`   // Usually encrypted ciphertext
-	Ephemeral string `json:"ephemeral"` // Ephemeral public key for DH (optional)
+	Message   string `json:"message"`            // Usually encrypted ciphertext
+	Ephemeral string `json:"ephemeral"`          // Ephemeral public key for DH (optional)
+	Suite     string `json:"suite,omitempty"`    // Set when Message is not a plain 1:1 ciphertext
+	ReplyTo   string `json:"reply_to,omitempty"` // ID of the quoted message, sent in the clear
+	Expires   int64  `json:"expires,omitempty"`  // Seconds the message lasts after its timestamp
+}
+
+// EditPayload replaces the text of one of the sender's earlier messages.
+// For a group, To is the group ID and the text is encrypted as for
+// group_chat.
+type EditPayload struct {
+	Target string `json:"target"`          // ID of the edited message
+	Group  bool   `json:"group,omitempty"` // To is a group ID
+	ChatPayload
+}
+
+// DeletePayload retracts one of the sender's earlier messages
+type DeletePayload struct {
+	Target string `json:"target"`
+	Group  bool   `json:"group,omitempty"`
+}
+
+// ReactionPayload adds or, with Removed set, takes back the sender's
+// emoji reaction to a message. In a group the emoji is encrypted as for
+// group_chat and carried in Sealed, as an edit carries its text; Emoji is
+// then empty.
+type ReactionPayload struct {
+	Target  string       `json:"target"`
+	Group   bool         `json:"group,omitempty"`
+	Emoji   string       `json:"emoji,omitempty"`
+	Sealed  *ChatPayload `json:"sealed,omitempty"`
+	Removed bool         `json:"removed,omitempty"`
+}
+
+// ExpiryPayload sets how long new messages in a conversation last. Either
+// side may change it; zero turns the timer off.
+type ExpiryPayload struct {
+	Group   bool  `json:"group,omitempty"`
+	Seconds int64 `json:"seconds"`
+}
+
+// AckPayload reports what the relay did with the packet carrying ID
+type AckPayload struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// GroupCreatePayload asks the relay for a new group owned by the sender
+type GroupCreatePayload struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// GroupMemberPayload invites or removes one member of a group
+type GroupMemberPayload struct {
+	Group    string `json:"group"`
+	Username string `json:"username"`
+}
+
+// HandshakePayload carries group key agreement data. Data is opaque to the
+// relay, which only keeps one commit per group and epoch.
+type HandshakePayload struct {
+	Group    string                     `json:"group"`
+	Epoch    uint64                     `json:"epoch"`              // Epoch a commit applies to, or a welcome joins at
+	Data     json.RawMessage            `json:"data"`               // crypto.Commit or crypto.Welcome
+	Welcomes map[string]json.RawMessage `json:"welcomes,omitempty"` // Commits only: a Welcome per added username
+}
+
+// GroupSyncPayload asks the relay for the commits a member has missed
+type GroupSyncPayload struct {
+	Group string `json:"group"`
+	Epoch uint64 `json:"epoch"`          // First epoch wanted
+	Join  bool   `json:"join,omitempty"` // Send our welcome first
+}
+
+// BlockPayload blocks or unblocks a user for the sender
+type BlockPayload struct {
+	Username string `json:"username"`
+	Blocked  bool   `json:"blocked"`
+}
+
+// BlockListPayload is the sender's full block list, as the relay has it
+type BlockListPayload struct {
+	Users []string `json:"users"`
 }
 
 // LocalChatMessage for storage in syncra/chats/
 type LocalChatMessage struct {
-	From      string    `json:"from"`
-	Content   string    `json:"content"`
-	Timestamp time.Time `json:"timestamp"`
-	IsMe      bool      `json:"is_me"`
+	ID        string              `json:"id,omitempty"` // Packet ID; edits, reactions and replies refer to it
+	From      string              `json:"from"`
+	Content   string              `json:"content"`
+	Timestamp time.Time           `json:"timestamp"`
+	IsMe      bool                `json:"is_me"`
+	EditedAt  *time.Time          `json:"edited_at,omitempty"`
+	Deleted   bool                `json:"deleted,omitempty"`    // Retracted; Content is cleared
+	ReplyTo   string              `json:"reply_to,omitempty"`   // ID of the message this answers
+	ExpiresAt *time.Time          `json:"expires_at,omitempty"` // Purged from history after this
+	Reactions map[string][]string `json:"reactions,omitempty"`  // Usernames by emoji
 }
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"fmt"
+)
+
+const (
+	// ProtocolVersion is the newest wire protocol this build speaks.
+	ProtocolVersion = 1
+
+	// MinProtocolVersion is the oldest wire protocol this build accepts.
+	MinProtocolVersion = 1
+)
+
+// Optional protocol features a peer may advertise in its hello. The wire
+// encoding is not one of them; it is chosen by WebSocket subprotocol.
+const (
+	FeatureReceipts = "receipts" // Delivery acknowledgements
+)
+
+// Encryption suites, listed in a hello in order of preference.
+const (
+	SuiteGroup = "mls-x25519-aes256gcm" // Group messages under the group's epoch keys
+)
+
+// HelloPayload is exchanged before authentication. The client sends its
+// offer; the relay answers with the negotiated result or a TypeError.
+type HelloPayload struct {
+	Version    int      `json:"version"`          // Highest version spoken (or the agreed one in a reply)
+	MinVersion int      `json:"min_version"`      // Oldest version still accepted
+	Features   []string `json:"features"`         // Supported (or agreed) optional features
+	Suites     []string `json:"suites,omitempty"` // Encryption suites, most preferred first
+	Agent      string   `json:"agent,omitempty"`  // Free-form software identifier
+}
+
+// NewHello describes what this build supports.
+func NewHello(agent string, features, suites []string) HelloPayload {
+	return HelloPayload{
+		Version:    ProtocolVersion,
+		MinVersion: MinProtocolVersion,
+		Features:   features,
+		Suites:     suites,
+		Agent:      agent,
+	}
+}
+
+// Negotiate picks the highest version both sides accept and the features
+// both support. Suites keep local's order of preference; an empty suite list
+// on either side means "no opinion" and the other side's list is used.
+func Negotiate(local, remote HelloPayload) (HelloPayload, error) {
+	version := local.Version
+	if remote.Version < version {
+		version = remote.Version
+	}
+	floor := local.MinVersion
+	if remote.MinVersion > floor {
+		floor = remote.MinVersion
+	}
+	if version < floor || version < 1 {
+		return HelloPayload{}, fmt.Errorf("incompatible protocol: peer speaks v%d-v%d, we speak v%d-v%d",
+			remote.MinVersion, remote.Version, local.MinVersion, local.Version)
+	}
+
+	agreed := HelloPayload{
+		Version:    version,
+		MinVersion: floor,
+		Features:   intersect(local.Features, remote.Features),
+		Agent:      local.Agent,
+	}
+
+	switch {
+	case len(local.Suites) == 0:
+		agreed.Suites = remote.Suites
+	case len(remote.Suites) == 0:
+		agreed.Suites = local.Suites
+	default:
+		agreed.Suites = intersect(local.Suites, remote.Suites)
+		if len(agreed.Suites) == 0 {
+			return HelloPayload{}, fmt.Errorf("no common encryption suite (peer offers %v)", remote.Suites)
+		}
+	}
+
+	return agreed, nil
+}
+
+// Has reports whether feature was agreed.
+func (h HelloPayload) Has(feature string) bool {
+	for _, f := range h.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// intersect returns the members of a that also appear in b, in a's order.
+func intersect(a, b []string) []string {
+	out := []string{}
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				out = append(out, x)
+				break
+			}
+		}
+	}
+	return out
+}
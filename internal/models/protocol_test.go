@@ -0,0 +1,106 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	hello := func(min, max int, features, suites []string) HelloPayload {
+		return HelloPayload{Version: max, MinVersion: min, Features: features, Suites: suites}
+	}
+
+	cases := []struct {
+		name          string
+		local, remote HelloPayload
+		version       int
+		features      []string
+		suites        []string
+		fails         bool
+	}{
+		{
+			name:    "same version",
+			local:   hello(1, 1, []string{FeatureReceipts}, nil),
+			remote:  hello(1, 1, []string{FeatureReceipts}, nil),
+			version: 1, features: []string{FeatureReceipts},
+		},
+		{
+			name:    "newer peer steps down",
+			local:   hello(1, 2, nil, nil),
+			remote:  hello(1, 3, nil, nil),
+			version: 2, features: []string{},
+		},
+		{
+			name:   "peer too new",
+			local:  hello(1, 2, nil, nil),
+			remote: hello(3, 4, nil, nil),
+			fails:  true,
+		},
+		{
+			name:   "peer too old",
+			local:  hello(2, 3, nil, nil),
+			remote: hello(1, 1, nil, nil),
+			fails:  true,
+		},
+		{
+			name:   "pre-negotiation peer",
+			local:  hello(1, 1, nil, nil),
+			remote: hello(0, 0, nil, nil),
+			fails:  true,
+		},
+		{
+			name:    "features in local order, unknown ones dropped",
+			local:   hello(1, 1, []string{"b", FeatureReceipts, "a"}, nil),
+			remote:  hello(1, 1, []string{"a", "zstd", "b"}, nil),
+			version: 1, features: []string{"b", "a"},
+		},
+		{
+			name:    "relay without suite opinion",
+			local:   hello(1, 1, nil, nil),
+			remote:  hello(1, 1, nil, []string{SuiteGroup}),
+			version: 1, features: []string{}, suites: []string{SuiteGroup},
+		},
+		{
+			name:    "common suites in local order",
+			local:   hello(1, 1, nil, []string{"s2", SuiteGroup}),
+			remote:  hello(1, 1, nil, []string{SuiteGroup, "s3", "s2"}),
+			version: 1, features: []string{}, suites: []string{"s2", SuiteGroup},
+		},
+		{
+			name:   "no common suite",
+			local:  hello(1, 1, nil, []string{SuiteGroup}),
+			remote: hello(1, 1, nil, []string{"s3"}),
+			fails:  true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := Negotiate(c.local, c.remote)
+			if c.fails {
+				if err == nil {
+					t.Fatalf("agreed on %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Version != c.version {
+				t.Errorf("version %d, want %d", got.Version, c.version)
+			}
+			if !reflect.DeepEqual(got.Features, c.features) {
+				t.Errorf("features %q, want %q", got.Features, c.features)
+			}
+			if !reflect.DeepEqual(got.Suites, c.suites) {
+				t.Errorf("suites %q, want %q", got.Suites, c.suites)
+			}
+		})
+	}
+}
+
+func TestHas(t *testing.T) {
+	h := HelloPayload{Features: []string{FeatureReceipts}}
+	if !h.Has(FeatureReceipts) || h.Has("other") {
+		t.Fatalf("Has on %v", h.Features)
+	}
+}
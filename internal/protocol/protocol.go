@@ -0,0 +1,654 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"syncra/internal/models"
+	"time"
+	"unicode/utf8"
+)
+
+// Authenticated is the system notice the relay sends once auth succeeds.
+const Authenticated = "Authenticated"
+
+// StaleEpoch is the reason a group commit is rejected when another commit
+// already took its epoch.
+const StaleEpoch = "Stale epoch"
+
+// MessageExpired is the reason a disappearing message is rejected when it
+// reaches the relay after its timer ran out.
+const MessageExpired = "Message expired"
+
+// Every constructor stamps the current protocol version and time. Payloads
+// are always produced with encoding/json, never by string concatenation.
+
+func newPacket(t models.MessageType, payload any) models.Packet {
+	data, _ := json.Marshal(payload)
+	return models.Packet{
+		Version:   models.ProtocolVersion,
+		Type:      t,
+		Payload:   data,
+		Timestamp: time.Now(),
+	}
+}
+
+// Hello builds a negotiation offer or reply.
+func Hello(h models.HelloPayload) models.Packet {
+	return newPacket(models.TypeHello, h)
+}
+
+// Challenge builds the relay's auth nonce packet.
+func Challenge(nonce string) models.Packet {
+	return newPacket(models.TypeChallenge, nonce)
+}
+
+// Auth builds the client's signed response to a challenge.
+func Auth(username, signature string) (models.Packet, error) {
+	a := models.AuthPayload{Username: username, Signature: signature}
+	if err := validateAuth(a); err != nil {
+		return models.Packet{}, err
+	}
+	return newPacket(models.TypeAuth, a), nil
+}
+
+// NewMessageID returns a random ID for a packet that expects an ack.
+func NewMessageID() string {
+	b := make([]byte, messageIDHexLen/2)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Chat builds a message from one user to another. It carries a fresh ID so
+// the relay can ack it and the recipient can drop redelivered copies.
+func Chat(from, to string, chat models.ChatPayload) (models.Packet, error) {
+	if err := validateUser("recipient", to); err != nil {
+		return models.Packet{}, err
+	}
+	if err := validateChat(chat); err != nil {
+		return models.Packet{}, err
+	}
+	p := newPacket(models.TypeChat, chat)
+	p.ID = NewMessageID()
+	p.From = from
+	p.To = to
+	return p, nil
+}
+
+// Edit replaces the text of the sender's message target in a 1:1 chat or,
+// with group set, in the group to. Like Chat it carries a fresh ID.
+func Edit(from, to string, group bool, target string, chat models.ChatPayload) (models.Packet, error) {
+	e := models.EditPayload{Target: target, Group: group, ChatPayload: chat}
+	if err := validateRecipient(to, group); err != nil {
+		return models.Packet{}, err
+	}
+	if err := validateHex("target", target, messageIDHexLen); err != nil {
+		return models.Packet{}, err
+	}
+	if err := validateChat(chat); err != nil {
+		return models.Packet{}, err
+	}
+	return addressed(newPacket(models.TypeEdit, e), from, to), nil
+}
+
+// Delete retracts the sender's message target, addressed like Edit.
+func Delete(from, to string, group bool, target string) (models.Packet, error) {
+	d := models.DeletePayload{Target: target, Group: group}
+	if err := validateRecipient(to, group); err != nil {
+		return models.Packet{}, err
+	}
+	if err := validateHex("target", target, messageIDHexLen); err != nil {
+		return models.Packet{}, err
+	}
+	return addressed(newPacket(models.TypeDelete, d), from, to), nil
+}
+
+// Reaction adds or takes back the sender's emoji on message target in
+// the 1:1 chat with to.
+func Reaction(from, to, target, emoji string, removed bool) (models.Packet, error) {
+	r := models.ReactionPayload{Target: target, Emoji: emoji, Removed: removed}
+	if err := validateReaction(to, r); err != nil {
+		return models.Packet{}, err
+	}
+	return addressed(newPacket(models.TypeReaction, r), from, to), nil
+}
+
+// GroupReaction is Reaction for group, with the emoji sealed as for
+// GroupChat.
+func GroupReaction(from, group, target string, sealed models.ChatPayload, removed bool) (models.Packet, error) {
+	r := models.ReactionPayload{Target: target, Group: true, Sealed: &sealed, Removed: removed}
+	if err := validateReaction(group, r); err != nil {
+		return models.Packet{}, err
+	}
+	return addressed(newPacket(models.TypeReaction, r), from, group), nil
+}
+
+// Expiry sets the message timer of a 1:1 chat or, with group set, of the
+// group to. A zero ttl turns it off.
+func Expiry(from, to string, group bool, ttl time.Duration) (models.Packet, error) {
+	x := models.ExpiryPayload{Group: group, Seconds: int64(ttl / time.Second)}
+	if err := validateRecipient(to, group); err != nil {
+		return models.Packet{}, err
+	}
+	if err := validateExpiry(x.Seconds); err != nil {
+		return models.Packet{}, err
+	}
+	return addressed(newPacket(models.TypeExpiry, x), from, to), nil
+}
+
+// Expired reports whether a message stamped by its sender at p.Timestamp
+// outlived chat's timer at now.
+func Expired(p models.Packet, chat models.ChatPayload, now time.Time) bool {
+	return chat.Expires > 0 && now.After(p.Timestamp.Add(time.Duration(chat.Expires)*time.Second))
+}
+
+// addressed gives p a fresh ID and its routing fields.
+func addressed(p models.Packet, from, to string) models.Packet {
+	p.ID = NewMessageID()
+	p.From = from
+	p.To = to
+	return p
+}
+
+// GroupCreate asks the relay for a new group with the sender as owner.
+func GroupCreate(name string, members []string) (models.Packet, error) {
+	g := models.GroupCreatePayload{Name: name, Members: members}
+	if err := validateGroupCreate(g); err != nil {
+		return models.Packet{}, err
+	}
+	return newPacket(models.TypeGroupCreate, g), nil
+}
+
+// GroupInvite adds username to a group the sender belongs to.
+func GroupInvite(group, username string) (models.Packet, error) {
+	return groupMember(models.TypeGroupInvite, group, username)
+}
+
+// GroupRemove drops username from a group; removing yourself leaves it.
+func GroupRemove(group, username string) (models.Packet, error) {
+	return groupMember(models.TypeGroupRemove, group, username)
+}
+
+func groupMember(t models.MessageType, group, username string) (models.Packet, error) {
+	gm := models.GroupMemberPayload{Group: group, Username: username}
+	if err := validateGroupMember(gm); err != nil {
+		return models.Packet{}, err
+	}
+	return newPacket(t, gm), nil
+}
+
+// GroupChat builds a message to every member of a group. Like Chat it
+// carries an ID for acks and de-duplication.
+func GroupChat(from, group string, chat models.ChatPayload) (models.Packet, error) {
+	if err := validateGroupID("group", group); err != nil {
+		return models.Packet{}, err
+	}
+	if err := validateChat(chat); err != nil {
+		return models.Packet{}, err
+	}
+	p := newPacket(models.TypeGroupChat, chat)
+	p.ID = NewMessageID()
+	p.From = from
+	p.To = group
+	return p, nil
+}
+
+// GroupInfo builds the relay's notice of a group's current state. Members
+// no longer listed have been removed.
+func GroupInfo(g models.Group) models.Packet {
+	return newPacket(models.TypeGroupInfo, g)
+}
+
+// GroupCommit builds a key agreement commit for h.Group. It carries an ID:
+// the relay acks it once the commit is stored, or rejects it if another
+// commit already took the epoch.
+func GroupCommit(from string, h models.HandshakePayload) (models.Packet, error) {
+	if err := validateHandshake(models.TypeGroupCommit, h); err != nil {
+		return models.Packet{}, err
+	}
+	p := newPacket(models.TypeGroupCommit, h)
+	p.ID = NewMessageID()
+	p.From = from
+	p.To = h.Group
+	return p, nil
+}
+
+// GroupWelcome builds the relay's delivery of a stored welcome.
+func GroupWelcome(h models.HandshakePayload) models.Packet {
+	return newPacket(models.TypeGroupWelcome, h)
+}
+
+// GroupSync asks the relay for a group's commits from epoch on, and with
+// join set, for the welcome that added us first.
+func GroupSync(group string, epoch uint64, join bool) (models.Packet, error) {
+	s := models.GroupSyncPayload{Group: group, Epoch: epoch, Join: join}
+	if err := validateGroupID("group", group); err != nil {
+		return models.Packet{}, err
+	}
+	return newPacket(models.TypeGroupSync, s), nil
+}
+
+// Block asks the relay to stop (or resume) relaying username's messages
+// to the sender.
+func Block(username string, blocked bool) (models.Packet, error) {
+	if err := validateUser("username", username); err != nil {
+		return models.Packet{}, err
+	}
+	return newPacket(models.TypeBlock, models.BlockPayload{Username: username, Blocked: blocked}), nil
+}
+
+// BlockList builds the relay's copy of a user's block list.
+func BlockList(users []string) models.Packet {
+	return newPacket(models.TypeBlockList, models.BlockListPayload{Users: users})
+}
+
+// Ack builds the relay's delivery receipt for the packet with the given ID.
+func Ack(id, status, reason string) models.Packet {
+	return newPacket(models.TypeAck, models.AckPayload{ID: id, Status: status, Reason: truncate(reason)})
+}
+
+// System builds an informational notice from the relay.
+func System(msg string) models.Packet {
+	return newPacket(models.TypeSystem, truncate(msg))
+}
+
+// Error builds an error notice from the relay.
+func Error(msg string) models.Packet {
+	return newPacket(models.TypeError, truncate(msg))
+}
+
+// DecodeHello extracts and checks a hello payload.
+func DecodeHello(p models.Packet) (models.HelloPayload, error) {
+	var h models.HelloPayload
+	if err := decode(p, models.TypeHello, &h); err != nil {
+		return h, err
+	}
+	if h.Version < 1 {
+		return h, invalid("hello version must be positive")
+	}
+	if h.MinVersion > h.Version {
+		return h, invalid("hello min_version exceeds version")
+	}
+	return h, nil
+}
+
+// DecodeChallenge extracts the hex nonce from a challenge.
+func DecodeChallenge(p models.Packet) (string, error) {
+	var nonce string
+	if err := decode(p, models.TypeChallenge, &nonce); err != nil {
+		return "", err
+	}
+	return nonce, validateHex("challenge", nonce, challengeHexLen)
+}
+
+// DecodeAuth extracts and checks an auth payload.
+func DecodeAuth(p models.Packet) (models.AuthPayload, error) {
+	var a models.AuthPayload
+	if err := decode(p, models.TypeAuth, &a); err != nil {
+		return a, err
+	}
+	return a, validateAuth(a)
+}
+
+// DecodeChat extracts and checks a chat payload. Routing fields are
+// checked too: To always, From when present (the relay fills it in).
+func DecodeChat(p models.Packet) (models.ChatPayload, error) {
+	var c models.ChatPayload
+	if err := decode(p, models.TypeChat, &c); err != nil {
+		return c, err
+	}
+	if err := validateUser("recipient", p.To); err != nil {
+		return c, err
+	}
+	if p.From != "" {
+		if err := validateUser("sender", p.From); err != nil {
+			return c, err
+		}
+	}
+	if p.ID != "" {
+		if err := validateHex("message id", p.ID, messageIDHexLen); err != nil {
+			return c, err
+		}
+	}
+	return c, validateChat(c)
+}
+
+// DecodeEdit extracts and checks an edit, routing fields included.
+func DecodeEdit(p models.Packet) (models.EditPayload, error) {
+	var e models.EditPayload
+	if err := decode(p, models.TypeEdit, &e); err != nil {
+		return e, err
+	}
+	if err := validateRouting(p, e.Group); err != nil {
+		return e, err
+	}
+	if err := validateHex("target", e.Target, messageIDHexLen); err != nil {
+		return e, err
+	}
+	return e, validateChat(e.ChatPayload)
+}
+
+// DecodeDelete extracts and checks a retraction, routing fields included.
+func DecodeDelete(p models.Packet) (models.DeletePayload, error) {
+	var d models.DeletePayload
+	if err := decode(p, models.TypeDelete, &d); err != nil {
+		return d, err
+	}
+	if err := validateRouting(p, d.Group); err != nil {
+		return d, err
+	}
+	return d, validateHex("target", d.Target, messageIDHexLen)
+}
+
+// DecodeReaction extracts and checks a reaction, routing fields included.
+func DecodeReaction(p models.Packet) (models.ReactionPayload, error) {
+	var r models.ReactionPayload
+	if err := decode(p, models.TypeReaction, &r); err != nil {
+		return r, err
+	}
+	if err := validateRouting(p, r.Group); err != nil {
+		return r, err
+	}
+	return r, validateReaction(p.To, r)
+}
+
+// DecodeExpiry extracts and checks a timer change, routing fields
+// included.
+func DecodeExpiry(p models.Packet) (models.ExpiryPayload, error) {
+	var x models.ExpiryPayload
+	if err := decode(p, models.TypeExpiry, &x); err != nil {
+		return x, err
+	}
+	if err := validateRouting(p, x.Group); err != nil {
+		return x, err
+	}
+	return x, validateExpiry(x.Seconds)
+}
+
+// DecodeGroupCreate extracts and checks a group creation request.
+func DecodeGroupCreate(p models.Packet) (models.GroupCreatePayload, error) {
+	var g models.GroupCreatePayload
+	if err := decode(p, models.TypeGroupCreate, &g); err != nil {
+		return g, err
+	}
+	return g, validateGroupCreate(g)
+}
+
+// DecodeGroupMember extracts and checks an invite or removal.
+func DecodeGroupMember(p models.Packet) (models.GroupMemberPayload, error) {
+	var gm models.GroupMemberPayload
+	if p.Type != models.TypeGroupInvite && p.Type != models.TypeGroupRemove {
+		return gm, invalid("expected group membership packet, got %q", p.Type)
+	}
+	if err := decode(p, p.Type, &gm); err != nil {
+		return gm, err
+	}
+	return gm, validateGroupMember(gm)
+}
+
+// DecodeGroupChat extracts and checks a group message; To is the group.
+func DecodeGroupChat(p models.Packet) (models.ChatPayload, error) {
+	var c models.ChatPayload
+	if err := decode(p, models.TypeGroupChat, &c); err != nil {
+		return c, err
+	}
+	if err := validateGroupID("group", p.To); err != nil {
+		return c, err
+	}
+	if p.From != "" {
+		if err := validateUser("sender", p.From); err != nil {
+			return c, err
+		}
+	}
+	if p.ID != "" {
+		if err := validateHex("message id", p.ID, messageIDHexLen); err != nil {
+			return c, err
+		}
+	}
+	return c, validateChat(c)
+}
+
+// DecodeGroupInfo extracts and checks a group notice.
+func DecodeGroupInfo(p models.Packet) (models.Group, error) {
+	var g models.Group
+	if err := decode(p, models.TypeGroupInfo, &g); err != nil {
+		return g, err
+	}
+	if err := validateGroupID("group", g.ID); err != nil {
+		return g, err
+	}
+	if err := validateText("group name", g.Name, MaxGroupNameBytes); err != nil {
+		return g, err
+	}
+	for _, m := range g.Members {
+		if err := validateUser("member", m); err != nil {
+			return g, err
+		}
+	}
+	return g, nil
+}
+
+// DecodeHandshake extracts and checks a group commit or welcome.
+func DecodeHandshake(p models.Packet) (models.HandshakePayload, error) {
+	var h models.HandshakePayload
+	if p.Type != models.TypeGroupCommit && p.Type != models.TypeGroupWelcome {
+		return h, invalid("expected group handshake packet, got %q", p.Type)
+	}
+	if len(p.Payload) > MaxHandshakeBytes {
+		return h, invalid("%s exceeds %d bytes", p.Type, MaxHandshakeBytes)
+	}
+	if err := decode(p, p.Type, &h); err != nil {
+		return h, err
+	}
+	if p.ID != "" {
+		if err := validateHex("message id", p.ID, messageIDHexLen); err != nil {
+			return h, err
+		}
+	}
+	return h, validateHandshake(p.Type, h)
+}
+
+// DecodeGroupSync extracts and checks a sync request.
+func DecodeGroupSync(p models.Packet) (models.GroupSyncPayload, error) {
+	var s models.GroupSyncPayload
+	if err := decode(p, models.TypeGroupSync, &s); err != nil {
+		return s, err
+	}
+	return s, validateGroupID("group", s.Group)
+}
+
+// DecodeBlock extracts and checks a block request.
+func DecodeBlock(p models.Packet) (models.BlockPayload, error) {
+	var b models.BlockPayload
+	if err := decode(p, models.TypeBlock, &b); err != nil {
+		return b, err
+	}
+	return b, validateUser("username", b.Username)
+}
+
+// DecodeBlockList extracts and checks a block list.
+func DecodeBlockList(p models.Packet) ([]string, error) {
+	var b models.BlockListPayload
+	if err := decode(p, models.TypeBlockList, &b); err != nil {
+		return nil, err
+	}
+	for _, u := range b.Users {
+		if err := validateUser("blocked user", u); err != nil {
+			return nil, err
+		}
+	}
+	return b.Users, nil
+}
+
+// DecodeAck extracts and checks a delivery receipt.
+func DecodeAck(p models.Packet) (models.AckPayload, error) {
+	var a models.AckPayload
+	if err := decode(p, models.TypeAck, &a); err != nil {
+		return a, err
+	}
+	if err := validateHex("ack id", a.ID, messageIDHexLen); err != nil {
+		return a, err
+	}
+	switch a.Status {
+	case models.AckDelivered, models.AckOffline, models.AckRejected:
+	default:
+		return a, invalid("unknown ack status %q", a.Status)
+	}
+	return a, nil
+}
+
+// DecodeText extracts the message of a system or error packet.
+func DecodeText(p models.Packet) (string, error) {
+	if p.Type != models.TypeSystem && p.Type != models.TypeError {
+		return "", invalid("expected system or error packet, got %q", p.Type)
+	}
+	var s string
+	if err := json.Unmarshal(p.Payload, &s); err != nil {
+		return "", invalid("malformed %s payload", p.Type)
+	}
+	return s, validateText("text", s, MaxTextBytes)
+}
+
+func decode(p models.Packet, want models.MessageType, v any) error {
+	if p.Type != want {
+		return invalid("expected %s packet, got %q", want, p.Type)
+	}
+	if len(p.Payload) == 0 {
+		return invalid("%s payload is missing", want)
+	}
+	if err := json.Unmarshal(p.Payload, v); err != nil {
+		return invalid("malformed %s payload", want)
+	}
+	return nil
+}
+
+func validateAuth(a models.AuthPayload) error {
+	if err := validateUser("username", a.Username); err != nil {
+		return err
+	}
+	return validateHex("signature", a.Signature, hex.EncodedLen(ed25519.SignatureSize))
+}
+
+func validateChat(c models.ChatPayload) error {
+	if err := validateText("message", c.Message, MaxChatBytes); err != nil {
+		return err
+	}
+	if c.Ephemeral != "" {
+		if err := validateHex("ephemeral key", c.Ephemeral, hex.EncodedLen(32)); err != nil {
+			return err
+		}
+	}
+	if c.ReplyTo != "" {
+		if err := validateHex("reply_to", c.ReplyTo, messageIDHexLen); err != nil {
+			return err
+		}
+	}
+	return validateExpiry(c.Expires)
+}
+
+// validateReaction checks a reaction to: the emoji in the clear for a
+// user, sealed for a group.
+func validateReaction(to string, r models.ReactionPayload) error {
+	if err := validateRecipient(to, r.Group); err != nil {
+		return err
+	}
+	if err := validateHex("target", r.Target, messageIDHexLen); err != nil {
+		return err
+	}
+	if !r.Group {
+		if r.Sealed != nil {
+			return invalid("sealed reaction outside a group")
+		}
+		return ValidateEmoji(r.Emoji)
+	}
+	if r.Emoji != "" {
+		return invalid("group reaction with an unsealed emoji")
+	}
+	if r.Sealed == nil || r.Sealed.Suite != models.SuiteGroup {
+		return invalid("group reaction is not sealed")
+	}
+	return validateChat(*r.Sealed)
+}
+
+// validateRecipient checks To: a group ID when group is set, else a user.
+func validateRecipient(to string, group bool) error {
+	if group {
+		return validateGroupID("group", to)
+	}
+	return validateUser("recipient", to)
+}
+
+// validateRouting checks the routing fields of a packet that refers to an
+// earlier message; From is filled in by the relay, so it may be empty.
+func validateRouting(p models.Packet, group bool) error {
+	if err := validateRecipient(p.To, group); err != nil {
+		return err
+	}
+	if p.From != "" {
+		if err := validateUser("sender", p.From); err != nil {
+			return err
+		}
+	}
+	if p.ID != "" {
+		return validateHex("message id", p.ID, messageIDHexLen)
+	}
+	return nil
+}
+
+func validateGroupCreate(g models.GroupCreatePayload) error {
+	if err := validateText("group name", g.Name, MaxGroupNameBytes); err != nil {
+		return err
+	}
+	if len(g.Members) > MaxGroupMembers {
+		return invalid("groups are limited to %d members", MaxGroupMembers)
+	}
+	for _, m := range g.Members {
+		if err := validateUser("member", m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateGroupMember(gm models.GroupMemberPayload) error {
+	if err := validateGroupID("group", gm.Group); err != nil {
+		return err
+	}
+	return validateUser("username", gm.Username)
+}
+
+func validateHandshake(t models.MessageType, h models.HandshakePayload) error {
+	if err := validateGroupID("group", h.Group); err != nil {
+		return err
+	}
+	if len(h.Data) == 0 {
+		return invalid("%s data is required", t)
+	}
+	if t != models.TypeGroupCommit && len(h.Welcomes) > 0 {
+		return invalid("only commits carry welcomes")
+	}
+	size := len(h.Data)
+	for u, w := range h.Welcomes {
+		if err := validateUser("welcome recipient", u); err != nil {
+			return err
+		}
+		size += len(w)
+	}
+	if size > MaxHandshakeBytes {
+		return invalid("%s exceeds %d bytes", t, MaxHandshakeBytes)
+	}
+	return nil
+}
+
+// truncate keeps relay notices within MaxTextBytes on a rune boundary.
+func truncate(s string) string {
+	if len(s) <= MaxTextBytes {
+		return s
+	}
+	s = s[:MaxTextBytes]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,344 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"syncra/internal/models"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	testGroup = "0b5f0c2e-8a4f-4c1e-9d3b-2f6a7c8d9e10"
+	testID    = "00112233445566778899aabbccddeeff"
+)
+
+var testSignature = strings.Repeat("ab", 64)
+
+// decoders maps each packet type to its decoder, with results in the
+// shape their payload is encoded in.
+var decoders = map[models.MessageType]func(models.Packet) (any, error){
+	models.TypeHello:        func(p models.Packet) (any, error) { return DecodeHello(p) },
+	models.TypeChallenge:    func(p models.Packet) (any, error) { return DecodeChallenge(p) },
+	models.TypeAuth:         func(p models.Packet) (any, error) { return DecodeAuth(p) },
+	models.TypeChat:         func(p models.Packet) (any, error) { return DecodeChat(p) },
+	models.TypeEdit:         func(p models.Packet) (any, error) { return DecodeEdit(p) },
+	models.TypeDelete:       func(p models.Packet) (any, error) { return DecodeDelete(p) },
+	models.TypeReaction:     func(p models.Packet) (any, error) { return DecodeReaction(p) },
+	models.TypeExpiry:       func(p models.Packet) (any, error) { return DecodeExpiry(p) },
+	models.TypeSystem:       func(p models.Packet) (any, error) { return DecodeText(p) },
+	models.TypeError:        func(p models.Packet) (any, error) { return DecodeText(p) },
+	models.TypeAck:          func(p models.Packet) (any, error) { return DecodeAck(p) },
+	models.TypeGroupCreate:  func(p models.Packet) (any, error) { return DecodeGroupCreate(p) },
+	models.TypeGroupInvite:  func(p models.Packet) (any, error) { return DecodeGroupMember(p) },
+	models.TypeGroupRemove:  func(p models.Packet) (any, error) { return DecodeGroupMember(p) },
+	models.TypeGroupChat:    func(p models.Packet) (any, error) { return DecodeGroupChat(p) },
+	models.TypeGroupInfo:    func(p models.Packet) (any, error) { return DecodeGroupInfo(p) },
+	models.TypeGroupCommit:  func(p models.Packet) (any, error) { return DecodeHandshake(p) },
+	models.TypeGroupWelcome: func(p models.Packet) (any, error) { return DecodeHandshake(p) },
+	models.TypeGroupSync:    func(p models.Packet) (any, error) { return DecodeGroupSync(p) },
+	models.TypeBlock:        func(p models.Packet) (any, error) { return DecodeBlock(p) },
+	models.TypeBlockList: func(p models.Packet) (any, error) {
+		users, err := DecodeBlockList(p)
+		return models.BlockListPayload{Users: users}, err
+	},
+}
+
+// wire sends p through JSON as a connection would.
+func wire(t testing.TB, p models.Packet) models.Packet {
+	t.Helper()
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out models.Packet
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// must unwraps constructor results, failing t on an error.
+func must(t *testing.T) func(models.Packet, error) models.Packet {
+	return func(p models.Packet, err error) models.Packet {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	chat := models.ChatPayload{
+		Message:   "line one\nline \"two\"\"}, \"type\": \"auth",
+		Ephemeral: strings.Repeat("0f", 32),
+		ReplyTo:   testID,
+		Expires:   3600,
+	}
+	handshake := models.HandshakePayload{
+		Group:    testGroup,
+		Epoch:    7,
+		Data:     json.RawMessage(`{"commit":1}`),
+		Welcomes: map[string]json.RawMessage{"bob": json.RawMessage(`{"welcome":2}`)},
+	}
+	group := models.Group{
+		ID: testGroup, Name: "Team <&>", Owner: "alice", Members: []string{"alice", "bob"},
+		Epoch: 3, Keys: map[string]string{"alice": "aa"}, CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	hello := models.NewHello("syncra test", []string{"acks"}, []string{"x25519"})
+	sealed := models.ChatPayload{Message: "c2VhbGVk", Suite: models.SuiteGroup}
+	must := must(t)
+
+	cases := []struct {
+		name   string
+		packet models.Packet
+		want   any
+	}{
+		{"hello", Hello(hello), hello},
+		{"challenge", Challenge(strings.Repeat("cd", 32)), strings.Repeat("cd", 32)},
+		{"auth", must(Auth("alice", testSignature)), models.AuthPayload{Username: "alice", Signature: testSignature}},
+		{"chat", must(Chat("alice", "bob", chat)), chat},
+		{"edit", must(Edit("alice", testGroup, true, testID, chat)), models.EditPayload{Target: testID, Group: true, ChatPayload: chat}},
+		{"delete", must(Delete("alice", "bob", false, testID)), models.DeletePayload{Target: testID}},
+		{"reaction", must(Reaction("alice", "bob", testID, "👍🏽", true)), models.ReactionPayload{Target: testID, Emoji: "👍🏽", Removed: true}},
+		{"group reaction", must(GroupReaction("alice", testGroup, testID, sealed, false)), models.ReactionPayload{Target: testID, Group: true, Sealed: &sealed}},
+		{"expiry", must(Expiry("alice", testGroup, true, time.Hour)), models.ExpiryPayload{Group: true, Seconds: 3600}},
+		{"system", System(`quoted "text"` + "\n"), `quoted "text"` + "\n"},
+		{"error", Error("bad </script>"), "bad </script>"},
+		{"ack", Ack(testID, models.AckRejected, "no"), models.AckPayload{ID: testID, Status: models.AckRejected, Reason: "no"}},
+		{"group create", must(GroupCreate("Team", []string{"bob"})), models.GroupCreatePayload{Name: "Team", Members: []string{"bob"}}},
+		{"group invite", must(GroupInvite(testGroup, "bob")), models.GroupMemberPayload{Group: testGroup, Username: "bob"}},
+		{"group remove", must(GroupRemove(testGroup, "bob")), models.GroupMemberPayload{Group: testGroup, Username: "bob"}},
+		{"group chat", must(GroupChat("alice", testGroup, chat)), chat},
+		{"group info", GroupInfo(group), group},
+		{"group commit", must(GroupCommit("alice", handshake)), handshake},
+		{"group welcome", GroupWelcome(models.HandshakePayload{Group: testGroup, Epoch: 8, Data: json.RawMessage(`{"welcome":2}`)}),
+			models.HandshakePayload{Group: testGroup, Epoch: 8, Data: json.RawMessage(`{"welcome":2}`)}},
+		{"group sync", must(GroupSync(testGroup, 1<<63+1, true)), models.GroupSyncPayload{Group: testGroup, Epoch: 1<<63 + 1, Join: true}},
+		{"block", must(Block("bob", true)), models.BlockPayload{Username: "bob", Blocked: true}},
+		{"block list", BlockList([]string{"bob", "carol"}), models.BlockListPayload{Users: []string{"bob", "carol"}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := wire(t, c.packet)
+			if p.Version != models.ProtocolVersion {
+				t.Errorf("version %d", p.Version)
+			}
+			got, err := decoders[p.Type](p)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %#v\nwant %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	must := must(t)
+	chat := must(Chat("alice", "bob", models.ChatPayload{Message: "hi"}))
+	cases := map[string]models.Packet{
+		"wrong type":        {Type: models.TypeChat, Payload: json.RawMessage(`{"username":"alice"}`)},
+		"missing payload":   {Type: models.TypeAuth},
+		"malformed payload": {Type: models.TypeAuth, Payload: json.RawMessage(`{"username":`)},
+		"short signature":   {Type: models.TypeAuth, Payload: json.RawMessage(`{"username":"alice","signature":"abcd"}`)},
+		"control in name":   {Type: models.TypeAuth, Payload: json.RawMessage(`{"username":"al\nice","signature":"` + testSignature + `"}`)},
+		"empty chat":        {Type: models.TypeChat, To: "bob", Payload: json.RawMessage(`{"message":""}`)},
+		"bad message id":    {Type: models.TypeChat, To: "bob", ID: "xyz", Payload: chat.Payload},
+		"no recipient":      {Type: models.TypeChat, Payload: chat.Payload},
+		"user as group":     {Type: models.TypeGroupChat, To: "bob", Payload: chat.Payload},
+		"negative expiry":   {Type: models.TypeExpiry, To: "bob", Payload: json.RawMessage(`{"seconds":-1}`)},
+		"unknown ack":       {Type: models.TypeAck, Payload: json.RawMessage(`{"id":"` + testID + `","status":"lost"}`)},
+		"welcome in welcome": {Type: models.TypeGroupWelcome, Payload: json.RawMessage(
+			`{"group":"` + testGroup + `","data":{},"welcomes":{"bob":{}}}`)},
+		"clear group reaction": {Type: models.TypeReaction, To: testGroup, Payload: json.RawMessage(
+			`{"target":"` + testID + `","group":true,"emoji":"👍"}`)},
+		"half-sealed group reaction": {Type: models.TypeReaction, To: testGroup, Payload: json.RawMessage(
+			`{"target":"` + testID + `","group":true,"emoji":"👍","sealed":{"message":"c2VhbGVk","suite":"` + models.SuiteGroup + `"}}`)},
+		"sealed 1:1 reaction": {Type: models.TypeReaction, To: "bob", Payload: json.RawMessage(
+			`{"target":"` + testID + `","emoji":"👍","sealed":{"message":"c2VhbGVk","suite":"` + models.SuiteGroup + `"}}`)},
+		"oversized chat": {Type: models.TypeChat, To: "bob", Payload: json.RawMessage(
+			`{"message":"` + strings.Repeat("a", MaxChatBytes+1) + `"}`)},
+	}
+	for name, p := range cases {
+		decode := decoders[p.Type]
+		if _, err := decode(p); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %v, want ErrInvalid", name, err)
+		}
+	}
+}
+
+func TestUsernames(t *testing.T) {
+	must := must(t)
+	for _, name := range []string{"alice", "a1", "bob.smith", "x_y-z"} {
+		if err := ValidateUsername(name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+	// Accounts from before the format was enforced still sign in
+	legacy := []string{"John Smith", "josé", "-dash", "a", "o'neil@home"}
+	for _, name := range legacy {
+		if ValidateUsername(name) == nil {
+			t.Errorf("%q passes the registration format", name)
+		}
+		p := must(Auth(name, testSignature))
+		if _, err := DecodeAuth(wire(t, p)); err != nil {
+			t.Errorf("legacy %q locked out: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "tab\there", strings.Repeat("x", MaxUsernameLen+1)} {
+		if ValidateExistingUsername(name) == nil {
+			t.Errorf("%q accepted", name)
+		}
+	}
+	// Chat histories are named after the peer, so no name may leave the
+	// chats folder or hide in it
+	for _, name := range []string{".", "..", "../data/contacts", "a/b", `a\b`, `..\x`, ".hidden", "/etc/passwd"} {
+		if ValidateExistingUsername(name) == nil {
+			t.Errorf("%q accepted", name)
+		}
+		p := must(Chat(name, "bob", models.ChatPayload{Message: "hi"}))
+		if _, err := DecodeChat(wire(t, p)); !errors.Is(err, ErrInvalid) {
+			t.Errorf("chat from %q: %v", name, err)
+		}
+	}
+	if ValidateExistingUsername("a..b") != nil {
+		t.Error("dots inside a name rejected")
+	}
+	if ValidateExistingUsername(strings.Repeat("é", MaxUsernameLen)) != nil {
+		t.Error("length counted in bytes, not characters")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	long := strings.Repeat("é", MaxTextBytes)
+	text, err := DecodeText(wire(t, System(long)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(text) > MaxTextBytes || !strings.HasPrefix(long, text) {
+		t.Fatalf("truncated to %d bytes", len(text))
+	}
+}
+
+// FuzzDecodePacket feeds arbitrary frames to every decoder. None may
+// panic, and whatever one accepts must survive being encoded again.
+func FuzzDecodePacket(f *testing.F) {
+	seeds := []models.Packet{
+		Hello(models.NewHello("seed", nil, nil)),
+		System("seed"),
+		Ack(testID, models.AckDelivered, ""),
+		GroupInfo(models.Group{ID: testGroup, Name: "g", Owner: "alice", Members: []string{"alice"}}),
+		GroupWelcome(models.HandshakePayload{Group: testGroup, Data: json.RawMessage(`{}`)}),
+		BlockList([]string{"bob"}),
+	}
+	for _, p := range []func() (models.Packet, error){
+		func() (models.Packet, error) { return Auth("alice", testSignature) },
+		func() (models.Packet, error) {
+			return Chat("alice", "bob", models.ChatPayload{Message: "hi", Expires: 5})
+		},
+		func() (models.Packet, error) {
+			return Edit("alice", "bob", false, testID, models.ChatPayload{Message: "hi"})
+		},
+		func() (models.Packet, error) { return Reaction("alice", "bob", testID, "🎉", false) },
+		func() (models.Packet, error) {
+			return GroupReaction("alice", testGroup, testID, models.ChatPayload{Message: "c2VhbGVk", Suite: models.SuiteGroup}, false)
+		},
+		func() (models.Packet, error) { return GroupCreate("g", []string{"bob"}) },
+		func() (models.Packet, error) {
+			return GroupCommit("alice", models.HandshakePayload{Group: testGroup, Data: json.RawMessage(`{"a":1}`)})
+		},
+		func() (models.Packet, error) { return GroupSync(testGroup, 2, true) },
+	} {
+		packet, err := p()
+		if err != nil {
+			f.Fatal(err)
+		}
+		seeds = append(seeds, packet)
+	}
+	for _, p := range seeds {
+		data, _ := json.Marshal(p)
+		f.Add(data)
+	}
+	f.Add([]byte(`{"type":"chat","to":"bob","payload":{"message":"\u0000"}}`))
+	f.Add([]byte(`{"type":"group_commit","payload":null}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var p models.Packet
+		if json.Unmarshal(data, &p) != nil {
+			return
+		}
+		decode, ok := decoders[p.Type]
+		if !ok {
+			return
+		}
+		v, err := decode(p)
+		if err != nil {
+			if !errors.Is(err, ErrInvalid) {
+				t.Fatalf("%s: error %v does not wrap ErrInvalid", p.Type, err)
+			}
+			return
+		}
+
+		again := newPacket(p.Type, v)
+		again.ID, again.From, again.To = p.ID, p.From, p.To
+		again = wire(t, again)
+		w, err := decode(again)
+		if err != nil {
+			t.Fatalf("%s: accepted %s but not its own encoding %s: %v", p.Type, p.Payload, again.Payload, err)
+		}
+		first, _ := json.Marshal(v)
+		second, _ := json.Marshal(w)
+		if !bytes.Equal(first, second) {
+			t.Fatalf("%s: %s changed to %s", p.Type, first, second)
+		}
+	})
+}
+
+// FuzzChatRoundTrip checks that any chat the constructor accepts arrives
+// unchanged, whatever its text holds.
+func FuzzChatRoundTrip(f *testing.F) {
+	f.Add("bob", "hello", "", int64(0))
+	f.Add("bob", `"}, "type": "auth", "x": {"`, testID, int64(60))
+	f.Add("John Smith", "line\nbreak and \\ backslash", "", int64(MaxExpirySeconds))
+	f.Fuzz(func(t *testing.T, to, message, replyTo string, expires int64) {
+		chat := models.ChatPayload{Message: message, ReplyTo: replyTo, Expires: expires}
+		p, err := Chat("alice", to, chat)
+		if err != nil {
+			return
+		}
+		got, err := DecodeChat(wire(t, p))
+		if err != nil {
+			t.Fatalf("constructed chat rejected: %v", err)
+		}
+		if got != chat {
+			t.Fatalf("got %#v, want %#v", got, chat)
+		}
+	})
+}
+
+// FuzzTextRoundTrip checks relay notices, which carry arbitrary error
+// text, always decode to their truncated text.
+func FuzzTextRoundTrip(f *testing.F) {
+	f.Add(`plain`)
+	f.Add(`"quoted" \ and` + "\n\t")
+	f.Add(strings.Repeat("€", MaxTextBytes))
+	f.Fuzz(func(t *testing.T, msg string) {
+		if msg == "" || !utf8.ValidString(msg) {
+			return
+		}
+		got, err := DecodeText(wire(t, Error(msg)))
+		if err != nil {
+			t.Fatalf("notice rejected: %v", err)
+		}
+		if got != truncate(msg) {
+			t.Fatalf("got %q, want %q", got, truncate(msg))
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// Longest chat body accepted, ciphertext included.
+	MaxChatBytes = 128 * 1024
+
+	// Longest system or error text.
+	MaxTextBytes = 1024
+
+	// Usernames are stored in a VARCHAR(50).
+	MinUsernameLen = 2
+	MaxUsernameLen = 50
+
+	// Challenge nonces are 32 random bytes, hex encoded.
+	challengeHexLen = 64
+
+	// Message IDs are 16 random bytes, hex encoded.
+	messageIDHexLen = 32
+
+	// Group names are stored in a VARCHAR(64).
+	MaxGroupNameBytes = 64
+
+	// Most members a group can be created with.
+	MaxGroupMembers = 256
+
+	// Largest group commit or welcome, welcomes included. Clients split
+	// big membership changes over several commits to stay under it.
+	MaxHandshakeBytes = 384 * 1024
+
+	// Longest reaction; enough for an emoji ZWJ sequence.
+	MaxEmojiBytes = 32
+
+	// Longest disappearing message timer, in seconds: four weeks.
+	MaxExpirySeconds = 4 * 7 * 24 * 60 * 60
+)
+
+// ErrInvalid is wrapped by every validation failure.
+var ErrInvalid = errors.New("invalid packet")
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Group IDs are UUIDs assigned by the relay's database.
+var groupIDPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// ValidateUsername checks the handle format new accounts must use. Its
+// errors are meant for people, so unlike the packet checks they do not
+// wrap ErrInvalid.
+func ValidateUsername(username string) error {
+	if n := len(username); n < MinUsernameLen || n > MaxUsernameLen {
+		return fmt.Errorf("username must be %d-%d characters", MinUsernameLen, MaxUsernameLen)
+	}
+	if !usernamePattern.MatchString(username) {
+		return fmt.Errorf("username may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit")
+	}
+	return nil
+}
+
+// ValidateExistingUsername checks what any stored username satisfies: up
+// to MaxUsernameLen characters of UTF-8 without control characters, and
+// usable as a file name, as chat histories are named after the peer: no
+// path separators and no leading dot. Accounts registered before
+// ValidateUsername existed need not match its format, so packets and
+// restores, which only name existing accounts, use this instead.
+func ValidateExistingUsername(username string) error {
+	if username == "" {
+		return fmt.Errorf("username is required")
+	}
+	if !utf8.ValidString(username) {
+		return fmt.Errorf("username is not valid UTF-8")
+	}
+	if utf8.RuneCountInString(username) > MaxUsernameLen {
+		return fmt.Errorf("username exceeds %d characters", MaxUsernameLen)
+	}
+	for _, r := range username {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("username contains control characters")
+		}
+		if r == '/' || r == '\\' {
+			return fmt.Errorf("username contains a path separator")
+		}
+	}
+	if username[0] == '.' {
+		return fmt.Errorf("username starts with a dot")
+	}
+	return nil
+}
+
+// validateUser applies ValidateExistingUsername to a packet field.
+func validateUser(field, username string) error {
+	if err := ValidateExistingUsername(username); err != nil {
+		return invalid("%s: %v", field, err)
+	}
+	return nil
+}
+
+func validateGroupID(field, id string) error {
+	if !groupIDPattern.MatchString(id) {
+		return invalid("%s is not a group ID", field)
+	}
+	return nil
+}
+
+func validateText(field, s string, max int) error {
+	if s == "" {
+		return invalid("%s is required", field)
+	}
+	if len(s) > max {
+		return invalid("%s exceeds %d bytes", field, max)
+	}
+	if !utf8.ValidString(s) {
+		return invalid("%s is not valid UTF-8", field)
+	}
+	return nil
+}
+
+// ValidateEmoji accepts a short token without spaces or control
+// characters, as sent in the clear or opened from a sealed group
+// reaction. Which emoji to offer is up to clients.
+func ValidateEmoji(s string) error {
+	if err := validateText("emoji", s, MaxEmojiBytes); err != nil {
+		return err
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return invalid("emoji contains whitespace or control characters")
+		}
+	}
+	return nil
+}
+
+func validateExpiry(seconds int64) error {
+	if seconds < 0 || seconds > MaxExpirySeconds {
+		return invalid("expiry must be 0-%d seconds", MaxExpirySeconds)
+	}
+	return nil
+}
+
+func validateHex(field, s string, size int) error {
+	if len(s) != size {
+		return invalid("%s must be %d hex characters", field, size)
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return invalid("%s is not hex", field)
+	}
+	return nil
+}
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
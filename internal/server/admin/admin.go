@@ -0,0 +1,169 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"syncra/internal/models"
+	"syncra/internal/server/database"
+	"syncra/internal/server/websocket"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Default page size for user listings.
+const defaultLimit = 50
+
+// StatsResponse is returned by GET /admin/stats.
+type StatsResponse struct {
+	websocket.Stats
+	RegisteredUsers int `json:"registered_users"`
+}
+
+// UserResponse is returned by GET /admin/users/{username}.
+type UserResponse struct {
+	*models.User
+	Online bool `json:"online"`
+}
+
+// ErrorResponse is the body of every non-2xx reply.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewHandler serves the operator API. Every request must carry
+// "Authorization: Bearer <token>".
+func NewHandler(hub *websocket.Hub, db *database.DB, token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /admin/stats", func(w http.ResponseWriter, r *http.Request) {
+		n, err := db.CountUsers(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, StatsResponse{Stats: hub.Stats(), RegisteredUsers: n})
+	})
+
+	mux.HandleFunc("GET /admin/users", func(w http.ResponseWriter, r *http.Request) {
+		limit := queryInt(r, "limit", defaultLimit)
+		offset := queryInt(r, "offset", 0)
+		users, err := db.ListUsers(r.Context(), limit, offset)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if users == nil {
+			users = []*models.User{}
+		}
+		writeJSON(w, http.StatusOK, users)
+	})
+
+	mux.HandleFunc("GET /admin/users/{username}", func(w http.ResponseWriter, r *http.Request) {
+		username := r.PathValue("username")
+		user, err := db.GetUserByUsername(r.Context(), username)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		_, online := hub.GetClient(username)
+		writeJSON(w, http.StatusOK, UserResponse{User: user, Online: online})
+	})
+
+	mux.HandleFunc("POST /admin/users/{username}/ban", func(w http.ResponseWriter, r *http.Request) {
+		username := r.PathValue("username")
+		if err := db.SetBanned(r.Context(), username, true); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		hub.Kick(username, "account suspended")
+		slog.Info("admin: user banned", "user", username)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /admin/users/{username}/unban", func(w http.ResponseWriter, r *http.Request) {
+		username := r.PathValue("username")
+		if err := db.SetBanned(r.Context(), username, false); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		slog.Info("admin: user unbanned", "user", username)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("DELETE /admin/users/{username}", func(w http.ResponseWriter, r *http.Request) {
+		username := r.PathValue("username")
+		if _, err := db.GetUserByUsername(r.Context(), username); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		if err := db.DeleteUser(r.Context(), username); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		hub.Kick(username, "account deleted")
+		slog.Info("admin: user deleted", "user", username)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /admin/sessions", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, hub.Sessions())
+	})
+
+	mux.HandleFunc("DELETE /admin/sessions/{username}", func(w http.ResponseWriter, r *http.Request) {
+		reason := r.URL.Query().Get("reason")
+		if reason == "" {
+			reason = "disconnected by operator"
+		}
+		if !hub.Kick(r.PathValue("username"), reason) {
+			writeError(w, http.StatusNotFound, errors.New("no active session"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return requireToken(token, mux)
+}
+
+func requireToken(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(got, want) != 1 {
+			slog.Warn("admin: unauthorized request", "remote", r.RemoteAddr, "path", r.URL.Path)
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func queryInt(r *http.Request, key string, def int) int {
+	v, err := strconv.Atoi(strings.TrimSpace(r.URL.Query().Get(key)))
+	if err != nil || v < 0 {
+		return def
+	}
+	return v
+}
+
+func writeDBError(w http.ResponseWriter, err error) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeError(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, ErrorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SetBlocked blocks or unblocks target for username. It returns
+// pgx.ErrNoRows if target does not exist.
+func (db *DB) SetBlocked(ctx context.Context, username, target string, blocked bool) error {
+	query := `
+		DELETE FROM blocks
+		WHERE user_id = (SELECT id FROM users WHERE username = $1)
+		AND blocked_id = (SELECT id FROM users WHERE username = $2)
+	`
+	if blocked {
+		query = `
+			INSERT INTO blocks (user_id, blocked_id)
+			SELECT u.id, b.id FROM users u, users b WHERE u.username = $1 AND b.username = $2
+			ON CONFLICT DO NOTHING
+		`
+	}
+	if _, err := db.Pool.Exec(ctx, query, username, target); err != nil {
+		return err
+	}
+	taken, err := db.IsUsernameTaken(ctx, target)
+	if err != nil {
+		return err
+	}
+	if !taken {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// BlockedUsers lists the usernames username has blocked
+func (db *DB) BlockedUsers(ctx context.Context, username string) ([]string, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT b.username FROM blocks k
+		JOIN users u ON u.id = k.user_id
+		JOIN users b ON b.id = k.blocked_id
+		WHERE u.username = $1 ORDER BY b.username
+	`, username)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
@@ -0,0 +1,242 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"syncra/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrStaleEpoch is returned when a commit's epoch has already been taken.
+var ErrStaleEpoch = errors.New("stale group epoch")
+
+// CreateGroup inserts a group owned by owner together with its members.
+// Unknown member usernames are skipped; the returned group lists who was
+// actually added.
+func (db *DB) CreateGroup(ctx context.Context, name, owner string, members []string) (*models.Group, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id string
+	query := `
+		INSERT INTO groups (id, name, owner_id, created_at)
+		SELECT gen_random_uuid(), $1, id, NOW() FROM users WHERE username = $2
+		RETURNING id
+	`
+	if err := tx.QueryRow(ctx, query, name, owner).Scan(&id); err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO group_members (group_id, user_id)
+		SELECT $1, id FROM users WHERE username = ANY($2)
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := tx.Exec(ctx, query, id, append([]string{owner}, members...)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return db.GetGroup(ctx, id)
+}
+
+// GetGroup retrieves a group and its member list
+func (db *DB) GetGroup(ctx context.Context, id string) (*models.Group, error) {
+	query := `
+		SELECT g.id, g.name, u.username, g.created_at
+		FROM groups g JOIN users u ON u.id = g.owner_id
+		WHERE g.id = $1
+	`
+	g := &models.Group{}
+	if err := db.Pool.QueryRow(ctx, query, id).Scan(&g.ID, &g.Name, &g.Owner, &g.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Pool.Query(ctx, `
+		SELECT u.username, COALESCE(u.public_key, '') FROM group_members gm JOIN users u ON u.id = gm.user_id
+		WHERE gm.group_id = $1 ORDER BY u.username
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	g.Keys = make(map[string]string)
+	var username, key string
+	_, err = pgx.ForEachRow(rows, []any{&username, &key}, func() error {
+		g.Members = append(g.Members, username)
+		if key != "" {
+			g.Keys[username] = key
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query = `SELECT COALESCE(MAX(epoch) + 1, 0) FROM group_commits WHERE group_id = $1`
+	if err := db.Pool.QueryRow(ctx, query, id).Scan(&g.Epoch); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// IsGroupMember reports whether username belongs to a group
+func (db *DB) IsGroupMember(ctx context.Context, groupID, username string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM group_members gm JOIN users u ON u.id = gm.user_id
+			WHERE gm.group_id = $1 AND u.username = $2
+		)
+	`
+	var member bool
+	err := db.Pool.QueryRow(ctx, query, groupID, username).Scan(&member)
+	return member, err
+}
+
+// GroupsForUser lists every group username belongs to
+func (db *DB) GroupsForUser(ctx context.Context, username string) ([]*models.Group, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT gm.group_id FROM group_members gm JOIN users u ON u.id = gm.user_id
+		WHERE u.username = $1
+	`, username)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]*models.Group, 0, len(ids))
+	for _, id := range ids {
+		g, err := db.GetGroup(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// AddGroupMember adds username to a group. It returns pgx.ErrNoRows if the
+// user does not exist.
+func (db *DB) AddGroupMember(ctx context.Context, groupID, username string) error {
+	query := `
+		INSERT INTO group_members (group_id, user_id)
+		SELECT $1, id FROM users WHERE username = $2
+		ON CONFLICT DO NOTHING
+	`
+	tag, err := db.Pool.Exec(ctx, query, groupID, username)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		// Either unknown, or already a member
+		taken, err := db.IsUsernameTaken(ctx, username)
+		if err != nil {
+			return err
+		}
+		if !taken {
+			return pgx.ErrNoRows
+		}
+	}
+	return nil
+}
+
+// RemoveGroupMember removes username from a group
+func (db *DB) RemoveGroupMember(ctx context.Context, groupID, username string) error {
+	query := `
+		DELETE FROM group_members
+		WHERE group_id = $1 AND user_id = (SELECT id FROM users WHERE username = $2)
+	`
+	if _, err := db.Pool.Exec(ctx, query, groupID, username); err != nil {
+		return err
+	}
+	// A stale welcome would let them back in if re-added later
+	query = `
+		DELETE FROM group_welcomes
+		WHERE group_id = $1 AND user_id = (SELECT id FROM users WHERE username = $2)
+	`
+	_, err := db.Pool.Exec(ctx, query, groupID, username)
+	return err
+}
+
+// GroupCommit is a stored key agreement commit
+type GroupCommit struct {
+	Epoch  uint64
+	Sender string
+	Data   []byte
+}
+
+// AddGroupCommit stores a commit for epoch together with the welcomes it
+// carries, keyed by username. It returns ErrStaleEpoch unless epoch is
+// the next one for the group, so concurrent commits have one winner.
+func (db *DB) AddGroupCommit(ctx context.Context, groupID string, epoch uint64, sender string, data []byte, welcomes map[string][]byte) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		INSERT INTO group_commits (group_id, epoch, sender_id, data)
+		SELECT $1, $2, id, $4 FROM users WHERE username = $3
+		AND $2 = (SELECT COALESCE(MAX(epoch) + 1, 0) FROM group_commits WHERE group_id = $1)
+		ON CONFLICT DO NOTHING
+	`
+	tag, err := tx.Exec(ctx, query, groupID, epoch, sender, data)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrStaleEpoch
+	}
+
+	query = `
+		INSERT INTO group_welcomes (group_id, user_id, epoch, data)
+		SELECT $1, id, $3, $4 FROM users WHERE username = $2
+		ON CONFLICT (group_id, user_id) DO UPDATE SET epoch = EXCLUDED.epoch, data = EXCLUDED.data
+	`
+	for username, w := range welcomes {
+		// Welcomes join at the epoch the commit creates
+		if _, err := tx.Exec(ctx, query, groupID, username, epoch+1, w); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// GroupCommitsSince lists a group's commits from epoch on, oldest first
+func (db *DB) GroupCommitsSince(ctx context.Context, groupID string, epoch uint64) ([]GroupCommit, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT c.epoch, COALESCE(u.username, ''), c.data
+		FROM group_commits c LEFT JOIN users u ON u.id = c.sender_id
+		WHERE c.group_id = $1 AND c.epoch >= $2 ORDER BY c.epoch
+	`, groupID, epoch)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (GroupCommit, error) {
+		var c GroupCommit
+		err := row.Scan(&c.Epoch, &c.Sender, &c.Data)
+		return c, err
+	})
+}
+
+// GroupWelcome returns the welcome stored for username and the epoch it
+// joins at. It returns pgx.ErrNoRows if there is none.
+func (db *DB) GroupWelcome(ctx context.Context, groupID, username string) (uint64, []byte, error) {
+	query := `
+		SELECT w.epoch, w.data FROM group_welcomes w JOIN users u ON u.id = w.user_id
+		WHERE w.group_id = $1 AND u.username = $2
+	`
+	var epoch uint64
+	var data []byte
+	err := db.Pool.QueryRow(ctx, query, groupID, username).Scan(&epoch, &data)
+	return epoch, data, err
+}
@@ -3,8 +3,31 @@ package database
 import (
 	"context"
 	"syncra/internal/models"
+
+	"github.com/jackc/pgx/v5"
 )
 
+// userColumns is the column list scanned by scanUser.
+const userColumns = `id, username, full_name, COALESCE(public_key, ''), public_key_hash, created_at, banned_at`
+
+// scanUser reads a row selected with userColumns.
+func scanUser(row pgx.Row) (*models.User, error) {
+	user := &models.User{}
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.FullName,
+		&user.PublicKey,
+		&user.PublicKeyHash,
+		&user.CreatedAt,
+		&user.BannedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 // CreateUser inserts a new user into the database
 func (db *DB) CreateUser(ctx context.Context, user *models.User) error {
 	query := `
@@ -32,25 +55,8 @@ func (db *DB) IsUsernameTaken(ctx context.Context, username string) (bool, error
 
 // GetUserByUsername retrieves a user by their username
 func (db *DB) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
-	user := &models.User{}
-	query := `
-		SELECT id, username, full_name, COALESCE(public_key, ''), public_key_hash, created_at
-		FROM users
-		WHERE username = $1
-	`
-	err := db.Pool.QueryRow(ctx, query, username).Scan(
-		&user.ID,
-		&user.Username,
-		&user.FullName,
-		&user.PublicKey,
-		&user.PublicKeyHash,
-		&user.CreatedAt,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-	return user, nil
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1`
+	return scanUser(db.Pool.QueryRow(ctx, query, username))
 }
 
 // UpdateFullName updates the full name of a user in the database
@@ -62,13 +68,42 @@ func (db *DB) UpdateFullName(ctx context.Context, username, fullName string) err
 
 // SearchUsers searches for users by username (partial match)
 func (db *DB) SearchUsers(ctx context.Context, query string) ([]*models.User, error) {
-	sql := `
-		SELECT id, username, full_name, COALESCE(public_key, ''), public_key_hash, created_at
-		FROM users
-		WHERE username ILIKE $1
-		LIMIT 10
-	`
-	rows, err := db.Pool.Query(ctx, sql, "%"+query+"%")
+	sql := `SELECT ` + userColumns + ` FROM users WHERE username ILIKE $1 LIMIT 10`
+	return db.queryUsers(ctx, sql, "%"+query+"%")
+}
+
+// ListUsers pages through every registered user, oldest first
+func (db *DB) ListUsers(ctx context.Context, limit, offset int) ([]*models.User, error) {
+	sql := `SELECT ` + userColumns + ` FROM users ORDER BY created_at LIMIT $1 OFFSET $2`
+	return db.queryUsers(ctx, sql, limit, offset)
+}
+
+// CountUsers returns the number of registered users
+func (db *DB) CountUsers(ctx context.Context) (int, error) {
+	var n int
+	err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM users`).Scan(&n)
+	return n, err
+}
+
+// SetBanned suspends or reinstates a user. It returns pgx.ErrNoRows if the
+// user does not exist.
+func (db *DB) SetBanned(ctx context.Context, username string, banned bool) error {
+	query := `UPDATE users SET banned_at = NULL WHERE username = $1`
+	if banned {
+		query = `UPDATE users SET banned_at = COALESCE(banned_at, NOW()) WHERE username = $1`
+	}
+	tag, err := db.Pool.Exec(ctx, query, username)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (db *DB) queryUsers(ctx context.Context, sql string, args ...any) ([]*models.User, error) {
+	rows, err := db.Pool.Query(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -76,21 +111,13 @@ func (db *DB) SearchUsers(ctx context.Context, query string) ([]*models.User, er
 
 	var users []*models.User
 	for rows.Next() {
-		user := &models.User{}
-		err := rows.Scan(
-			&user.ID,
-			&user.Username,
-			&user.FullName,
-			&user.PublicKey,
-			&user.PublicKeyHash,
-			&user.CreatedAt,
-		)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		users = append(users, user)
 	}
-	return users, nil
+	return users, rows.Err()
 }
 
 // DeleteUser removes a user from the database
@@ -0,0 +1,69 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"syncra/internal/server/database"
+	"syncra/internal/server/websocket"
+	"time"
+)
+
+// Time allowed for each readiness check.
+const checkTimeout = 2 * time.Second
+
+// New builds the relay's HTTP handler: the WebSocket endpoint plus the
+// liveness and readiness probes used by orchestrators.
+func New(hub *websocket.Hub, db *database.DB) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		websocket.ServeWs(hub, w, r)
+	})
+
+	// Liveness: the process is up and able to serve HTTP.
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, http.StatusOK, nil)
+	})
+
+	// Readiness: we can accept new sessions right now.
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		checks := map[string]string{
+			"database": "ok",
+			"hub":      "ok",
+		}
+		code := http.StatusOK
+
+		if err := db.Pool.Ping(ctx); err != nil {
+			checks["database"] = err.Error()
+			code = http.StatusServiceUnavailable
+		}
+		if hub.Draining() {
+			checks["hub"] = "draining"
+			code = http.StatusServiceUnavailable
+		} else if err := hub.Ping(ctx); err != nil {
+			checks["hub"] = "run loop unresponsive"
+			code = http.StatusServiceUnavailable
+		}
+
+		writeStatus(w, code, checks)
+	})
+
+	return mux
+}
+
+func writeStatus(w http.ResponseWriter, code int, checks map[string]string) {
+	status := "ok"
+	if code != http.StatusOK {
+		status = "unavailable"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks,omitempty"`
+	}{status, checks})
+}
@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"syncra/internal/models"
+	"syncra/internal/protocol"
+	"syncra/internal/server/database"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Block lists live in the database and are cached on each authenticated
+// client, since chats are only ever relayed to online recipients.
+
+// syncBlocks loads the client's block list and sends it a copy.
+func (c *Client) syncBlocks(db *database.DB) {
+	users, err := db.BlockedUsers(context.Background(), c.Username)
+	if err != nil {
+		c.log.Error("failed to load block list", "user", c.Username, "err", err)
+		return
+	}
+	c.setBlocked(users)
+	c.queue(protocol.BlockList(users))
+}
+
+func (c *Client) handleBlock(packet models.Packet) {
+	req, err := protocol.DecodeBlock(packet)
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	if req.Username == c.Username {
+		c.sendError("You can't block yourself")
+		return
+	}
+
+	db, err := database.Connect()
+	if err != nil {
+		c.log.Error("block: database unavailable", "err", err)
+		c.sendError("Internal server error")
+		return
+	}
+	defer db.Close()
+
+	err = db.SetBlocked(context.Background(), c.Username, req.Username, req.Blocked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.sendError("User not found")
+		return
+	}
+	if err != nil {
+		c.log.Error("failed to update block list", "err", err)
+		c.sendError("Internal server error")
+		return
+	}
+	c.log.Info("block list changed", "user", c.Username, "target", req.Username, "blocked", req.Blocked)
+	c.syncBlocks(db)
+}
+
+func (c *Client) setBlocked(users []string) {
+	blocked := make(map[string]bool, len(users))
+	for _, u := range users {
+		blocked[u] = true
+	}
+	c.blockedMu.Lock()
+	c.blocked = blocked
+	c.blockedMu.Unlock()
+}
+
+// blocks reports whether this client blocked username.
+func (c *Client) blocks(username string) bool {
+	c.blockedMu.RLock()
+	defer c.blockedMu.RUnlock()
+	return c.blocked[username]
+}
@@ -5,12 +5,15 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"sort"
 	"strings"
+	"sync"
+	"syncra/internal/codec"
 	"syncra/internal/models"
+	"syncra/internal/protocol"
 	"syncra/internal/server/database"
 	"time"
 
@@ -29,11 +32,19 @@ const (
 
 	// Maximum message size allowed from peer.
 	maxMessageSize = 512 * 1024 // 512KB
+
+	// Time allowed to flush queued packets when the relay shuts down.
+	drainWait = 5 * time.Second
 )
 
+// serverHello is the relay's side of protocol negotiation. The relay is
+// blind to payloads, so it has no opinion on encryption suites.
+var serverHello = models.NewHello("syncra-relay", []string{models.FeatureReceipts}, nil)
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    codec.Subprotocols(),
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
@@ -46,9 +57,12 @@ type Client struct {
 	// The websocket connection.
 	Conn *websocket.Conn
 
-	// Buffered channel of outbound messages.
+	// Buffered channel of outbound messages, already encoded with codec.
 	send chan []byte
 
+	// Wire encoding picked via the WebSocket subprotocol
+	codec codec.Codec
+
 	// Username of the connected identity
 	Username string
 
@@ -57,6 +71,27 @@ type Client struct {
 
 	// Challenge sent to this client
 	Challenge string
+
+	// When the connection was upgraded
+	ConnectedAt time.Time
+
+	// Random per-connection identifier for correlating log lines
+	ID string
+
+	// Logger carrying the connection ID and remote address
+	log *slog.Logger
+
+	// Negotiated protocol; Version is 0 until the client's hello arrives
+	Protocol models.HelloPayload
+
+	// Usernames this client blocked; read by other clients' handleChat
+	blocked   map[string]bool
+	blockedMu sync.RWMutex
+
+	// Closed by reject to make WritePump flush and hang up
+	closing     chan struct{}
+	closeOnce   sync.Once
+	closeReason string
 }
 
 func (c *Client) ReadPump() {
@@ -67,39 +102,109 @@ func (c *Client) ReadPump() {
 	c.Conn.SetReadLimit(maxMessageSize)
 	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.Conn.SetPongHandler(func(string) error { c.Conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	rejected := false
 	for {
 		_, msgData, err := c.Conn.ReadMessage()
 		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				c.log.Warn("connection lost", "err", err)
+			} else {
+				c.log.Debug("connection closed", "err", err)
+			}
 			break
 		}
 
-		var packet models.Packet
-		if err := json.Unmarshal(msgData, &packet); err != nil {
-			continue
+		packets, err := c.codec.UnmarshalFrame(msgData)
+		if err != nil {
+			c.log.Warn("dropping malformed packets", "err", err, "bytes", len(msgData))
 		}
 
-		switch packet.Type {
-		case models.TypeAuth:
-			var auth models.AuthPayload
-			if err := json.Unmarshal(packet.Payload, &auth); err != nil {
-				c.sendError("Invalid auth payload")
-				continue
+		for _, packet := range packets {
+			// Keep reading after a rejection so WritePump can deliver the
+			// error and close frame before the connection is torn down.
+			if rejected {
+				break
 			}
-			c.handleAuth(auth)
+			rejected = c.dispatch(packet)
+		}
+	}
+}
 
-		case models.TypeChat:
-			if !c.Authenticated {
-				c.sendError("Unauthorized")
-				continue
-			}
-			c.handleChat(packet)
+// dispatch handles one inbound packet. It returns true once the client has
+// been rejected and nothing further from it should be processed.
+func (c *Client) dispatch(packet models.Packet) bool {
+	switch packet.Type {
+	case models.TypeHello:
+		if c.Protocol.Version != 0 {
+			c.sendError("Protocol already negotiated")
+			return false
+		}
+		offer, err := protocol.DecodeHello(packet)
+		if err != nil {
+			c.reject(err.Error())
+			return true
+		}
+		agreed, err := models.Negotiate(serverHello, offer)
+		if err != nil {
+			c.log.Info("hello rejected", "agent", offer.Agent, "version", offer.Version, "err", err)
+			c.reject(err.Error())
+			return true
+		}
+		c.Protocol = agreed
+		c.log.Debug("protocol negotiated", "agent", offer.Agent, "version", agreed.Version, "features", agreed.Features)
+		c.queue(protocol.Hello(agreed))
+
+	case models.TypeAuth:
+		if c.Protocol.Version == 0 {
+			c.log.Info("auth without hello", "version", packet.Version)
+			c.reject(fmt.Sprintf("Unsupported client: protocol negotiation required (relay speaks v%d-v%d); please upgrade",
+				models.MinProtocolVersion, models.ProtocolVersion))
+			return true
+		}
+		auth, err := protocol.DecodeAuth(packet)
+		if err != nil {
+			c.log.Warn("invalid auth payload", "err", err)
+			c.sendError(err.Error())
+			return false
 		}
+		c.handleAuth(auth)
+
+	case models.TypeChat:
+		if !c.Authenticated {
+			c.sendError("Unauthorized")
+			return false
+		}
+		c.handleChat(packet)
+
+	case models.TypeEdit, models.TypeDelete, models.TypeReaction, models.TypeExpiry:
+		if !c.Authenticated {
+			c.sendError("Unauthorized")
+			return false
+		}
+		c.handleUpdate(packet)
+
+	case models.TypeGroupCreate, models.TypeGroupInvite, models.TypeGroupRemove, models.TypeGroupChat,
+		models.TypeGroupCommit, models.TypeGroupSync:
+		if !c.Authenticated {
+			c.sendError("Unauthorized")
+			return false
+		}
+		c.handleGroup(packet)
+
+	case models.TypeBlock:
+		if !c.Authenticated {
+			c.sendError("Unauthorized")
+			return false
+		}
+		c.handleBlock(packet)
 	}
+	return false
 }
 
 func (c *Client) handleAuth(auth models.AuthPayload) {
 	db, err := database.Connect()
 	if err != nil {
+		c.log.Error("auth: database unavailable", "err", err)
 		c.sendError("Internal server error")
 		return
 	}
@@ -107,37 +212,121 @@ func (c *Client) handleAuth(auth models.AuthPayload) {
 
 	user, err := db.GetUserByUsername(context.Background(), auth.Username)
 	if err != nil {
+		c.log.Info("auth rejected", "user", auth.Username, "reason", "unknown user")
 		c.sendError("User not found")
 		return
 	}
 
 	sig, err := hex.DecodeString(auth.Signature)
 	if err != nil || len(sig) != ed25519.SignatureSize {
+		c.log.Info("auth rejected", "user", auth.Username, "reason", "malformed signature")
 		c.sendError("Invalid signature format or size")
 		return
 	}
 
 	pubKey, err := hex.DecodeString(user.PublicKey)
 	if err != nil || len(pubKey) != ed25519.PublicKeySize {
+		c.log.Error("auth: stored public key is invalid", "user", auth.Username)
 		c.sendError("Server error: invalid public key stored (may be legacy user)")
 		return
 	}
 
 	if !ed25519.Verify(pubKey, []byte(c.Challenge), sig) {
+		c.log.Warn("auth rejected", "user", auth.Username, "reason", "bad signature")
 		c.sendError("Invalid signature")
 		return
 	}
 
+	if user.BannedAt != nil {
+		c.log.Warn("auth rejected", "user", auth.Username, "reason", "banned")
+		c.sendError("Account suspended")
+		return
+	}
+
 	c.Authenticated = true
 	c.Username = auth.Username
 	c.Hub.authenticate <- c
-	c.sendSystem("Authenticated")
+	c.sendSystem(protocol.Authenticated)
+	c.syncBlocks(db)
+	c.syncGroups(db)
 }
 
 func (c *Client) handleChat(packet models.Packet) {
+	// The relay can't read the message, but it can refuse malformed envelopes
+	packet.From = c.Username
+	chat, err := protocol.DecodeChat(packet)
+	if err != nil {
+		c.log.Info("chat rejected", "to", packet.To, "err", err)
+		c.nack(packet, models.AckRejected, err.Error())
+		return
+	}
+	if protocol.Expired(packet, chat, time.Now()) {
+		// Retried for too long while the recipient was away
+		c.log.Debug("expired chat dropped", "to", packet.To, "id", packet.ID)
+		c.nack(packet, models.AckRejected, protocol.MessageExpired)
+		return
+	}
+	c.relayDirect(packet)
+}
+
+// handleUpdate relays an edit, retraction, reaction or timer change the
+// same way as the messages it refers to. Only the recipients can check
+// that the message exists and, for edits, that it came from its author.
+func (c *Client) handleUpdate(packet models.Packet) {
+	packet.From = c.Username
+	var group bool
+	var err error
+	switch packet.Type {
+	case models.TypeEdit:
+		var e models.EditPayload
+		e, err = protocol.DecodeEdit(packet)
+		group = e.Group
+	case models.TypeDelete:
+		var d models.DeletePayload
+		d, err = protocol.DecodeDelete(packet)
+		group = d.Group
+	case models.TypeReaction:
+		var r models.ReactionPayload
+		r, err = protocol.DecodeReaction(packet)
+		group = r.Group
+	default:
+		var x models.ExpiryPayload
+		x, err = protocol.DecodeExpiry(packet)
+		group = x.Group
+	}
+	if err != nil {
+		c.log.Info("message update rejected", "type", packet.Type, "to", packet.To, "err", err)
+		c.nack(packet, models.AckRejected, err.Error())
+		return
+	}
+	if group {
+		db, err := database.Connect()
+		if err != nil {
+			c.log.Error("update: database unavailable", "err", err)
+			c.nack(packet, models.AckRejected, "Internal server error")
+			return
+		}
+		defer db.Close()
+		c.relayGroup(db, packet)
+	} else {
+		c.relayDirect(packet)
+	}
+}
+
+// relayDirect delivers a checked 1:1 packet to its recipient and acks it.
+func (c *Client) relayDirect(packet models.Packet) {
 	target, ok := c.Hub.GetClient(packet.To)
 	if !ok {
-		c.sendError("Recipient offline")
+		c.log.Debug("chat to offline recipient", "to", packet.To)
+		c.nack(packet, models.AckOffline, "Recipient offline")
+		return
+	}
+
+	// Blocked senders get the same ack as everyone else, so a block can't
+	// be probed for; the message just goes nowhere
+	if target.blocks(c.Username) {
+		c.log.Debug("chat to blocking recipient dropped", "to", packet.To)
+		c.ack(packet, models.AckDelivered, "")
 		return
 	}
 
@@ -150,30 +339,60 @@ func (c *Client) handleChat(packet models.Packet) {
 	c.Hub.JoinRoom(roomID, packet.To)
 
 	// Relay the packet
-	packet.From = c.Username
 	packet.Timestamp = time.Now()
-	data, _ := json.Marshal(packet)
-	target.send <- data
+	if n := target.queue(packet); n > 0 {
+		c.Hub.relayed.Add(1)
+		c.log.Debug("chat relayed", "from", c.Username, "to", packet.To, "bytes", n)
+		c.ack(packet, models.AckDelivered, "")
+	}
 }
 
-func (c *Client) sendError(msg string) {
-	p := models.Packet{
-		Type:      models.TypeError,
-		Payload:   json.RawMessage(`"` + msg + `"`),
-		Timestamp: time.Now(),
+// ack sends a delivery receipt for packet if the client negotiated
+// receipts and gave the packet an ID. It reports whether one was sent.
+func (c *Client) ack(packet models.Packet, status, reason string) bool {
+	if packet.ID == "" || !c.Protocol.Has(models.FeatureReceipts) {
+		return false
 	}
-	data, _ := json.Marshal(p)
-	c.send <- data
+	c.queue(protocol.Ack(packet.ID, status, reason))
+	return true
 }
 
-func (c *Client) sendSystem(msg string) {
-	p := models.Packet{
-		Type:      models.TypeSystem,
-		Payload:   json.RawMessage(`"` + msg + `"`),
-		Timestamp: time.Now(),
+// nack reports a failed delivery, as a receipt when the client can retry on
+// one and as a plain error otherwise.
+func (c *Client) nack(packet models.Packet, status, reason string) {
+	if !c.ack(packet, status, reason) {
+		c.sendError(reason)
+	}
+}
+
+// reject reports msg to the client and then closes the connection once the
+// error has been written.
+func (c *Client) reject(msg string) {
+	c.sendError(msg)
+	c.closeOnce.Do(func() {
+		c.closeReason = msg
+		close(c.closing)
+	})
+}
+
+// queue encodes p with this client's codec and hands it to WritePump. It
+// returns the encoded size, or 0 if the packet could not be encoded.
+func (c *Client) queue(p models.Packet) int {
+	data, err := c.codec.Marshal(p)
+	if err != nil {
+		c.log.Error("failed to encode packet", "type", p.Type, "codec", c.codec.Name(), "err", err)
+		return 0
 	}
-	data, _ := json.Marshal(p)
 	c.send <- data
+	return len(data)
+}
+
+func (c *Client) sendError(msg string) {
+	c.queue(protocol.Error(msg))
+}
+
+func (c *Client) sendSystem(msg string) {
+	c.queue(protocol.System(msg))
 }
 
 func (c *Client) WritePump() {
@@ -191,15 +410,19 @@ func (c *Client) WritePump() {
 				return
 			}
 
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
+			// Coalesce whatever else is queued into the same frame; the
+			// codec's framing lets the peer split it back apart.
+			w, err := c.Conn.NextWriter(c.frameType())
 			if err != nil {
+				c.log.Debug("write failed", "err", err)
 				return
 			}
 			w.Write(message)
 
+			delim := c.codec.Delimiter()
 			n := len(c.send)
 			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
+				w.Write(delim)
 				w.Write(<-c.send)
 			}
 
@@ -211,14 +434,54 @@ func (c *Client) WritePump() {
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+		case <-c.Hub.done:
+			c.drain(websocket.CloseGoingAway, "relay shutting down")
+			return
+		case <-c.closing:
+			c.drain(websocket.ClosePolicyViolation, c.closeReason)
+			return
 		}
 	}
 }
 
+// drain flushes whatever is already queued for this client and then sends
+// a close frame with the given code and reason.
+func (c *Client) drain(code int, reason string) {
+	deadline := time.Now().Add(drainWait)
+	c.Conn.SetWriteDeadline(deadline)
+	for n := len(c.send); n > 0; n-- {
+		message, ok := <-c.send
+		if !ok {
+			break
+		}
+		if err := c.Conn.WriteMessage(c.frameType(), message); err != nil {
+			return
+		}
+	}
+	// Close reasons must fit in a control frame
+	if len(reason) > 120 {
+		reason = reason[:120]
+	}
+	c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+}
+
+// frameType is the WebSocket message type used for this client's codec.
+func (c *Client) frameType() int {
+	if c.codec.Binary() {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
 func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if hub.Draining() {
+		http.Error(w, "relay shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
+		slog.Warn("websocket upgrade failed", "remote", r.RemoteAddr, "err", err)
 		return
 	}
 
@@ -227,22 +490,25 @@ func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	rand.Read(challenge)
 	challengeHex := hex.EncodeToString(challenge)
 
+	id := make([]byte, 6)
+	rand.Read(id)
+	connID := hex.EncodeToString(id)
+
 	client := &Client{
-		Hub:       hub,
-		Conn:      conn,
-		send:      make(chan []byte, 256),
-		Challenge: challengeHex,
+		Hub:         hub,
+		Conn:        conn,
+		send:        make(chan []byte, 256),
+		codec:       codec.ForSubprotocol(conn.Subprotocol()),
+		Challenge:   challengeHex,
+		ConnectedAt: time.Now(),
+		ID:          connID,
+		log:         slog.With("conn", connID, "remote", conn.RemoteAddr().String(), "codec", conn.Subprotocol()),
+		closing:     make(chan struct{}),
 	}
 	client.Hub.register <- client
 
 	// Send challenge immediately
-	challengePkg := models.Packet{
-		Type:      models.TypeChallenge,
-		Payload:   json.RawMessage(`"` + challengeHex + `"`),
-		Timestamp: time.Now(),
-	}
-	data, _ := json.Marshal(challengePkg)
-	client.send <- data
+	client.queue(protocol.Challenge(challengeHex))
 
 	go client.WritePump()
 	go client.ReadPump()
@@ -0,0 +1,324 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"syncra/internal/models"
+	"syncra/internal/protocol"
+	"syncra/internal/server/database"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Group membership lives in the database, and who may post, commit, sync
+// or be welcomed is checked there. Each group is mirrored as a hub room of
+// its online members, so posts fan out without listing them from the
+// database. Group keys are agreed
+// by the members themselves: the relay only stores their commits in epoch
+// order and hands out welcomes, without being able to read either.
+
+// groupRoom is the hub room holding a group's members.
+func groupRoom(id string) string {
+	return "group:" + id
+}
+
+// syncGroups joins the rooms of every group the client belongs to and
+// sends it their current state.
+func (c *Client) syncGroups(db *database.DB) {
+	groups, err := db.GroupsForUser(context.Background(), c.Username)
+	if err != nil {
+		c.log.Error("failed to load groups", "user", c.Username, "err", err)
+		return
+	}
+	for _, g := range groups {
+		for _, m := range g.Members {
+			c.Hub.JoinRoom(groupRoom(g.ID), m)
+		}
+		c.queue(protocol.GroupInfo(*g))
+	}
+}
+
+func (c *Client) handleGroup(packet models.Packet) {
+	db, err := database.Connect()
+	if err != nil {
+		c.log.Error("group: database unavailable", "err", err)
+		if packet.Type == models.TypeGroupChat {
+			c.nack(packet, models.AckRejected, "Internal server error")
+		} else {
+			c.sendError("Internal server error")
+		}
+		return
+	}
+	defer db.Close()
+
+	switch packet.Type {
+	case models.TypeGroupChat:
+		c.handleGroupChat(db, packet)
+	case models.TypeGroupCreate:
+		c.handleGroupCreate(db, packet)
+	case models.TypeGroupInvite, models.TypeGroupRemove:
+		c.handleGroupMember(db, packet)
+	case models.TypeGroupCommit:
+		c.handleGroupCommit(db, packet)
+	case models.TypeGroupSync:
+		c.handleGroupSync(db, packet)
+	}
+}
+
+func (c *Client) handleGroupCreate(db *database.DB, packet models.Packet) {
+	req, err := protocol.DecodeGroupCreate(packet)
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	g, err := db.CreateGroup(context.Background(), req.Name, c.Username, req.Members)
+	if err != nil {
+		c.log.Error("failed to create group", "err", err)
+		c.sendError("Could not create group")
+		return
+	}
+	c.log.Info("group created", "group", g.ID, "owner", c.Username, "members", len(g.Members))
+	c.publishGroup(g)
+}
+
+func (c *Client) handleGroupMember(db *database.DB, packet models.Packet) {
+	req, err := protocol.DecodeGroupMember(packet)
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	ctx := context.Background()
+
+	// Non-members can't tell a missing group from one they aren't in
+	g, err := db.GetGroup(ctx, req.Group)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !g.HasMember(c.Username)) {
+		c.sendError("Group not found")
+		return
+	}
+	if err != nil {
+		c.log.Error("failed to load group", "group", req.Group, "err", err)
+		c.sendError("Internal server error")
+		return
+	}
+
+	var removed []string
+	if packet.Type == models.TypeGroupInvite {
+		err = db.AddGroupMember(ctx, g.ID, req.Username)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.sendError("User not found")
+			return
+		}
+	} else {
+		switch {
+		case req.Username == g.Owner:
+			c.sendError("The group owner can't be removed")
+			return
+		case req.Username != c.Username && c.Username != g.Owner:
+			c.sendError("Only the group owner can remove other members")
+			return
+		}
+		err = db.RemoveGroupMember(ctx, g.ID, req.Username)
+		removed = append(removed, req.Username)
+	}
+	if err != nil {
+		c.log.Error("failed to update group", "group", g.ID, "err", err)
+		c.sendError("Internal server error")
+		return
+	}
+
+	updated, err := db.GetGroup(ctx, g.ID)
+	if err != nil {
+		c.log.Error("failed to load group", "group", g.ID, "err", err)
+		return
+	}
+	c.log.Info("group membership changed", "group", g.ID, "by", c.Username, "action", packet.Type, "user", req.Username)
+	// A removed member still hears about it, so their client can drop it
+	c.publishGroup(updated, removed...)
+}
+
+// publishGroup syncs the group's hub room with g and sends g to every
+// online member, plus anyone in extra.
+func (c *Client) publishGroup(g *models.Group, extra ...string) {
+	room := groupRoom(g.ID)
+	for _, u := range c.Hub.GetRoomUsers(room) {
+		if !g.HasMember(u) {
+			c.Hub.LeaveRoom(room, u)
+		}
+	}
+	for _, m := range g.Members {
+		c.Hub.JoinRoom(room, m)
+	}
+
+	info := protocol.GroupInfo(*g)
+	for _, u := range append(g.Members, extra...) {
+		if target, ok := c.Hub.GetClient(u); ok {
+			target.queue(info)
+		}
+	}
+}
+
+func (c *Client) handleGroupChat(db *database.DB, packet models.Packet) {
+	packet.From = c.Username
+	chat, err := protocol.DecodeGroupChat(packet)
+	if err != nil {
+		c.log.Info("group chat rejected", "group", packet.To, "err", err)
+		c.nack(packet, models.AckRejected, err.Error())
+		return
+	}
+	if protocol.Expired(packet, chat, time.Now()) {
+		c.log.Debug("expired group chat dropped", "group", packet.To, "id", packet.ID)
+		c.nack(packet, models.AckRejected, protocol.MessageExpired)
+		return
+	}
+	c.relayGroup(db, packet)
+}
+
+// relayGroup fans a checked group packet out to the group's online members
+// and acks it.
+func (c *Client) relayGroup(db *database.DB, packet models.Packet) {
+	member, err := db.IsGroupMember(context.Background(), packet.To, c.Username)
+	if err != nil {
+		c.log.Error("failed to check group membership", "group", packet.To, "err", err)
+		c.nack(packet, models.AckRejected, "Internal server error")
+		return
+	}
+	if !member {
+		c.nack(packet, models.AckRejected, "Not a member of this group")
+		return
+	}
+	room := groupRoom(packet.To)
+
+	// Fan out to every online member but the sender
+	packet.Timestamp = time.Now()
+	members := c.Hub.GetRoomUsers(room)
+	delivered := 0
+	for _, u := range members {
+		if u == c.Username {
+			continue
+		}
+		target, ok := c.Hub.GetClient(u)
+		if !ok {
+			continue
+		}
+		if n := target.queue(packet); n > 0 {
+			c.Hub.relayed.Add(1)
+			delivered++
+		}
+	}
+	c.log.Debug("group chat relayed", "from", c.Username, "group", packet.To, "members", len(members), "delivered", delivered)
+
+	if delivered == 0 && len(members) > 1 {
+		c.nack(packet, models.AckOffline, "No members online")
+		return
+	}
+	c.ack(packet, models.AckDelivered, "")
+}
+
+func (c *Client) handleGroupCommit(db *database.DB, packet models.Packet) {
+	packet.From = c.Username
+	h, err := protocol.DecodeHandshake(packet)
+	if err != nil {
+		c.log.Info("group commit rejected", "err", err)
+		c.nack(packet, models.AckRejected, err.Error())
+		return
+	}
+	g, err := db.GetGroup(context.Background(), h.Group)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !g.HasMember(c.Username)) {
+		c.nack(packet, models.AckRejected, "Not a member of this group")
+		return
+	}
+	if err != nil {
+		c.log.Error("failed to load group", "group", h.Group, "err", err)
+		c.nack(packet, models.AckRejected, "Internal server error")
+		return
+	}
+	// Offline members are welcomed too; they collect it with a group_sync
+	welcomes := make(map[string][]byte, len(h.Welcomes))
+	for u, w := range h.Welcomes {
+		if !g.HasMember(u) {
+			c.nack(packet, models.AckRejected, "Welcome for a non-member: "+u)
+			return
+		}
+		welcomes[u] = w
+	}
+
+	err = db.AddGroupCommit(context.Background(), h.Group, h.Epoch, c.Username, h.Data, welcomes)
+	if errors.Is(err, database.ErrStaleEpoch) {
+		c.nack(packet, models.AckRejected, protocol.StaleEpoch)
+		return
+	}
+	if err != nil {
+		c.log.Error("failed to store group commit", "group", h.Group, "err", err)
+		c.nack(packet, models.AckRejected, "Internal server error")
+		return
+	}
+	c.log.Info("group commit", "group", h.Group, "epoch", h.Epoch, "by", c.Username, "welcomes", len(welcomes))
+
+	// Members that are offline catch up through group_sync
+	commit, _ := protocol.GroupCommit(c.Username, models.HandshakePayload{Group: h.Group, Epoch: h.Epoch, Data: h.Data})
+	for _, u := range c.Hub.GetRoomUsers(groupRoom(h.Group)) {
+		if u == c.Username {
+			continue
+		}
+		target, ok := c.Hub.GetClient(u)
+		if !ok {
+			continue
+		}
+		if w, ok := h.Welcomes[u]; ok {
+			target.queue(protocol.GroupWelcome(models.HandshakePayload{Group: h.Group, Epoch: h.Epoch + 1, Data: w}))
+		} else {
+			target.queue(commit)
+		}
+	}
+	c.ack(packet, models.AckDelivered, "")
+}
+
+func (c *Client) handleGroupSync(db *database.DB, packet models.Packet) {
+	req, err := protocol.DecodeGroupSync(packet)
+	if err != nil {
+		c.sendError(err.Error())
+		return
+	}
+	ctx := context.Background()
+	member, err := db.IsGroupMember(ctx, req.Group, c.Username)
+	if err != nil {
+		c.log.Error("failed to check group membership", "group", req.Group, "err", err)
+		c.sendError("Internal server error")
+		return
+	}
+	if !member {
+		c.sendError("Group not found")
+		return
+	}
+
+	from := req.Epoch
+	if req.Join {
+		epoch, data, err := db.GroupWelcome(ctx, req.Group, c.Username)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			// Not welcomed yet; a member will commit us in
+			return
+		case err != nil:
+			c.log.Error("failed to load group welcome", "group", req.Group, "err", err)
+			c.sendError("Internal server error")
+			return
+		}
+		c.queue(protocol.GroupWelcome(models.HandshakePayload{Group: req.Group, Epoch: epoch, Data: data}))
+		from = epoch
+	}
+
+	commits, err := db.GroupCommitsSince(ctx, req.Group, from)
+	if err != nil {
+		c.log.Error("failed to load group commits", "group", req.Group, "err", err)
+		c.sendError("Internal server error")
+		return
+	}
+	for _, gc := range commits {
+		p, err := protocol.GroupCommit(gc.Sender, models.HandshakePayload{Group: req.Group, Epoch: gc.Epoch, Data: gc.Data})
+		if err != nil {
+			continue
+		}
+		c.queue(p)
+	}
+}
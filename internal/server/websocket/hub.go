@@ -1,16 +1,42 @@
 package websocket
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
+// Session describes an authenticated connection for operators.
+type Session struct {
+	ID          string    `json:"id"`
+	Username    string    `json:"username"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// Stats is a point-in-time snapshot of the hub.
+type Stats struct {
+	Connections    int       `json:"connections"`
+	Sessions       int       `json:"sessions"`
+	Rooms          int       `json:"rooms"`
+	PacketsRelayed uint64    `json:"packets_relayed"`
+	StartedAt      time.Time `json:"started_at"`
+}
+
 // Hub maintains the set of active clients and broadcasts messages to the
 // clients.
 type Hub struct {
 	// Registered clients.
 	clients map[string]*Client
 
+	// Every live connection, authenticated or not.
+	conns map[*Client]bool
+
 	// Register requests from the clients.
 	register chan *Client
 
@@ -20,9 +46,26 @@ type Hub struct {
 	// Authentication updates
 	authenticate chan *Client
 
+	// Liveness probes answered by the run loop
+	ping chan chan struct{}
+
+	// Closed when the relay starts shutting down
+	done         chan struct{}
+	shutdownOnce sync.Once
+
+	// Closed once shutting down and every connection has gone away. A
+	// connection accepted while draining can empty the hub again.
+	idle     chan struct{}
+	idleOnce sync.Once
+
 	// Rooms map: RoomID -> Set of Usernames
 	rooms map[string]map[string]bool
 
+	// Chat packets handed to a recipient since startup
+	relayed atomic.Uint64
+
+	startedAt time.Time
+
 	mu sync.RWMutex
 }
 
@@ -31,29 +74,40 @@ func NewHub() *Hub {
 		register:     make(chan *Client),
 		unregister:   make(chan *Client),
 		authenticate: make(chan *Client),
+		ping:         make(chan chan struct{}),
+		done:         make(chan struct{}),
+		idle:         make(chan struct{}),
 		clients:      make(map[string]*Client),
+		conns:        make(map[*Client]bool),
 		rooms:        make(map[string]map[string]bool),
+		startedAt:    time.Now(),
 	}
 }
 
 func (h *Hub) Run() {
+	done := h.done
+	draining := false
 	for {
 		select {
-		case <-h.register:
-			log.Printf("New connection pending authentication")
+		case client := <-h.register:
+			h.mu.Lock()
+			h.conns[client] = true
+			h.mu.Unlock()
+			client.log.Debug("connection pending authentication")
 
 		case client := <-h.authenticate:
 			h.mu.Lock()
 			if client.Username != "" {
 				h.clients[client.Username] = client
-				log.Printf("Client authenticated and registered: %s", client.Username)
+				client.log.Info("client authenticated", "user", client.Username)
 			}
 			h.mu.Unlock()
 
 		case client := <-h.unregister:
 			h.mu.Lock()
+			delete(h.conns, client)
 			if client.Username != "" {
-				if _, ok := h.clients[client.Username]; ok {
+				if current, ok := h.clients[client.Username]; ok && current == client {
 					delete(h.clients, client.Username)
 					// Clean up rooms this client was in
 					for roomID, users := range h.rooms {
@@ -64,15 +118,73 @@ func (h *Hub) Run() {
 							}
 						}
 					}
-					log.Printf("Client unregistered: %s", client.Username)
+					client.log.Info("client unregistered", "user", client.Username)
 				}
 			}
+			remaining := len(h.conns)
 			h.mu.Unlock()
 			close(client.send)
+			if draining && remaining == 0 {
+				h.idleOnce.Do(func() { close(h.idle) })
+			}
+
+		case reply := <-h.ping:
+			close(reply)
+
+		case <-done:
+			// Stop selecting on the closed channel; WritePumps see it too
+			// and close their connections, which come back as unregisters.
+			done = nil
+			draining = true
+			h.mu.RLock()
+			remaining := len(h.conns)
+			h.mu.RUnlock()
+			slog.Info("relay shutting down", "draining", remaining)
+			if remaining == 0 {
+				h.idleOnce.Do(func() { close(h.idle) })
+			}
 		}
 	}
 }
 
+// Ping reports whether the run loop is still servicing requests.
+func (h *Hub) Ping(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case h.ping <- reply:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Draining reports whether Shutdown has been called.
+func (h *Hub) Draining() bool {
+	select {
+	case <-h.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown tells every connection to flush its queue and go away, then
+// waits until they have all unregistered or ctx expires.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.shutdownOnce.Do(func() { close(h.done) })
+	select {
+	case <-h.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // JoinRoom adds a user to a deterministic room
 func (h *Hub) JoinRoom(roomID string, username string) {
 	h.mu.Lock()
@@ -83,6 +195,16 @@ func (h *Hub) JoinRoom(roomID string, username string) {
 	h.rooms[roomID][username] = true
 }
 
+// LeaveRoom removes a user from a room, dropping the room once empty
+func (h *Hub) LeaveRoom(roomID string, username string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.rooms[roomID], username)
+	if len(h.rooms[roomID]) == 0 {
+		delete(h.rooms, roomID)
+	}
+}
+
 // GetRoomUsers returns usernames in a room
 func (h *Hub) GetRoomUsers(roomID string) []string {
 	h.mu.RLock()
@@ -94,6 +216,51 @@ func (h *Hub) GetRoomUsers(roomID string) []string {
 	return users
 }
 
+// Sessions lists authenticated connections ordered by username
+func (h *Hub) Sessions() []Session {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	sessions := make([]Session, 0, len(h.clients))
+	for _, c := range h.clients {
+		sessions = append(sessions, Session{
+			ID:          c.ID,
+			Username:    c.Username,
+			RemoteAddr:  c.Conn.RemoteAddr().String(),
+			ConnectedAt: c.ConnectedAt,
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Username < sessions[j].Username })
+	return sessions
+}
+
+// Stats returns current connection and traffic counters
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return Stats{
+		Connections:    len(h.conns),
+		Sessions:       len(h.clients),
+		Rooms:          len(h.rooms),
+		PacketsRelayed: h.relayed.Load(),
+		StartedAt:      h.startedAt,
+	}
+}
+
+// Kick closes the session of username with a policy-violation close frame.
+// It reports whether such a session existed.
+func (h *Hub) Kick(username, reason string) bool {
+	client, ok := h.GetClient(username)
+	if !ok {
+		return false
+	}
+	// WriteControl is safe to call alongside the client's WritePump
+	client.Conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason), time.Now().Add(writeWait))
+	client.Conn.Close()
+	client.log.Warn("session kicked", "user", username, "reason", reason)
+	return true
+}
+
 // GetClient returns a client by username
 func (h *Hub) GetClient(username string) (*Client, bool) {
 	h.mu.RLock()
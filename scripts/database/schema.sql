@@ -4,10 +4,56 @@ CREATE TABLE IF NOT EXISTS users (
     username VARCHAR(50) UNIQUE NOT NULL,
     full_name VARCHAR(255) NOT NULL,
     public_key_hash VARCHAR(64) UNIQUE NOT NULL,
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    banned_at TIMESTAMP WITH TIME ZONE
 );
 
 -- Index for username for faster lookups
 CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
 -- Index for public_key_hash
 CREATE INDEX IF NOT EXISTS idx_users_pk_hash ON users(public_key_hash);
+
+-- Group conversations; the creator owns the group
+CREATE TABLE IF NOT EXISTS groups (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(64) NOT NULL,
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+    group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    joined_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, user_id)
+);
+
+-- Index for listing a user's groups
+CREATE INDEX IF NOT EXISTS idx_group_members_user ON group_members(user_id);
+
+-- Users each user refuses messages from
+CREATE TABLE IF NOT EXISTS blocks (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, blocked_id)
+);
+
+-- Group key agreement commits, one per epoch; the data is opaque to the relay
+CREATE TABLE IF NOT EXISTS group_commits (
+    group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    epoch BIGINT NOT NULL,
+    sender_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, epoch)
+);
+
+-- The latest welcome for each added member, kept until they leave
+CREATE TABLE IF NOT EXISTS group_welcomes (
+    group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    epoch BIGINT NOT NULL,
+    data BYTEA NOT NULL,
+    PRIMARY KEY (group_id, user_id)
+);
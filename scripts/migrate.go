@@ -20,13 +20,53 @@ func main() {
 
 	ctx := context.Background()
 
-	// Migration: Add public_key column
-	query := `ALTER TABLE users ADD COLUMN IF NOT EXISTS public_key TEXT;`
+	migrations := []struct {
+		name  string
+		query string
+	}{
+		{"ADD COLUMN public_key", `ALTER TABLE users ADD COLUMN IF NOT EXISTS public_key TEXT;`},
+		{"ADD COLUMN banned_at", `ALTER TABLE users ADD COLUMN IF NOT EXISTS banned_at TIMESTAMP WITH TIME ZONE;`},
+		{"CREATE TABLE groups", `CREATE TABLE IF NOT EXISTS groups (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			name VARCHAR(64) NOT NULL,
+			owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);`},
+		{"CREATE TABLE group_members", `CREATE TABLE IF NOT EXISTS group_members (
+			group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			joined_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (group_id, user_id)
+		);`},
+		{"CREATE INDEX idx_group_members_user", `CREATE INDEX IF NOT EXISTS idx_group_members_user ON group_members(user_id);`},
+		{"CREATE TABLE blocks", `CREATE TABLE IF NOT EXISTS blocks (
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			blocked_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, blocked_id)
+		);`},
+		{"CREATE TABLE group_commits", `CREATE TABLE IF NOT EXISTS group_commits (
+			group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+			epoch BIGINT NOT NULL,
+			sender_id UUID REFERENCES users(id) ON DELETE SET NULL,
+			data BYTEA NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (group_id, epoch)
+		);`},
+		{"CREATE TABLE group_welcomes", `CREATE TABLE IF NOT EXISTS group_welcomes (
+			group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			epoch BIGINT NOT NULL,
+			data BYTEA NOT NULL,
+			PRIMARY KEY (group_id, user_id)
+		);`},
+	}
 
-	fmt.Println("Running migration: ADD COLUMN public_key...")
-	_, err = db.Pool.Exec(ctx, query)
-	if err != nil {
-		log.Fatalf("Migration failed: %v", err)
+	for _, m := range migrations {
+		fmt.Printf("Running migration: %s...\n", m.name)
+		if _, err := db.Pool.Exec(ctx, m.query); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
 	}
 
 	fmt.Println("Migration successful!")